@@ -0,0 +1,271 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/1CL0UD/cloudm-cli/internal/config"
+	"github.com/1CL0UD/cloudm-cli/internal/filesystem"
+	"github.com/1CL0UD/cloudm-cli/internal/logger"
+	"github.com/1CL0UD/cloudm-cli/internal/postgres"
+	"github.com/spf13/cobra"
+)
+
+var appUserCmd = &cobra.Command{
+	Use:   "app-user",
+	Short: "Manage the credentials of the app user",
+}
+
+var appUserRotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Give the app user a new random password",
+	Long: `Generate a new random password for the app user, store it through
+target.app_user_secret and set it on the target. Sessions opened with the old
+password stay connected; they are listed so that they can be restarted.`,
+	RunE: runAppUserRotate,
+}
+
+func init() {
+	appUserCmd.AddCommand(appUserRotateCmd)
+}
+
+func runAppUserRotate(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	log, err := logger.New(logger.LoggerOptions{
+		Verbose: verbose,
+		LogFile: logFile,
+		NoColor: noColor,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to initialize logger: %w", err)
+	}
+	defer log.Close()
+
+	configPath := cfgFile
+	if configPath == "" {
+		configPath = "db.yaml"
+	}
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		log.Error("Failed to load configuration: %v", err)
+		return err
+	}
+	appUser := cfg.Target.AppUser
+
+	if err := postgres.TestTargetConnection(cfg.Target); err != nil {
+		log.Error("Failed to connect to target database: %v", err)
+		return err
+	}
+
+	exists, err := postgres.RoleExists(ctx, cfg.Target, appUser)
+	if err != nil {
+		log.Error("%v", err)
+		return err
+	}
+	if !exists {
+		log.Error("App user %s does not exist on the target", appUser)
+		return fmt.Errorf("app user %s does not exist", appUser)
+	}
+
+	if dryRun {
+		log.DryRun("Would set a new password for %s and store it in %s", appUser, appUserSecretLocation(cfg, cfg.Target))
+		sessions, err := postgres.ListRoleSessions(ctx, cfg.Target, appUser)
+		if err != nil {
+			log.Warning("Failed to list sessions of %s: %v", appUser, err)
+			return nil
+		}
+		if len(sessions) > 0 {
+			log.DryRun("%d sessions of %s would keep using the old password:", len(sessions), appUser)
+			logRoleSessions(log, sessions)
+		}
+		return nil
+	}
+
+	if err := checkProtected(cfg, cfg.Target); err != nil {
+		log.Error("%v", err)
+		return err
+	}
+
+	// The new password is staged next to the stored one before it is set,
+	// so that it can't be lost once it is in effect, and replaces the stored
+	// one only then, so that a failed change leaves that one valid
+	password := postgres.GeneratePassword()
+	target := cfg.Target
+	target.AppUserSecret = appUserSecret(cfg, target)
+	staged := stagedAppUserSecret(cfg, target)
+	if err := filesystem.WriteSecretFile(staged, password); err != nil {
+		log.Error("Failed to stage the new password, it was not changed: %v", err)
+		return err
+	}
+
+	sessions, err := postgres.RotatePassword(ctx, cfg.Target, appUser, password)
+	if err != nil {
+		os.Remove(staged)
+		log.Error("%v", err)
+		log.Info("The stored password was left in place and is still valid")
+		return err
+	}
+	log.Success("Password of %s rotated", appUser)
+
+	if err := commitAppUserSecret(ctx, target, staged, password, log); err != nil {
+		log.Error("Failed to store the new password: %v", err)
+		log.Warning("The new password is in effect and kept in %s; store it from there", staged)
+		return err
+	}
+	if cfg.Target.AppUserPassword != "" {
+		log.Warning("target.app_user_password still holds the old password; remove it or update it")
+	}
+
+	if len(sessions) == 0 {
+		log.Success("No sessions of %s were opened with the old password", appUser)
+		return nil
+	}
+	log.Warning("%d sessions of %s were opened with the old password and stay connected until they reconnect:", len(sessions), appUser)
+	logRoleSessions(log, sessions)
+
+	return nil
+}
+
+// ensureAppUser creates the app user when it doesn't exist. Unless
+// app_user_password is set, it gets a random password that is stored
+// through app_user_secret before the role is created, so that it can't be
+// lost.
+func ensureAppUser(ctx context.Context, cfg *config.Config, target config.TargetConfig, log *logger.Logger) error {
+	exists, err := postgres.RoleExists(ctx, target, target.AppUser)
+	if err != nil {
+		return err
+	}
+	if exists {
+		log.Debug("App user %s already exists", target.AppUser)
+		return nil
+	}
+
+	password := target.AppUserPassword
+	if password == "" {
+		password = postgres.GeneratePassword()
+		target.AppUserSecret = appUserSecret(cfg, target)
+		if err := storeAppUserSecret(ctx, target, password, log); err != nil {
+			return fmt.Errorf("failed to store password of %s: %w", target.AppUser, err)
+		}
+	}
+
+	if err := postgres.CreateAppUser(ctx, target, target.AppUser, password); err != nil {
+		return err
+	}
+	log.Success("Created app user %s", target.AppUser)
+	return nil
+}
+
+// appUserSecret returns the sinks of the app user password, defaulting to
+// a file under the output directory when none is configured
+func appUserSecret(cfg *config.Config, target config.TargetConfig) config.SecretConfig {
+	secret := target.AppUserSecret
+	if secret.File == "" && len(secret.Command) == 0 {
+		secret.File = filesystem.GetAppUserSecretPath(cfg.Options.OutputDir, target.AppUser)
+	}
+	return secret
+}
+
+// appUserSecretLocation describes where the app user finds its password
+func appUserSecretLocation(cfg *config.Config, target config.TargetConfig) string {
+	if target.AppUserPassword != "" {
+		return "target.app_user_password"
+	}
+	secret := appUserSecret(cfg, target)
+	var sinks []string
+	if secret.File != "" {
+		sinks = append(sinks, secret.File)
+	}
+	if len(secret.Command) > 0 {
+		sinks = append(sinks, "credential helper "+secret.Command[0])
+	}
+	return strings.Join(sinks, " and ")
+}
+
+// storeAppUserSecret hands the app user password to the sinks of
+// target.AppUserSecret
+func storeAppUserSecret(ctx context.Context, target config.TargetConfig, password string, log *logger.Logger) error {
+	secret := target.AppUserSecret
+	if secret.File != "" {
+		if err := filesystem.WriteSecretFile(secret.File, password); err != nil {
+			return err
+		}
+		log.Info("Password of %s written to %s", target.AppUser, secret.File)
+	}
+	if len(secret.Command) > 0 {
+		if err := runCredentialHelper(ctx, secret.Command, target, password); err != nil {
+			return err
+		}
+		log.Info("Password of %s stored by %s", target.AppUser, secret.Command[0])
+	}
+	return nil
+}
+
+// stagedAppUserSecret returns the file a new app user password is staged
+// in: next to the secret file, or next to the default one when only a
+// credential helper stores it
+func stagedAppUserSecret(cfg *config.Config, target config.TargetConfig) string {
+	path := target.AppUserSecret.File
+	if path == "" {
+		path = filesystem.GetAppUserSecretPath(cfg.Options.OutputDir, target.AppUser)
+	}
+	return filesystem.StagedSecretPath(path)
+}
+
+// commitAppUserSecret hands a staged app user password to the sinks of
+// target.AppUserSecret: the credential helper first, then the secret file,
+// which the staged file replaces. Without a secret file the staged one is
+// removed once stored. On failure the staged file is kept.
+func commitAppUserSecret(ctx context.Context, target config.TargetConfig, staged, password string, log *logger.Logger) error {
+	secret := target.AppUserSecret
+	if len(secret.Command) > 0 {
+		if err := runCredentialHelper(ctx, secret.Command, target, password); err != nil {
+			return err
+		}
+		log.Info("Password of %s stored by %s", target.AppUser, secret.Command[0])
+	}
+	if secret.File == "" {
+		os.Remove(staged)
+		return nil
+	}
+	if err := filesystem.CommitSecretFile(secret.File); err != nil {
+		return err
+	}
+	log.Info("Password of %s written to %s", target.AppUser, secret.File)
+	return nil
+}
+
+// runCredentialHelper runs a credential helper command with the password
+// on stdin and the role and target in its environment
+func runCredentialHelper(ctx context.Context, command []string, target config.TargetConfig, password string) error {
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Stdin = strings.NewReader(password + "\n")
+	cmd.Env = append(os.Environ(),
+		"CLOUDM_APP_USER="+target.AppUser,
+		"CLOUDM_TARGET_HOST="+target.Host,
+		"CLOUDM_TARGET_PORT="+strconv.Itoa(target.Port),
+		"CLOUDM_TARGET_DATABASE="+target.Database,
+	)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("credential helper %s failed: %w: %s", command[0], err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// logRoleSessions prints the sessions of a role with where and when they
+// were opened
+func logRoleSessions(log *logger.Logger, sessions []postgres.Session) {
+	for _, s := range sessions {
+		log.Info("  pid %d: database=%s client=%s application=%q started=%s (%s ago)",
+			s.PID, s.Database, s.ClientAddr, s.ApplicationName,
+			s.Started.Format("2006-01-02 15:04:05"), time.Since(s.Started).Round(time.Second))
+	}
+}
\ No newline at end of file
@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/1CL0UD/cloudm-cli/internal/config"
+	"github.com/1CL0UD/cloudm-cli/internal/encryption"
+	"github.com/1CL0UD/cloudm-cli/internal/filesystem"
+	"github.com/1CL0UD/cloudm-cli/internal/logger"
+)
+
+// dumpInput is a dump file ready to be read by pg_restore
+type dumpInput struct {
+	Path      string
+	Decryptor *encryption.Decryptor
+	cleanup   func()
+}
+
+// Close removes any plaintext copy made of the dump
+func (d dumpInput) Close() {
+	if d.cleanup != nil {
+		d.cleanup()
+	}
+}
+
+// artifactEncryptor returns the encryptor for dumps and backups, or nil when
+// encryption is disabled. It fails when encryption is required by the
+// configuration but not usable.
+func artifactEncryptor(cfg *config.Config, log *logger.Logger) (*encryption.Encryptor, error) {
+	if err := config.ValidateEncryption(cfg); err != nil {
+		return nil, err
+	}
+
+	enc, err := encryption.NewEncryptor(cfg.Options.Encryption)
+	if err != nil {
+		return nil, err
+	}
+
+	if enc != nil {
+		log.Info("Artifacts will be encrypted (%d recipients, passphrase: %t)",
+			len(cfg.Options.Encryption.Recipients), cfg.Options.Encryption.Passphrase != "")
+	}
+	return enc, nil
+}
+
+// openDumpInput prepares a dump file for pg_restore. Encrypted dumps are
+// decrypted into a file readable by the owner only, next to the dump rather
+// than in the shared temporary directory, so that parallel restore keeps
+// working and, when seekable is set, entries can be restored one by one.
+// Otherwise, with a single job, or when encryption is required, they are
+// streamed to pg_restore and never written to disk in plaintext.
+func openDumpInput(path string, cfg *config.Config, dec *encryption.Decryptor, seekable bool, log *logger.Logger) (dumpInput, error) {
+	encrypted, err := encryption.IsEncrypted(path)
+	if err != nil {
+		return dumpInput{}, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if !encrypted {
+		if cfg.Options.Encryption.Required {
+			log.Warning("%s is not encrypted although encryption is required", path)
+		}
+		return dumpInput{Path: path}, nil
+	}
+
+	if dec == nil {
+		if dec, err = encryption.NewDecryptor(cfg.Options.Encryption); err != nil {
+			return dumpInput{}, err
+		}
+	}
+
+	if cfg.Options.Encryption.Required {
+		log.Info("Streaming encrypted %s to pg_restore (parallel jobs disabled)", filepath.Base(path))
+		return dumpInput{Path: path, Decryptor: dec}, nil
+	}
+	if !seekable && max(cfg.Options.ParallelJobs, cfg.Options.DataParallelJobs, cfg.Options.PostDataParallelJobs) <= 1 {
+		log.Info("Streaming encrypted %s to pg_restore", filepath.Base(path))
+		return dumpInput{Path: path, Decryptor: dec}, nil
+	}
+
+	// CreateTemp creates the file with mode 0600
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".plain-*-"+filepath.Base(path))
+	if err != nil {
+		return dumpInput{}, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	tmp.Close()
+	cleanup := func() { os.Remove(tmp.Name()) }
+
+	log.Info("Decrypting %s...", filepath.Base(path))
+	if err := dec.DecryptFile(path, tmp.Name()); err != nil {
+		cleanup()
+		return dumpInput{}, err
+	}
+
+	return dumpInput{Path: tmp.Name(), cleanup: cleanup}, nil
+}
+
+// uploadArtifacts copies the files of a migration directory to the
+// configured storage backend, under the directory's timestamp. Files named
+// in done are skipped; those uploaded are added to it when it isn't nil.
+func uploadArtifacts(ctx context.Context, cfg *config.Config, migrationDir string, done map[string]bool, log *logger.Logger) error {
+	if cfg.Options.Storage.URL == "" {
+		return nil
+	}
+
+	st, err := filesystem.NewStorage(cfg.Options.Storage.URL, cfg.Options.Storage)
+	if err != nil {
+		return err
+	}
+
+	prefix := filepath.Base(migrationDir)
+	log.Info("Uploading artifacts to %s...", st.Location(prefix))
+	uploaded, err := filesystem.UploadDir(ctx, st, migrationDir, prefix, done)
+	if err != nil {
+		return err
+	}
+
+	log.Success("Uploaded %d files to %s", len(uploaded), st.Location(prefix))
+	return nil
+}
+
+// fetchRemoteInput downloads the dumps of a remote migration directory into
+// a local temporary directory. It returns the storage to write results back
+// to and the local directory, which the caller removes when done.
+func fetchRemoteInput(ctx context.Context, cfg *config.Config, location string, log *logger.Logger) (filesystem.Storage, string, error) {
+	st, err := filesystem.NewStorage(location, cfg.Options.Storage)
+	if err != nil {
+		return nil, "", err
+	}
+
+	localDir, err := os.MkdirTemp("", "cloudm-restore-")
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+
+	log.Info("Downloading dumps from %s...", st.Location(""))
+	files, err := filesystem.FetchDumps(ctx, st, localDir)
+	if err != nil {
+		os.RemoveAll(localDir)
+		return nil, "", err
+	}
+	for _, f := range files {
+		size, _ := filesystem.GetFileSize(f)
+		log.Info("Downloaded %s (%s)", filepath.Base(f), size)
+	}
+
+	return st, localDir, nil
+}
@@ -24,6 +24,7 @@ func init() {
 }
 
 func runBackup(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
 	startTime := time.Now()
 
 	// Initialize logger
@@ -56,6 +57,13 @@ func runBackup(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("target database configuration is incomplete")
 	}
 
+	// Set up artifact encryption
+	enc, err := artifactEncryptor(cfg, log)
+	if err != nil {
+		log.Error("Encryption setup failed: %v", err)
+		return err
+	}
+
 	// Initialize executor
 	exec := executor.New(log, dryRun)
 
@@ -105,14 +113,25 @@ func runBackup(cmd *cobra.Command, args []string) error {
 		cfg.Target.AdminPassword,
 		cfg.Target.Database,
 		backupFile,
+		enc,
 	); err != nil {
 		log.Error("Backup failed: %v", err)
 		return err
 	}
 
+	if err := filesystem.WriteBackupInfo(backupDir, postgres.RestoreTarget(cfg.Target)); err != nil {
+		log.Warning("Failed to record backup info: %v", err)
+	}
+
 	// Get file size
 	size, _ := filesystem.GetFileSize(backupFile)
 
+	// Upload to remote storage if configured
+	if err := uploadArtifacts(ctx, cfg, backupDir, nil, log); err != nil {
+		log.Error("Upload failed: %v", err)
+		return err
+	}
+
 	log.Success("Backup completed in %s", time.Since(startTime).Round(time.Second))
 	log.Info("Backup file: %s (%s)", backupFile, size)
 
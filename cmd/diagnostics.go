@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"github.com/1CL0UD/cloudm-cli/internal/logger"
+	"github.com/1CL0UD/cloudm-cli/internal/postgres"
+)
+
+// logDiagnostics prints the summary of the pg_restore diagnostics of a phase
+func logDiagnostics(log *logger.Logger, phase string, diag *postgres.DiagnosticLog) {
+	summary := diag.Summary()
+	if summary.Errors+summary.Warnings+summary.Ignored == 0 {
+		return
+	}
+
+	log.Info("%s diagnostics: %d errors, %d warnings, %d ignored",
+		phase, summary.Errors, summary.Warnings, summary.Ignored)
+	for _, category := range postgres.SortedKeys(summary.ByCategory) {
+		log.Info("  %-30s %d", category, summary.ByCategory[category])
+	}
+	for _, reason := range postgres.SortedKeys(summary.ByReason) {
+		log.Info("  ignored: %s (%d)", reason, summary.ByReason[reason])
+	}
+
+	for _, d := range diag.Diagnostics() {
+		switch {
+		case d.Ignored:
+			log.Debug("  %s (ignored: %s)", d, d.Reason)
+		case d.Severity == postgres.SeverityWarning:
+			log.Warning("  %s", d)
+		default:
+			log.Error("  %s", d)
+			if d.Command != "" {
+				log.Debug("    command: %s", d.Command)
+			}
+		}
+	}
+}
@@ -32,6 +32,7 @@ func init() {
 }
 
 func runDump(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
 	startTime := time.Now()
 
 	// Initialize logger
@@ -64,6 +65,13 @@ func runDump(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("source database configuration is incomplete")
 	}
 
+	// Set up artifact encryption
+	enc, err := artifactEncryptor(cfg, log)
+	if err != nil {
+		log.Error("Encryption setup failed: %v", err)
+		return err
+	}
+
 	// Initialize executor
 	exec := executor.New(log, dryRun)
 
@@ -81,6 +89,23 @@ func runDump(cmd *cobra.Command, args []string) error {
 	}
 	log.Success("Connected to source database: %s/%s", cfg.Source.Host, cfg.Source.Database)
 
+	// Detect large objects, which are not covered by the schema-restricted dump
+	includeLargeObjects := false
+	if !structureOnly {
+		loStats, err := postgres.GetLargeObjectStats(ctx, cfg.Source)
+		if err != nil {
+			log.Warning("Failed to inspect source large objects: %v", err)
+		} else if loStats.Count > 0 {
+			if cfg.Options.SkipLargeObjects {
+				log.Warning("Source has %d large objects but skip_large_objects is set; they will not be dumped", loStats.Count)
+			} else {
+				includeLargeObjects = true
+				log.Info("Source has %d large objects (%d bytes); they will be included in the data dump",
+					loStats.Count, loStats.TotalBytes)
+			}
+		}
+	}
+
 	if dryRun {
 		log.DryRun("Dry run mode - no changes will be made")
 		log.Success("Dry run completed successfully")
@@ -117,6 +142,7 @@ func runDump(cmd *cobra.Command, args []string) error {
 			Database:   cfg.Source.Database,
 			Schema:     "public",
 			OutputFile: structureDump,
+			Encryptor:  enc,
 		}); err != nil {
 			log.Error("Structure dump failed: %v", err)
 			return err
@@ -138,6 +164,8 @@ func runDump(cmd *cobra.Command, args []string) error {
 			Schema:        "public",
 			OutputFile:    dataDump,
 			ExcludeTables: cfg.Options.ExcludeTables,
+			LargeObjects:  includeLargeObjects,
+			Encryptor:     enc,
 		}); err != nil {
 			log.Error("Data dump failed: %v", err)
 			return err
@@ -147,6 +175,12 @@ func runDump(cmd *cobra.Command, args []string) error {
 		log.Success("Data dump completed: %s (%s)", dataDump, size)
 	}
 
+	// Upload to remote storage if configured
+	if err := uploadArtifacts(ctx, cfg, migrationDir, nil, log); err != nil {
+		log.Error("Upload failed: %v", err)
+		return err
+	}
+
 	log.Success("Dump completed in %s", time.Since(startTime).Round(time.Second))
 	log.Info("Files saved to: %s", migrationDir)
 
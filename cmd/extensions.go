@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/1CL0UD/cloudm-cli/internal/config"
+	"github.com/1CL0UD/cloudm-cli/internal/logger"
+	"github.com/1CL0UD/cloudm-cli/internal/postgres"
+)
+
+// resolveExtensions works out the extensions to create on the target: those
+// installed on the source when discover is set, adjusted by the configured
+// entries. Extensions the target server cannot provide are reported and
+// fail the run, before anything on the target is dropped.
+func resolveExtensions(ctx context.Context, cfg *config.Config, discover bool, log *logger.Logger) ([]postgres.Extension, error) {
+	var source []postgres.Extension
+	if discover {
+		var err error
+		if source, err = postgres.GetExtensions(ctx, cfg.Source); err != nil {
+			return nil, fmt.Errorf("failed to discover source extensions: %w", err)
+		}
+		log.Info("Found %d extensions on the source", len(source))
+	}
+
+	wanted := postgres.MergeExtensions(source, cfg.Options.Extensions)
+	if len(wanted) == 0 {
+		return nil, nil
+	}
+
+	checks, err := postgres.CheckExtensions(ctx, cfg.Target, wanted)
+	if err != nil {
+		return nil, err
+	}
+
+	var extensions []postgres.Extension
+	var missing, restricted []string
+	for _, c := range checks {
+		ext := c.Extension
+		switch {
+		case !c.Available:
+			log.Error("Extension %s is not available on the target server", c.Name)
+			missing = append(missing, c.Name)
+			continue
+		case c.Restricted != "":
+			log.Error("Extension %s cannot be created by %s: %s", c.Name, cfg.Target.AdminUser, c.Restricted)
+			restricted = append(restricted, c.Name)
+			continue
+		case c.VersionMismatch():
+			log.Warning("Extension %s version %s is not available on the target; the default version %s will be created",
+				c.Name, c.Version, c.DefaultVersion)
+			ext.Version = ""
+		case c.InstalledVersion != "" && c.Version != "" && c.InstalledVersion != c.Version:
+			log.Warning("Extension %s is already installed on the target in version %s (source: %s); it is kept",
+				c.Name, c.InstalledVersion, c.Version)
+		default:
+			log.Debug("Extension %s %s available on the target", c.Name, c.Version)
+		}
+		extensions = append(extensions, ext)
+	}
+
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("extensions not available on the target server: %s (install them or mark them skip: true in options.extensions)",
+			strings.Join(missing, ", "))
+	}
+	if len(restricted) > 0 {
+		return nil, fmt.Errorf("%s may not create extensions on the target server: %s (allow them on the server, create them beforehand, or mark them skip: true in options.extensions)",
+			cfg.Target.AdminUser, strings.Join(restricted, ", "))
+	}
+
+	log.Success("%d extensions available on the target", len(extensions))
+	return extensions, nil
+}
@@ -0,0 +1,212 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/1CL0UD/cloudm-cli/internal/config"
+	"github.com/1CL0UD/cloudm-cli/internal/encryption"
+	"github.com/1CL0UD/cloudm-cli/internal/filesystem"
+	"github.com/1CL0UD/cloudm-cli/internal/logger"
+	"github.com/1CL0UD/cloudm-cli/internal/postgres"
+	"github.com/spf13/cobra"
+)
+
+var (
+	inspectFile    string
+	inspectTypes   []string
+	inspectSchema  string
+	inspectName    string
+	inspectOwner   string
+	inspectSection string
+	inspectCount   bool
+	inspectFormat  string
+)
+
+var inspectCmd = &cobra.Command{
+	Use:   "inspect",
+	Short: "Inspect dump contents",
+	Long:  `List the catalog entries of the dump files in a migration directory without restoring them`,
+	RunE:  runInspect,
+}
+
+func init() {
+	inspectCmd.Flags().StringVarP(&inputDir, "input", "i", "", "input directory containing dump files (required)")
+	inspectCmd.Flags().StringVar(&inspectFile, "file", "", "inspect only this dump (structure, data or backup)")
+	inspectCmd.Flags().StringSliceVar(&inspectTypes, "type", nil, "filter by entry type, e.g. \"TABLE DATA\" (repeatable)")
+	inspectCmd.Flags().StringVar(&inspectSchema, "schema", "", "filter by schema")
+	inspectCmd.Flags().StringVar(&inspectName, "name", "", "filter by object name (supports * and ? wildcards)")
+	inspectCmd.Flags().StringVar(&inspectOwner, "owner", "", "filter by owner (supports * and ? wildcards)")
+	inspectCmd.Flags().StringVar(&inspectSection, "section", "", "filter by section (pre-data, data, post-data)")
+	inspectCmd.Flags().BoolVar(&inspectCount, "count", false, "print entry counts per type instead of entries")
+	inspectCmd.Flags().StringVar(&inspectFormat, "format", "table", "output format (table, json)")
+	inspectCmd.MarkFlagRequired("input")
+}
+
+// dumpInspection holds the filtered catalog of one dump file
+type dumpInspection struct {
+	File    string              `json:"file"`
+	Entries []postgres.TOCEntry `json:"entries,omitempty"`
+	Counts  map[string]int      `json:"counts,omitempty"`
+	Total   int                 `json:"total"`
+
+	types []string
+}
+
+func runInspect(cmd *cobra.Command, args []string) error {
+	// Initialize logger
+	log, err := logger.New(logger.LoggerOptions{
+		Verbose: verbose,
+		LogFile: logFile,
+		NoColor: noColor,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to initialize logger: %w", err)
+	}
+	defer log.Close()
+
+	if inspectFormat != "table" && inspectFormat != "json" {
+		log.Error("Unsupported format %q (expected table or json)", inspectFormat)
+		return fmt.Errorf("unsupported format: %s", inspectFormat)
+	}
+
+	switch inspectSection {
+	case "", postgres.SectionPreData, postgres.SectionData, postgres.SectionPostData:
+	default:
+		log.Error("Unsupported section %q (expected pre-data, data or post-data)", inspectSection)
+		return fmt.Errorf("unsupported section: %s", inspectSection)
+	}
+
+	files, err := selectInspectFiles(inputDir, inspectFile)
+	if err != nil {
+		log.Error("%v", err)
+		return err
+	}
+
+	filter := postgres.TOCFilter{
+		Types:   inspectTypes,
+		Schema:  inspectSchema,
+		Name:    inspectName,
+		Owner:   inspectOwner,
+		Section: inspectSection,
+	}
+
+	var results []dumpInspection
+	var dec *encryption.Decryptor
+	for _, file := range files {
+		encrypted, err := encryption.IsEncrypted(file)
+		if err != nil {
+			log.Error("Failed to read %s: %v", file, err)
+			return err
+		}
+		if encrypted && dec == nil {
+			if dec, err = configDecryptor(); err != nil {
+				log.Error("Cannot decrypt %s: %v", file, err)
+				return err
+			}
+		}
+
+		entries, err := postgres.ReadTOC(file, dec)
+		if err != nil {
+			log.Error("Failed to read %s: %v", file, err)
+			return err
+		}
+
+		matched := postgres.FilterTOC(entries, filter)
+		result := dumpInspection{File: file, Total: len(matched)}
+		if inspectCount {
+			result.types, result.Counts = postgres.CountTOCByType(matched)
+		} else {
+			result.Entries = matched
+		}
+		results = append(results, result)
+	}
+
+	if inspectFormat == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	}
+
+	for _, result := range results {
+		printInspection(result)
+	}
+
+	return nil
+}
+
+// configDecryptor loads the configuration only to obtain the keys needed
+// for encrypted dumps
+func configDecryptor() (*encryption.Decryptor, error) {
+	configPath := cfgFile
+	if configPath == "" {
+		configPath = "db.yaml"
+	}
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return nil, err
+	}
+	return encryption.NewDecryptor(cfg.Options.Encryption)
+}
+
+// selectInspectFiles resolves which dump files of a directory to inspect
+func selectInspectFiles(dir, only string) ([]string, error) {
+	if only == "" {
+		files := filesystem.ListDumpFiles(dir)
+		if len(files) == 0 {
+			return nil, fmt.Errorf("no dump files found in %s", dir)
+		}
+		return files, nil
+	}
+
+	structure, data := filesystem.GetDumpPaths(dir)
+	var file string
+	switch only {
+	case "structure":
+		file = structure
+	case "data":
+		file = data
+	case "backup":
+		file = filesystem.GetBackupPath(dir)
+	default:
+		return nil, fmt.Errorf("unknown dump %q (expected structure, data or backup)", only)
+	}
+
+	if !filesystem.FileExists(file) {
+		return nil, fmt.Errorf("dump file not found: %s", file)
+	}
+	return []string{file}, nil
+}
+
+// printInspection prints one dump's entries or counts as a table
+func printInspection(result dumpInspection) {
+	fmt.Printf("== %s (%d entries) ==\n", filepath.Base(result.File), result.Total)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if inspectCount {
+		fmt.Fprintln(w, "TYPE\tCOUNT")
+		for _, t := range result.types {
+			fmt.Fprintf(w, "%s\t%d\n", t, result.Counts[t])
+		}
+	} else {
+		fmt.Fprintln(w, "ID\tTYPE\tSCHEMA\tNAME\tOWNER\tSECTION")
+		for _, e := range result.Entries {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n",
+				e.DumpID, e.Type, dashIfEmpty(e.Schema), e.Name, dashIfEmpty(e.Owner), e.Section)
+		}
+	}
+	w.Flush()
+	fmt.Println()
+}
+
+// dashIfEmpty renders empty table cells as "-"
+func dashIfEmpty(s string) string {
+	if strings.TrimSpace(s) == "" {
+		return "-"
+	}
+	return s
+}
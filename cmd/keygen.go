@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/1CL0UD/cloudm-cli/internal/encryption"
+	"github.com/spf13/cobra"
+)
+
+var keyOutput string
+
+var keygenCmd = &cobra.Command{
+	Use:   "keygen",
+	Short: "Generate an encryption key pair",
+	Long:  `Generate a key pair for options.encryption: the public recipient goes into the config, the identity file is needed to decrypt`,
+	RunE:  runKeygen,
+}
+
+func init() {
+	keygenCmd.Flags().StringVarP(&keyOutput, "output", "o", "", "file to write the identity to (required)")
+	keygenCmd.MarkFlagRequired("output")
+}
+
+func runKeygen(cmd *cobra.Command, args []string) error {
+	if _, err := os.Stat(keyOutput); err == nil {
+		return fmt.Errorf("refusing to overwrite existing identity file: %s", keyOutput)
+	}
+
+	identity, recipient, err := encryption.GenerateKeyPair()
+	if err != nil {
+		return err
+	}
+
+	content := fmt.Sprintf("# recipient: %s\n%s\n", recipient, identity)
+	if err := os.WriteFile(keyOutput, []byte(content), 0600); err != nil {
+		return fmt.Errorf("failed to write identity file: %w", err)
+	}
+
+	fmt.Printf("Identity written to %s\n", keyOutput)
+	fmt.Printf("Recipient: %s\n", recipient)
+	return nil
+}
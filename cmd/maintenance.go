@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"context"
+	"time"
+
+	"github.com/1CL0UD/cloudm-cli/internal/config"
+	"github.com/1CL0UD/cloudm-cli/internal/logger"
+	"github.com/1CL0UD/cloudm-cli/internal/postgres"
+)
+
+// runMaintenance runs the configured post-restore steps on the target and
+// returns their timings. Failures are reported as warnings since the data
+// is already in place.
+func runMaintenance(ctx context.Context, opts config.MaintenanceConfig, target config.TargetConfig, log *logger.Logger) []logger.PhaseReport {
+	var phases []logger.PhaseReport
+
+	if opts.ResetSequences {
+		start := time.Now()
+		log.Info("Resetting sequences to their column values...")
+		resets, err := postgres.ResetSequences(ctx, target, "public")
+		for _, r := range resets {
+			log.Debug("  %s: %d -> %d (%s)", r.Sequence, r.From, r.To, r.Column)
+		}
+		if err != nil {
+			log.Warning("Sequence reset failed: %v", err)
+		} else {
+			log.Success("Reset %d sequences in %s", len(resets), time.Since(start).Round(time.Millisecond))
+		}
+		phases = append(phases, logger.PhaseReport{Name: "Maintenance: reset sequences", Duration: time.Since(start)})
+	}
+
+	if opts.RefreshMaterializedViews {
+		start := time.Now()
+		log.Info("Refreshing materialized views...")
+		refreshed, err := postgres.RefreshMaterializedViews(ctx, target, "public")
+		for _, name := range refreshed {
+			log.Debug("  refreshed %s", name)
+		}
+		if err != nil {
+			log.Warning("Materialized view refresh failed: %v", err)
+		} else {
+			log.Success("Refreshed %d materialized views in %s", len(refreshed), time.Since(start).Round(time.Millisecond))
+		}
+		phases = append(phases, logger.PhaseReport{Name: "Maintenance: refresh materialized views", Duration: time.Since(start)})
+	}
+
+	if opts.Analyze || opts.Vacuum {
+		start := time.Now()
+		name := "ANALYZE"
+		if opts.Vacuum {
+			name = "VACUUM (ANALYZE)"
+		}
+		log.Info("Running %s...", name)
+		if err := postgres.AnalyzeDatabase(ctx, target, opts.Vacuum); err != nil {
+			log.Warning("%s failed: %v", name, err)
+		} else {
+			log.Success("%s completed in %s", name, time.Since(start).Round(time.Millisecond))
+		}
+		phases = append(phases, logger.PhaseReport{Name: "Maintenance: " + name, Duration: time.Since(start)})
+	}
+
+	return phases
+}
+
+// maintenanceEnabled reports whether any post-restore step is configured
+func maintenanceEnabled(opts config.MaintenanceConfig) bool {
+	return opts.Analyze || opts.Vacuum || opts.RefreshMaterializedViews || opts.ResetSequences
+}
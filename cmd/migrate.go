@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/1CL0UD/cloudm-cli/internal/config"
+	"github.com/1CL0UD/cloudm-cli/internal/encryption"
 	"github.com/1CL0UD/cloudm-cli/internal/filesystem"
 	"github.com/1CL0UD/cloudm-cli/internal/logger"
 	"github.com/1CL0UD/cloudm-cli/internal/postgres"
@@ -29,8 +30,8 @@ func init() {
 	migrateCmd.Flags().BoolVar(&skipBackup, "skip-backup", false, "skip pre-migration backup")
 }
 
-func runMigrate(cmd *cobra.Command, args []string) error {
-	ctx := context.Background()
+func runMigrate(cmd *cobra.Command, args []string) (err error) {
+	ctx := cmd.Context()
 	startTime := time.Now()
 
 	// Initialize logger
@@ -63,6 +64,21 @@ func runMigrate(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	// Set up artifact encryption. The session key lets this run restore the
+	// dumps it encrypts without access to the recipients' identities.
+	enc, err := artifactEncryptor(cfg, log)
+	if err != nil {
+		log.Error("Encryption setup failed: %v", err)
+		return err
+	}
+	var dec *encryption.Decryptor
+	if enc != nil {
+		if dec, err = enc.WithSessionKey(); err != nil {
+			log.Error("Encryption setup failed: %v", err)
+			return err
+		}
+	}
+
 	// Initialize executor
 	exec := executor.New(log, dryRun)
 
@@ -87,12 +103,57 @@ func runMigrate(cmd *cobra.Command, args []string) error {
 	}
 	log.Success("Connected to target database: %s/%s", cfg.Target.Host, cfg.Target.Database)
 
+	// Refuse targets that must never be wiped, including the source itself
+	if err := checkProtected(cfg, cfg.Target); err != nil {
+		log.Error("%v", err)
+		return err
+	}
+	if err := checkNotSource(ctx, cfg); err != nil {
+		log.Error("%v", err)
+		return err
+	}
+	if _, err := postgres.CompileGrants(cfg.Grants); err != nil {
+		log.Error("Invalid grants: %v", err)
+		return err
+	}
+
+	// Check that the target server provides the extensions of the source
+	// before anything on it is dropped
+	extensions, err := resolveExtensions(ctx, cfg, true, log)
+	if err != nil {
+		log.Error("Extension check failed: %v", err)
+		return err
+	}
+
+	// Detect large objects, which are not covered by the schema-restricted dumps
+	includeLargeObjects := false
+	sourceLargeObjects, err := postgres.GetLargeObjectStats(ctx, cfg.Source)
+	if err != nil {
+		log.Warning("Failed to inspect source large objects: %v", err)
+	} else if sourceLargeObjects.Count > 0 {
+		if cfg.Options.SkipLargeObjects {
+			log.Warning("Source has %d large objects but skip_large_objects is set; they will not be migrated", sourceLargeObjects.Count)
+		} else {
+			includeLargeObjects = true
+			log.Info("Source has %d large objects (%d bytes); they will be included in the data dump",
+				sourceLargeObjects.Count, sourceLargeObjects.TotalBytes)
+		}
+	}
+
 	if dryRun {
 		log.DryRun("Dry run mode - no changes will be made")
+		if err := showOwnershipPlan(ctx, cfg, log); err != nil {
+			log.Warning("Failed to plan ownership: %v", err)
+		}
 		log.Success("Dry run completed successfully")
 		return nil
 	}
 
+	if err := confirmTarget(cfg.Target, "migrate into", assumeYes); err != nil {
+		log.Error("%v", err)
+		return err
+	}
+
 	// Create migration directory
 	outputDir := cfg.Options.OutputDir
 	if outputDir == "" {
@@ -111,6 +172,50 @@ func runMigrate(cmd *cobra.Command, args []string) error {
 
 	var phases []logger.PhaseReport
 	var files []string
+	uploaded := make(map[string]bool)
+
+	// On failure after the target was modified, roll it back to the backup
+	// if configured, and record the outcome in the timing report. Files
+	// written since the upload before the target was modified, such as logs
+	// and reports, are uploaded whether the migration succeeded or not.
+	backupTaken := false
+	targetModified := false
+	reopenTarget := func() {}
+	defer func() {
+		// Let sessions back in only after any rollback
+		defer reopenTarget()
+
+		// Roll back and upload even when the migration was interrupted
+		ctx := context.WithoutCancel(ctx)
+
+		if err != nil {
+			report := logger.MigrationReport{
+				StartTime:    startTime,
+				Phases:       phases,
+				Files:        files,
+				ErrorMessage: err.Error(),
+			}
+			if targetModified && cfg.Options.RollbackOnFailure {
+				if backupTaken {
+					report.Rollback = rollbackTarget(ctx, cfg, filesystem.GetBackupPath(migrationDir), dec, log)
+				} else {
+					log.Warning("Cannot roll back: no pre-migration backup was taken")
+				}
+			}
+			report.EndTime = time.Now()
+
+			if reportErr := logger.GenerateReport(report, filepath.Join(migrationDir, "migration_time.txt")); reportErr != nil {
+				log.Warning("Failed to generate timing report: %v", reportErr)
+			}
+		}
+
+		if uploadErr := uploadArtifacts(ctx, cfg, migrationDir, uploaded, log); uploadErr != nil {
+			log.Error("Upload failed: %v", uploadErr)
+			if err == nil {
+				err = uploadErr
+			}
+		}
+	}()
 
 	// Phase 0: Backup target (unless skipped)
 	if !skipBackup && !cfg.Options.SkipBackup {
@@ -125,16 +230,25 @@ func runMigrate(cmd *cobra.Command, args []string) error {
 			cfg.Target.AdminPassword,
 			cfg.Target.Database,
 			backupFile,
+			enc,
 		); err != nil {
 			log.Error("Backup failed: %v", err)
 			return err
 		}
 
+		if err := filesystem.WriteBackupInfo(migrationDir, postgres.RestoreTarget(cfg.Target)); err != nil {
+			log.Warning("Failed to record backup info: %v", err)
+		}
+
 		phases = append(phases, logger.PhaseReport{Name: "Backup", Duration: time.Since(backupStart)})
 		files = append(files, backupFile)
+		backupTaken = true
 		log.Success("Backup completed: %s", backupFile)
 	} else {
 		log.Info("Skipping backup (--skip-backup flag or config)")
+		if cfg.Options.RollbackOnFailure {
+			log.Warning("rollback_on_failure has no effect without a pre-migration backup")
+		}
 	}
 
 	// Phase 1: Dump from source
@@ -153,6 +267,7 @@ func runMigrate(cmd *cobra.Command, args []string) error {
 		Database:   cfg.Source.Database,
 		Schema:     "public",
 		OutputFile: structureDump,
+		Encryptor:  enc,
 	}); err != nil {
 		log.Error("Structure dump failed: %v", err)
 		return err
@@ -171,6 +286,8 @@ func runMigrate(cmd *cobra.Command, args []string) error {
 		Schema:        "public",
 		OutputFile:    dataDump,
 		ExcludeTables: cfg.Options.ExcludeTables,
+		LargeObjects:  includeLargeObjects,
+		Encryptor:     enc,
 	}); err != nil {
 		log.Error("Data dump failed: %v", err)
 		return err
@@ -180,70 +297,170 @@ func runMigrate(cmd *cobra.Command, args []string) error {
 
 	phases = append(phases, logger.PhaseReport{Name: "Dump", Duration: time.Since(dumpStart)})
 
+	// Upload the backup and dumps before the target is modified, so that
+	// they outlive a runner lost during the migration
+	if err := uploadArtifacts(ctx, cfg, migrationDir, uploaded, log); err != nil {
+		log.Error("Upload failed: %v", err)
+		return err
+	}
+
+	// Read the privileges of the dump and check their roles before the
+	// target is modified
+	privileges, err := dumpPrivileges(ctx, cfg, cfg.Target, cfg.Options.Privileges, structureDump, dec, nil, log)
+	if err != nil {
+		log.Error("Privilege check failed: %v", err)
+		return err
+	}
+
 	// Phase 2: Prepare and restore to target
 	log.Phase("STEP 2: Clean & restore to target database")
 	restoreStart := time.Now()
 
-	// Prepare target (terminate connections, drop/recreate schema, create extensions)
+	// Close sessions on the target, then prepare it (drop/recreate schema,
+	// create extensions)
+	var blocked []postgres.ConnectGrant
+	if reopenTarget, blocked, err = drainTarget(ctx, cfg, cfg.Target, log); err != nil {
+		log.Error("Failed to close sessions on target: %v", err)
+		return err
+	}
+
 	log.Info("Preparing target database...")
-	if err := postgres.PrepareTarget(ctx, cfg.Target, cfg.Options.Extensions); err != nil {
+	targetModified = true
+	if err := postgres.PrepareTarget(ctx, cfg.Target, extensions); err != nil {
 		log.Error("Failed to prepare target: %v", err)
 		return err
 	}
 	log.Success("Target prepared (schema recreated, extensions created)")
 
-	// Restore structure
-	log.Info("Restoring database structure (parallel jobs: %d)...", cfg.Options.ParallelJobs)
-	if err := postgres.RestoreStructure(postgres.RestoreOptions{
+	// Classify pg_restore output with the configured ignore rules
+	ignoreRules, err := postgres.CompileIgnoreRules(cfg.Options.RestoreIgnore)
+	if err != nil {
+		log.Error("Invalid restore ignore rules: %v", err)
+		return err
+	}
+
+	// Prepare dumps for reading, decrypting them if needed
+	structureInput, err := openDumpInput(structureDump, cfg, dec, false, log)
+	if err != nil {
+		log.Error("Failed to open structure dump: %v", err)
+		return err
+	}
+	defer structureInput.Close()
+
+	dataInput, err := openDumpInput(dataDump, cfg, dec, false, log)
+	if err != nil {
+		log.Error("Failed to open data dump: %v", err)
+		return err
+	}
+	defer dataInput.Close()
+
+	phases = append(phases, logger.PhaseReport{Name: "Prepare target", Duration: time.Since(restoreStart)})
+
+	// Restore pre-data: tables, types, functions and other schema objects
+	// needed to load data, without indexes and constraints
+	sectionStart := time.Now()
+	log.Info("Restoring pre-data section (parallel jobs: %d)...", cfg.Options.ParallelJobs)
+	preDataDiag := postgres.NewDiagnosticLog(ignoreRules)
+	err = postgres.RestoreSection(postgres.RestoreOptions{
 		Host:         cfg.Target.Host,
 		Port:         cfg.Target.Port,
 		User:         cfg.Target.AdminUser,
 		Password:     cfg.Target.AdminPassword,
 		Database:     cfg.Target.Database,
 		Schema:       "public",
-		InputFile:    structureDump,
+		InputFile:    structureInput.Path,
 		ParallelJobs: cfg.Options.ParallelJobs,
-	}); err != nil {
-		log.Error("Structure restore failed: %v", err)
+		Decryptor:    structureInput.Decryptor,
+		Diagnostics:  preDataDiag,
+	}, postgres.SectionPreData)
+	logDiagnostics(log, "Pre-data restore", preDataDiag)
+	if err != nil {
+		log.Error("Pre-data restore failed: %v", err)
 		return err
 	}
-	log.Success("Structure restored successfully")
+	log.Success("Pre-data restored in %s", time.Since(sectionStart).Round(time.Second))
+	phases = append(phases, logger.PhaseReport{Name: "Restore pre-data", Duration: time.Since(sectionStart)})
 
 	// Restore data
+	sectionStart = time.Now()
 	log.Info("Restoring database data (parallel jobs: %d)...", cfg.Options.DataParallelJobs)
-	if err := postgres.RestoreData(postgres.RestoreOptions{
+	dataDiag := postgres.NewDiagnosticLog(ignoreRules)
+	err = postgres.RestoreData(postgres.RestoreOptions{
 		Host:         cfg.Target.Host,
 		Port:         cfg.Target.Port,
 		User:         cfg.Target.AdminUser,
 		Password:     cfg.Target.AdminPassword,
 		Database:     cfg.Target.Database,
 		Schema:       "public",
-		InputFile:    dataDump,
+		InputFile:    dataInput.Path,
 		ParallelJobs: cfg.Options.DataParallelJobs,
-	}); err != nil {
+		LargeObjects: includeLargeObjects,
+		Decryptor:    dataInput.Decryptor,
+		Diagnostics:  dataDiag,
+	})
+	logDiagnostics(log, "Data restore", dataDiag)
+	if err != nil {
 		log.Error("Data restore failed: %v", err)
 		return err
 	}
-	log.Success("Data restored successfully")
+	log.Success("Data restored in %s", time.Since(sectionStart).Round(time.Second))
+	phases = append(phases, logger.PhaseReport{Name: "Restore data", Duration: time.Since(sectionStart)})
+
+	// Restore post-data: indexes, constraints and triggers, built once on
+	// the loaded tables
+	sectionStart = time.Now()
+	log.Info("Restoring post-data section (parallel jobs: %d)...", cfg.Options.PostDataParallelJobs)
+	postDataDiag := postgres.NewDiagnosticLog(ignoreRules)
+	err = postgres.RestoreSection(postgres.RestoreOptions{
+		Host:         cfg.Target.Host,
+		Port:         cfg.Target.Port,
+		User:         cfg.Target.AdminUser,
+		Password:     cfg.Target.AdminPassword,
+		Database:     cfg.Target.Database,
+		Schema:       "public",
+		InputFile:    structureInput.Path,
+		ParallelJobs: cfg.Options.PostDataParallelJobs,
+		Decryptor:    structureInput.Decryptor,
+		Diagnostics:  postDataDiag,
+	}, postgres.SectionPostData)
+	logDiagnostics(log, "Post-data restore", postDataDiag)
+	if err != nil {
+		log.Error("Post-data restore failed: %v", err)
+		return err
+	}
+	log.Success("Post-data restored in %s", time.Since(sectionStart).Round(time.Second))
+	phases = append(phases, logger.PhaseReport{Name: "Restore post-data", Duration: time.Since(sectionStart)})
 
-	phases = append(phases, logger.PhaseReport{Name: "Restore", Duration: time.Since(restoreStart)})
+	// Post-restore maintenance (if configured)
+	if maintenanceEnabled(cfg.Options.Maintenance) {
+		log.Phase("Post-restore maintenance")
+		phases = append(phases, runMaintenance(ctx, cfg.Options.Maintenance, cfg.Target, log)...)
+	}
 
 	// Phase 3: Configure ownership
 	log.Phase("STEP 3: Configure ownership for " + cfg.Target.AppUser)
 	ownershipStart := time.Now()
 
 	// Create app user if needed
-	if err := postgres.CreateAppUserIfNotExists(ctx, cfg.Target, cfg.Target.AppUser); err != nil {
+	if err := ensureAppUser(ctx, cfg, cfg.Target, log); err != nil {
 		log.Error("Failed to create app user: %v", err)
 		return err
 	}
 
-	// Transfer ownership
-	log.Info("Transferring ownership to %s...", cfg.Target.AppUser)
-	if err := postgres.TransferOwnership(ctx, cfg.Target, cfg.Target.AppUser); err != nil {
+	// Transfer ownership, mapping source owners to target roles
+	mapping, err := roleMapping(ctx, cfg, true, dumpInput{}, log)
+	if err != nil {
+		log.Error("Failed to read source owners: %v", err)
+		return err
+	}
+	if err := transferOwnership(ctx, cfg, cfg.Target, mapping, blocked, migrationDir, log); err != nil {
 		log.Error("Ownership transfer failed: %v", err)
 		return err
 	}
+	if err := restoreDumpPrivileges(ctx, cfg.Target, privileges, log); err != nil {
+		log.Error("%v", err)
+		return err
+	}
 	log.Success("Ownership configured successfully")
 
 	phases = append(phases, logger.PhaseReport{Name: "Ownership", Duration: time.Since(ownershipStart)})
@@ -279,6 +496,27 @@ func runMigrate(cmd *cobra.Command, args []string) error {
 		}
 	}
 
+	// Compare large objects
+	if includeLargeObjects {
+		targetLargeObjects, err := postgres.GetTargetLargeObjectStats(ctx, cfg.Target)
+		if err != nil {
+			log.Warning("Failed to get target large object stats: %v", err)
+		} else {
+			if err := logger.AppendLargeObjectReport(sourceLargeObjects, targetLargeObjects, validationLog); err != nil {
+				log.Warning("Failed to add large objects to validation report: %v", err)
+			}
+
+			_, hasDiscrepancy := postgres.CompareLargeObjectStats(sourceLargeObjects, targetLargeObjects)
+			if hasDiscrepancy {
+				log.Warning("Large object discrepancies found! Source: %d (%d bytes), target: %d (%d bytes)",
+					sourceLargeObjects.Count, sourceLargeObjects.TotalBytes,
+					targetLargeObjects.Count, targetLargeObjects.TotalBytes)
+			} else {
+				log.Success("All %d large objects validated successfully!", targetLargeObjects.Count)
+			}
+		}
+	}
+
 	// Generate timing report
 	endTime := time.Now()
 	report := logger.MigrationReport{
@@ -317,9 +555,104 @@ func runMigrate(cmd *cobra.Command, args []string) error {
 	log.Info("Next steps:")
 	log.Info("1. Review validation report: %s", validationLog)
 	log.Info("2. Test application connectivity")
-	log.Info("3. If %s was created, change its password", cfg.Target.AppUser)
+	log.Info("3. If %s was created, give the application its password from %s", cfg.Target.AppUser, appUserSecretLocation(cfg, cfg.Target))
 	log.Info("4. Verify critical business processes")
 	log.Info("5. Once verified, clean up dump files and old backup")
 
+	return nil
+}
+
+// rollbackTarget restores the pre-migration backup onto the target and
+// re-applies ownership to the app user
+func rollbackTarget(ctx context.Context, cfg *config.Config, backupFile string, dec *encryption.Decryptor, log *logger.Logger) *logger.RollbackReport {
+	log.Phase("ROLLBACK: Restore pre-migration backup")
+	start := time.Now()
+	report := &logger.RollbackReport{Backup: backupFile}
+
+	if err := restoreBackup(ctx, cfg, backupFile, dec, log); err != nil {
+		log.Error("Rollback failed: %v", err)
+		log.Error("The target may be incomplete; restore %s manually", backupFile)
+		report.Error = err.Error()
+	} else {
+		log.Success("Target rolled back to %s", backupFile)
+		report.Success = true
+	}
+
+	report.Duration = time.Since(start)
+	return report
+}
+
+// restoreBackup replaces the contents of the target with a backup taken by
+// BackupDatabase and transfers ownership to the app user
+func restoreBackup(ctx context.Context, cfg *config.Config, backupFile string, dec *encryption.Decryptor, log *logger.Logger) error {
+	ignoreRules, err := postgres.CompileIgnoreRules(cfg.Options.RestoreIgnore)
+	if err != nil {
+		return err
+	}
+
+	input, err := openDumpInput(backupFile, cfg, dec, false, log)
+	if err != nil {
+		return fmt.Errorf("failed to open backup: %w", err)
+	}
+	defer input.Close()
+
+	// The backup creates the extensions it contains; only configured ones
+	// are created up front
+	reopen, _, err := drainTarget(ctx, cfg, cfg.Target, log)
+	if err != nil {
+		return fmt.Errorf("failed to close sessions on target: %w", err)
+	}
+	defer reopen()
+
+	log.Info("Preparing target database...")
+	extensions := postgres.MergeExtensions(nil, cfg.Options.Extensions)
+	if err := postgres.PrepareTarget(ctx, cfg.Target, extensions); err != nil {
+		return fmt.Errorf("failed to prepare target: %w", err)
+	}
+
+	// The backup covers the whole database, so no schema restriction; other
+	// schemas are replaced object by object
+	log.Info("Restoring backup (parallel jobs: %d)...", cfg.Options.ParallelJobs)
+	diag := postgres.NewDiagnosticLog(ignoreRules)
+	err = postgres.RestoreFull(postgres.RestoreOptions{
+		Host:         cfg.Target.Host,
+		Port:         cfg.Target.Port,
+		User:         cfg.Target.AdminUser,
+		Password:     cfg.Target.AdminPassword,
+		Database:     cfg.Target.Database,
+		InputFile:    input.Path,
+		ParallelJobs: cfg.Options.ParallelJobs,
+		Decryptor:    input.Decryptor,
+		Clean:        true,
+		Diagnostics:  diag,
+	})
+	logDiagnostics(log, "Backup restore", diag)
+	if err != nil {
+		return fmt.Errorf("failed to restore backup: %w", err)
+	}
+
+	// Objects get back the owners recorded in the backup
+	if err := ensureAppUser(ctx, cfg, cfg.Target, log); err != nil {
+		return err
+	}
+	mapping, err := backupRoleMapping(cfg, input, log)
+	if err != nil {
+		return fmt.Errorf("failed to read backup owners: %w", err)
+	}
+	mapping.OwnersOnly = true
+	if err := transferOwnership(ctx, cfg, cfg.Target, mapping, nil, "", log); err != nil {
+		return err
+	}
+
+	// The backup holds the privileges the target had, which are given back
+	// as they are whatever options.privileges says
+	stmts, err := postgres.DumpACLs(input.Path, input.Decryptor, nil)
+	if err != nil {
+		return err
+	}
+	if err := restoreDumpPrivileges(ctx, cfg.Target, stmts, log); err != nil {
+		return err
+	}
+
 	return nil
 }
\ No newline at end of file
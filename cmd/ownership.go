@@ -0,0 +1,551 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/1CL0UD/cloudm-cli/internal/config"
+	"github.com/1CL0UD/cloudm-cli/internal/filesystem"
+	"github.com/1CL0UD/cloudm-cli/internal/logger"
+	"github.com/1CL0UD/cloudm-cli/internal/postgres"
+	"github.com/spf13/cobra"
+)
+
+var ownershipCmd = &cobra.Command{
+	Use:   "ownership",
+	Short: "Audit and fix ownership and privileges of the target",
+}
+
+var ownershipAuditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Compare owners and privileges of the target with the configuration",
+	Long: `Compare the owners, privileges and default privileges of the target database
+with those a migration would give it under role_map and grants, and print the
+differences. Exits with an error when any are found.`,
+	RunE: runOwnershipAudit,
+}
+
+var ownershipFixCmd = &cobra.Command{
+	Use:   "fix",
+	Short: "Apply only the differences found by ownership audit",
+	Long: `Audit the target like ownership audit, then apply the statements fixing the
+differences in a single transaction.`,
+	RunE: runOwnershipFix,
+}
+
+var ownershipRevertCmd = &cobra.Command{
+	Use:   "revert",
+	Short: "Restore the owners and privileges recorded before an ownership change",
+	Long: `Give the objects changed by a migration, restore or ownership fix back the
+owners and privileges recorded in ownership_undo.json in its directory. The
+statements run in a single transaction.`,
+	RunE: runOwnershipRevert,
+}
+
+var revertInput string
+
+func init() {
+	ownershipRevertCmd.Flags().StringVarP(&revertInput, "input", "i", "", "directory holding ownership_undo.json (required)")
+	ownershipRevertCmd.MarkFlagRequired("input")
+
+	ownershipCmd.AddCommand(ownershipAuditCmd)
+	ownershipCmd.AddCommand(ownershipFixCmd)
+	ownershipCmd.AddCommand(ownershipRevertCmd)
+}
+
+func runOwnershipAudit(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	log, err := logger.New(logger.LoggerOptions{
+		Verbose: verbose,
+		LogFile: logFile,
+		NoColor: noColor,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to initialize logger: %w", err)
+	}
+	defer log.Close()
+
+	audit, err := auditOwnership(ctx, log)
+	if err != nil {
+		return err
+	}
+	drifts := audit.drifts
+	if len(drifts) == 0 {
+		log.Success("Ownership and privileges match the configuration")
+		return nil
+	}
+
+	for _, d := range drifts {
+		log.Warning("%s: %s", d.Object, d.Problem)
+		log.Debug("  fix: %s", d.Fix)
+	}
+	log.Error("Found %d differences; run ownership fix to apply them", len(drifts))
+	return fmt.Errorf("found %d ownership differences", len(drifts))
+}
+
+func runOwnershipFix(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	log, err := logger.New(logger.LoggerOptions{
+		Verbose: verbose,
+		LogFile: logFile,
+		NoColor: noColor,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to initialize logger: %w", err)
+	}
+	defer log.Close()
+
+	audit, err := auditOwnership(ctx, log)
+	if err != nil {
+		return err
+	}
+	cfg, drifts := audit.cfg, audit.drifts
+	if len(drifts) == 0 {
+		log.Success("Ownership and privileges match the configuration; nothing to fix")
+		return nil
+	}
+
+	for _, d := range drifts {
+		log.Info("%s: %s", d.Object, d.Problem)
+	}
+
+	schemas := postgres.OwnershipSchemas(audit.mapping, audit.rules)
+	plan, err := postgres.PlanFixes(ctx, cfg.Target, schemas, drifts)
+	if err != nil {
+		log.Error("Failed to plan fixes: %v", err)
+		return err
+	}
+	plan.BatchLargeObjects = cfg.Options.LargeObjectBatches
+	logPlanAccess(log, cfg.Target.AdminUser, plan)
+	if len(plan.Statements) == 0 && len(plan.LargeObjects) == 0 {
+		log.Error("None of the fixes is possible with the privileges of %s", cfg.Target.AdminUser)
+		return fmt.Errorf("no fix is possible as %s", cfg.Target.AdminUser)
+	}
+
+	if dryRun {
+		logPlanStatements(log, plan)
+		return nil
+	}
+
+	if err := checkProtected(cfg, cfg.Target); err != nil {
+		log.Error("%v", err)
+		return err
+	}
+
+	before, err := postgres.TakeOwnershipSnapshot(ctx, cfg.Target, schemas)
+	if err != nil {
+		log.Error("Failed to record owners and privileges: %v", err)
+		return err
+	}
+
+	if err := postgres.ApplyOwnershipPlan(ctx, cfg.Target, plan); err != nil {
+		log.Error("Fix failed: %v", err)
+		return err
+	}
+	log.Success("Applied %d fixes", len(drifts)-len(plan.Impossible))
+	logPlanOutcome(log, cfg.Target.AdminUser, plan)
+
+	outputDir := cfg.Options.OutputDir
+	if outputDir == "" {
+		outputDir = "./migrations"
+	}
+	dir, err := filesystem.CreateMigrationDir(outputDir)
+	if err != nil {
+		log.Warning("Failed to record owners and privileges; this change cannot be reverted: %v", err)
+		return nil
+	}
+	saveOwnershipChanges(ctx, cfg.Target, schemas, before, nil, dir, log)
+
+	return nil
+}
+
+func runOwnershipRevert(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	log, err := logger.New(logger.LoggerOptions{
+		Verbose: verbose,
+		LogFile: logFile,
+		NoColor: noColor,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to initialize logger: %w", err)
+	}
+	defer log.Close()
+
+	configPath := cfgFile
+	if configPath == "" {
+		configPath = "db.yaml"
+	}
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		log.Error("Failed to load configuration: %v", err)
+		return err
+	}
+
+	saved, err := postgres.LoadOwnershipSnapshot(filesystem.GetOwnershipSnapshotPath(revertInput))
+	if err != nil {
+		log.Error("%v", err)
+		return err
+	}
+	if target := postgres.RestoreTarget(cfg.Target); saved.Target != target {
+		log.Error("The snapshot was taken on %s, not %s", saved.Target, target)
+		return fmt.Errorf("snapshot target %s does not match configured target %s", saved.Target, target)
+	}
+	if err := checkProtected(cfg, cfg.Target); err != nil {
+		log.Error("%v", err)
+		return err
+	}
+
+	if err := postgres.TestTargetConnection(cfg.Target); err != nil {
+		log.Error("Failed to connect to target database: %v", err)
+		return err
+	}
+
+	plan, err := postgres.PlanRevert(ctx, cfg.Target, saved)
+	if err != nil {
+		log.Error("Failed to read owners and privileges: %v", err)
+		return err
+	}
+	plan.BatchLargeObjects = cfg.Options.LargeObjectBatches
+	for _, skipped := range plan.Skipped {
+		log.Warning("Cannot revert %s", skipped)
+	}
+	logPlanAccess(log, cfg.Target.AdminUser, plan)
+	if len(plan.Statements) == 0 && len(plan.LargeObjects) == 0 && len(plan.Impossible) > 0 {
+		log.Error("None of the changes is possible with the privileges of %s", cfg.Target.AdminUser)
+		return fmt.Errorf("no change is possible as %s", cfg.Target.AdminUser)
+	}
+	if len(plan.Statements) == 0 && len(plan.LargeObjects) == 0 {
+		log.Success("Owners and privileges already match the snapshot of %s", saved.CreatedAt.Format("2006-01-02 15:04:05"))
+		return nil
+	}
+
+	if dryRun {
+		logPlanStatements(log, plan)
+		return nil
+	}
+
+	log.Info("Reverting owners and privileges of %d objects (%d statements)...", len(saved.Objects), len(plan.Statements))
+	if err := postgres.ApplyOwnershipPlan(ctx, cfg.Target, plan); err != nil {
+		log.Error("Revert failed: %v", err)
+		return err
+	}
+	log.Success("Owners and privileges restored to the snapshot of %s", saved.CreatedAt.Format("2006-01-02 15:04:05"))
+	logPlanOutcome(log, cfg.Target.AdminUser, plan)
+	for _, role := range saved.CreatedRoles {
+		log.Info("Role %s was created by the change and is kept; drop it if unused", role)
+	}
+
+	return nil
+}
+
+// ownershipAudit is the outcome of comparing the target with the
+// configuration
+type ownershipAudit struct {
+	cfg     *config.Config
+	mapping postgres.RoleMapping
+	rules   []postgres.GrantRule
+	drifts  []postgres.Drift
+}
+
+// auditOwnership loads the configuration and compares the ownership and
+// privileges of the target with it. Source owners are read from the source
+// database when it can be reached.
+func auditOwnership(ctx context.Context, log *logger.Logger) (*ownershipAudit, error) {
+	configPath := cfgFile
+	if configPath == "" {
+		configPath = "db.yaml"
+	}
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		log.Error("Failed to load configuration: %v", err)
+		return nil, err
+	}
+	if cfg.Target.Host == "" || cfg.Target.Database == "" {
+		log.Error("Target database configuration is incomplete")
+		return nil, fmt.Errorf("target database configuration is incomplete")
+	}
+
+	if err := postgres.TestTargetConnection(cfg.Target); err != nil {
+		log.Error("Failed to connect to target database: %v", err)
+		return nil, err
+	}
+	log.Success("Connected to target database: %s/%s", cfg.Target.Host, cfg.Target.Database)
+
+	rules, err := postgres.CompileGrants(cfg.Grants)
+	if err != nil {
+		log.Error("Invalid grants: %v", err)
+		return nil, err
+	}
+
+	readSource := false
+	if cfg.Source.Host != "" {
+		if err := postgres.TestConnection(cfg.Source); err != nil {
+			log.Warning("Source database unreachable, source owners are unknown: %v", err)
+		} else {
+			readSource = true
+		}
+	}
+	mapping, err := roleMapping(ctx, cfg, readSource, dumpInput{}, log)
+	if err != nil {
+		log.Error("Failed to read source owners: %v", err)
+		return nil, err
+	}
+
+	log.Info("Auditing ownership and privileges of %s...", cfg.Target.Database)
+	drifts, err := postgres.AuditOwnership(ctx, cfg.Target, mapping, rules)
+	if err != nil {
+		log.Error("Audit failed: %v", err)
+		return nil, err
+	}
+
+	return &ownershipAudit{cfg: cfg, mapping: mapping, rules: rules, drifts: drifts}, nil
+}
+
+// roleMapping builds the mapping from source owners to target roles. When
+// role_map maps any owner, the source owners are read from the source
+// catalog if readSource is set, and otherwise from the given dump.
+func roleMapping(ctx context.Context, cfg *config.Config, readSource bool, dump dumpInput, log *logger.Logger) (postgres.RoleMapping, error) {
+	mapping := postgres.NewRoleMapping(cfg.Options.RoleMap, cfg.Target.AppUser)
+	if len(mapping.Roles) == 0 {
+		return mapping, nil
+	}
+
+	switch {
+	case readSource:
+		owners, err := postgres.SourceOwners(ctx, cfg.Source, mapping.Schemas)
+		if err != nil {
+			return mapping, err
+		}
+		mapping.SourceOwners = owners
+		if mapping.LargeObjectOwners, err = postgres.SourceLargeObjectOwners(ctx, cfg.Source); err != nil {
+			return mapping, err
+		}
+		log.Info("Read owners of %d objects from the source database", len(owners))
+	case dump.Path != "":
+		entries, err := postgres.ReadTOC(dump.Path, dump.Decryptor)
+		if err != nil {
+			return mapping, err
+		}
+		mapping.SourceOwners = postgres.OwnersFromTOC(entries)
+		mapping.LargeObjectOwners = postgres.LargeObjectOwnersFromTOC(entries)
+		log.Info("Read owners of %d objects from the dump", len(mapping.SourceOwners))
+	default:
+		log.Warning("Source owners are unknown; every object will be owned by %s", mapping.Default)
+	}
+
+	return mapping, nil
+}
+
+// backupRoleMapping builds the role mapping giving the objects of a
+// pre-migration backup back the owners it records. Those are roles of the
+// target already, so role_map.roles does not apply. The database and the
+// objects without a recorded owner go to the owner the backup records for
+// the database, or the default role. Every schema of the backup is
+// covered, since the backup replaces the whole database.
+func backupRoleMapping(cfg *config.Config, backup dumpInput, log *logger.Logger) (postgres.RoleMapping, error) {
+	mapping := postgres.NewRoleMapping(cfg.Options.RoleMap, cfg.Target.AppUser)
+	mapping.Roles = nil
+	mapping.KeepSourceOwners = true
+
+	entries, err := postgres.ReadTOC(backup.Path, backup.Decryptor)
+	if err != nil {
+		return mapping, err
+	}
+	mapping.SourceOwners = postgres.OwnersFromTOC(entries)
+	mapping.LargeObjectOwners = postgres.LargeObjectOwnersFromTOC(entries)
+	mapping.Schemas = postgres.TOCSchemas(entries, mapping.Schemas)
+	for _, e := range entries {
+		if e.Type == "DATABASE" && e.Owner != "" {
+			mapping.Default = e.Owner
+		}
+	}
+	log.Info("Read owners of %d objects from the backup", len(mapping.SourceOwners))
+
+	return mapping, nil
+}
+
+// transferOwnership plans the ownership of the target by a role mapping
+// and the grants rules, saves the plan to dir when save_ownership_sql is
+// set, and applies it in a single transaction. blocked are the CONNECT
+// grants drainTarget took away, which the recorded previous state includes.
+func transferOwnership(ctx context.Context, cfg *config.Config, target config.TargetConfig, mapping postgres.RoleMapping, blocked []postgres.ConnectGrant, dir string, log *logger.Logger) error {
+	switch {
+	case mapping.KeepSourceOwners:
+		log.Info("Giving objects back their recorded owners (default: %s)...", mapping.Default)
+	case len(mapping.Roles) > 0:
+		log.Info("Transferring ownership by role map (default: %s)...", mapping.Default)
+	default:
+		log.Info("Transferring ownership to %s...", mapping.Default)
+	}
+
+	rules, err := postgres.CompileGrants(cfg.Grants)
+	if err != nil {
+		return err
+	}
+	plan, err := postgres.PlanOwnership(ctx, target, mapping, rules)
+	if err != nil {
+		return err
+	}
+	plan.BatchLargeObjects = cfg.Options.LargeObjectBatches
+	for _, skipped := range plan.Skipped {
+		log.Warning("Kept owner of %s", skipped)
+	}
+	logPlanAccess(log, target.AdminUser, plan)
+
+	if cfg.Options.SaveOwnershipSQL && dir != "" {
+		if err := saveOwnershipPlan(dir, plan); err != nil {
+			log.Warning("%v", err)
+		} else {
+			log.Info("Ownership plan saved to %s", filesystem.GetOwnershipSQLPath(dir))
+		}
+	}
+
+	// Record the owners and privileges the plan replaces, unless the target
+	// is a shadow database whose previous state is of no use
+	var before *postgres.OwnershipSnapshot
+	schemas := postgres.OwnershipSchemas(mapping, rules)
+	if dir != "" && target.Database == cfg.Target.Database {
+		snapshot, err := postgres.TakeOwnershipSnapshot(ctx, target, schemas)
+		if err != nil {
+			log.Warning("Failed to record owners and privileges; this change cannot be reverted: %v", err)
+		} else {
+			snapshot = snapshot.WithConnectGrants(blocked)
+			before = &snapshot
+		}
+	}
+
+	log.Info("Applying ownership plan (%d statements) in one transaction...", len(plan.Statements))
+	for _, c := range plan.LargeObjects {
+		if plan.BatchLargeObjects {
+			log.Info("Changing the owner of %s in batches...", c)
+		} else {
+			log.Info("Changing the owner of %s in the same transaction...", c)
+		}
+	}
+	if err := postgres.ApplyOwnershipPlan(ctx, target, plan); err != nil {
+		return fmt.Errorf("failed to apply ownership plan: %w", err)
+	}
+	for _, role := range plan.CreatedRoles {
+		log.Info("Created role %s (NOLOGIN)", role)
+	}
+	logPlanOutcome(log, target.AdminUser, plan)
+
+	if before != nil {
+		saveOwnershipChanges(ctx, target, schemas, *before, plan.CreatedRoles, dir, log)
+	}
+
+	return nil
+}
+
+// saveOwnershipChanges writes the part of a snapshot taken before an
+// ownership change that the change modified to dir, so that ownership
+// revert can restore it
+func saveOwnershipChanges(ctx context.Context, target config.TargetConfig, schemas []string, before postgres.OwnershipSnapshot, created []string, dir string, log *logger.Logger) {
+	after, err := postgres.TakeOwnershipSnapshot(ctx, target, schemas)
+	if err != nil {
+		log.Warning("Failed to record owners and privileges; this change cannot be reverted: %v", err)
+		return
+	}
+
+	changed := before.Changed(after)
+	changed.CreatedRoles = created
+	if changed.IsEmpty() && len(created) == 0 {
+		return
+	}
+
+	path := filesystem.GetOwnershipSnapshotPath(dir)
+	if err := changed.Save(path); err != nil {
+		log.Warning("%v", err)
+		return
+	}
+	log.Info("Previous owners and privileges of %d objects saved to %s", len(changed.Objects), path)
+}
+
+// showOwnershipPlan prints the ownership plan a migration from the source
+// would apply to the target, for a dry run
+func showOwnershipPlan(ctx context.Context, cfg *config.Config, log *logger.Logger) error {
+	mapping, err := roleMapping(ctx, cfg, true, dumpInput{}, log)
+	if err != nil {
+		return err
+	}
+	rules, err := postgres.CompileGrants(cfg.Grants)
+	if err != nil {
+		return err
+	}
+	plan, err := postgres.PlanOwnershipFromSource(ctx, cfg.Source, cfg.Target, mapping, rules)
+	if err != nil {
+		return err
+	}
+	plan.BatchLargeObjects = cfg.Options.LargeObjectBatches
+
+	log.DryRun("Ownership plan:")
+	logPlanStatements(log, plan)
+	for _, skipped := range plan.Skipped {
+		log.DryRun("  -- would keep owner of %s", skipped)
+	}
+	if plan.Managed {
+		log.DryRun("%s is not a superuser; the plan grants it membership in the roles it acts as and revokes it at the end", cfg.Target.AdminUser)
+	}
+	for _, op := range plan.Impossible {
+		log.DryRun("  -- not possible as %s: %s", cfg.Target.AdminUser, op)
+	}
+	return nil
+}
+
+// logPlanAccess reports whether the admin user acts through temporary
+// memberships and the operations a plan leaves out
+func logPlanAccess(log *logger.Logger, adminUser string, plan postgres.OwnershipPlan) {
+	if plan.Managed {
+		log.Info("%s is not a superuser; it acts as the target roles through temporary memberships", adminUser)
+	}
+	for _, op := range plan.Impossible {
+		log.Warning("Not possible as %s: %s", adminUser, op)
+	}
+}
+
+// logPlanOutcome reports the memberships an applied plan granted and
+// revoked, and how many operations it left out
+func logPlanOutcome(log *logger.Logger, adminUser string, plan postgres.OwnershipPlan) {
+	if len(plan.TemporaryMemberships) > 0 {
+		log.Info("Memberships of %s in %s were granted for the plan and revoked", adminUser, strings.Join(plan.TemporaryMemberships, ", "))
+	}
+	if len(plan.Impossible) > 0 {
+		log.Warning("%d ownership operations were not possible with the privileges of %s; run them as a superuser or give %s ADMIN OPTION on the roles listed above",
+			len(plan.Impossible), adminUser, adminUser)
+	}
+}
+
+// logPlanStatements prints what a plan would run, for a dry run. Large
+// objects are summarised rather than listed one by one.
+func logPlanStatements(log *logger.Logger, plan postgres.OwnershipPlan) {
+	log.DryRun("Would apply %d statements in one transaction:", len(plan.Statements))
+	for _, stmt := range plan.Statements {
+		log.DryRun("  %s;", stmt)
+	}
+	for _, c := range plan.LargeObjects {
+		if plan.BatchLargeObjects {
+			log.DryRun("Then would change the owner of %s, committing in batches", c)
+		} else {
+			log.DryRun("  -- change the owner of %s", c)
+		}
+	}
+	for _, stmt := range plan.Cleanup {
+		log.DryRun("  %s;", stmt)
+	}
+}
+
+// saveOwnershipPlan writes an ownership plan as ownership.sql in dir
+func saveOwnershipPlan(dir string, plan postgres.OwnershipPlan) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+	if err := os.WriteFile(filesystem.GetOwnershipSQLPath(dir), []byte(plan.SQL()), 0644); err != nil {
+		return fmt.Errorf("failed to write ownership plan: %w", err)
+	}
+	return nil
+}
\ No newline at end of file
@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/1CL0UD/cloudm-cli/internal/config"
+	"github.com/1CL0UD/cloudm-cli/internal/encryption"
+	"github.com/1CL0UD/cloudm-cli/internal/logger"
+	"github.com/1CL0UD/cloudm-cli/internal/postgres"
+)
+
+// dumpPrivileges reads the privileges recorded in a dump unless mode is
+// reset, renaming their roles through role_map.roles for map. It fails
+// when they name roles that neither exist on the target nor are created by
+// the ownership step, so that this is found before the target is modified.
+// When entries is not nil, only the privileges among them are read.
+func dumpPrivileges(ctx context.Context, cfg *config.Config, target config.TargetConfig, mode, dumpFile string, dec *encryption.Decryptor, entries []postgres.TOCEntry, log *logger.Logger) ([]string, error) {
+	if mode == "" || mode == postgres.PrivilegesReset {
+		return nil, nil
+	}
+
+	stmts, err := postgres.DumpACLs(dumpFile, dec, entries)
+	if err != nil {
+		return nil, err
+	}
+	if mode == postgres.PrivilegesMap {
+		stmts = postgres.MapACLRoles(stmts, cfg.Options.RoleMap.Roles)
+	}
+	if len(stmts) == 0 {
+		log.Info("The dump records no privileges to restore")
+		return nil, nil
+	}
+
+	missing, err := postgres.MissingRoles(ctx, target, postgres.ACLRoles(stmts))
+	if err != nil {
+		return nil, err
+	}
+	missing = withoutCreatedRoles(cfg, target, missing)
+	if len(missing) > 0 {
+		hint := "create them on the target"
+		if mode == postgres.PrivilegesPreserve {
+			hint += " or map them with options.privileges: map and role_map.roles"
+		} else {
+			hint += " or add them to role_map.roles"
+		}
+		return nil, fmt.Errorf("privileges of the dump name roles missing on the target: %s; %s",
+			strings.Join(missing, ", "), hint)
+	}
+
+	log.Info("Read %d privilege statements from the dump (%s)", len(stmts), mode)
+	return stmts, nil
+}
+
+// withoutCreatedRoles drops from missing the roles that the ownership step
+// creates: the app user and the roles of role_map and grants
+func withoutCreatedRoles(cfg *config.Config, target config.TargetConfig, missing []string) []string {
+	created := map[string]bool{target.AppUser: true, cfg.Options.RoleMap.Default: true}
+	for _, role := range cfg.Options.RoleMap.Roles {
+		created[role] = true
+	}
+	for _, g := range cfg.Grants {
+		created[g.Role] = true
+	}
+
+	var left []string
+	for _, role := range missing {
+		if !created[role] {
+			left = append(left, role)
+		}
+	}
+	return left
+}
+
+// restoreDumpPrivileges applies the privileges read by dumpPrivileges in a
+// single transaction. It runs after the ownership step so that the roles
+// it creates exist; statements naming roles still missing are skipped.
+func restoreDumpPrivileges(ctx context.Context, target config.TargetConfig, stmts []string, log *logger.Logger) error {
+	if len(stmts) == 0 {
+		return nil
+	}
+
+	missing, err := postgres.MissingRoles(ctx, target, postgres.ACLRoles(stmts))
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		stmts = postgres.WithoutRoles(stmts, missing)
+		log.Warning("Skipping privileges of roles missing on the target: %s", strings.Join(missing, ", "))
+	}
+
+	log.Info("Restoring %d privilege statements from the dump...", len(stmts))
+	if err := postgres.ApplyOwnershipPlan(ctx, target, postgres.OwnershipPlan{Statements: stmts}); err != nil {
+		return fmt.Errorf("failed to restore privileges: %w", err)
+	}
+	log.Success("Privileges of the dump restored")
+	return nil
+}
\ No newline at end of file
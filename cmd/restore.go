@@ -2,7 +2,12 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/1CL0UD/cloudm-cli/internal/config"
@@ -14,7 +19,13 @@ import (
 )
 
 var (
-	inputDir string
+	inputDir            string
+	restoreTables       []string
+	restoreExcludeTable []string
+	restoreObjectTypes  []string
+	restoreResume       bool
+	restoreResumable    bool
+	restoreStrategy     string
 )
 
 var restoreCmd = &cobra.Command{
@@ -25,15 +36,21 @@ var restoreCmd = &cobra.Command{
 }
 
 func init() {
-	restoreCmd.Flags().StringVarP(&inputDir, "input", "i", "", "input directory containing dump files (required)")
+	restoreCmd.Flags().StringVarP(&inputDir, "input", "i", "", "input directory or storage location (s3://, sftp://) containing dump files (required)")
 	restoreCmd.Flags().BoolVar(&skipBackup, "skip-backup", false, "skip pre-migration backup")
 	restoreCmd.Flags().BoolVar(&structureOnly, "structure-only", false, "restore only schema structure")
 	restoreCmd.Flags().BoolVar(&dataOnly, "data-only", false, "restore only data")
+	restoreCmd.Flags().StringSliceVar(&restoreTables, "table", nil, "restore only these tables, as table or schema.table (repeatable, supports wildcards)")
+	restoreCmd.Flags().StringSliceVar(&restoreExcludeTable, "exclude-table", nil, "skip these tables (repeatable, supports wildcards)")
+	restoreCmd.Flags().StringSliceVar(&restoreObjectTypes, "object-type", nil, "restore only entries of these types, e.g. FUNCTION or \"MATERIALIZED VIEW\" (repeatable)")
+	restoreCmd.Flags().BoolVar(&restoreResumable, "resumable", false, "restore data one table per transaction and record progress, so that an interrupted restore can be continued with --resume")
+	restoreCmd.Flags().BoolVar(&restoreResume, "resume", false, "continue an interrupted restore without dropping what is already loaded")
+	restoreCmd.Flags().StringVar(&restoreStrategy, "strategy", postgres.StrategyInPlace, "restore strategy: in-place (replace the public schema) or shadow (restore into a new database and swap it in)")
 	restoreCmd.MarkFlagRequired("input")
 }
 
 func runRestore(cmd *cobra.Command, args []string) error {
-	ctx := context.Background()
+	ctx := cmd.Context()
 	startTime := time.Now()
 
 	// Initialize logger
@@ -66,15 +83,68 @@ func runRestore(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("target database configuration is incomplete")
 	}
 
+	// Classify pg_restore output with the configured ignore rules
+	ignoreRules, err := postgres.CompileIgnoreRules(cfg.Options.RestoreIgnore)
+	if err != nil {
+		log.Error("Invalid restore ignore rules: %v", err)
+		return err
+	}
+
+	// A shadow restore writes to a fresh database next to the live one and
+	// swaps it in once it is complete
+	target := cfg.Target
+	shadow := false
+	switch restoreStrategy {
+	case postgres.StrategyInPlace:
+	case postgres.StrategyShadow:
+		shadow = true
+		target.Database = postgres.ShadowDatabaseName(cfg.Target.Database)
+	default:
+		log.Error("Unknown restore strategy: %s", restoreStrategy)
+		return fmt.Errorf("unknown restore strategy %q (use %s or %s)", restoreStrategy, postgres.StrategyInPlace, postgres.StrategyShadow)
+	}
+
+	// Download dumps from remote storage into a local working directory
+	localDir := inputDir
+	var remote filesystem.Storage
+	if filesystem.IsRemoteLocation(inputDir) {
+		remote, localDir, err = fetchRemoteInput(ctx, cfg, inputDir, log)
+		if err != nil {
+			log.Error("Failed to download dumps: %v", err)
+			return err
+		}
+		defer os.RemoveAll(localDir)
+	}
+
+	// Load the state of the interrupted restore to continue. Remote inputs
+	// keep their state under the output directory since the downloaded
+	// dumps are temporary.
+	statePath := filesystem.GetRestoreStatePath(localDir)
+	if remote != nil {
+		statePath = filesystem.GetRestoreStatePath(filepath.Join(cfg.Options.OutputDir, path.Base(strings.TrimRight(inputDir, "/"))))
+	}
+	var state *postgres.RestoreState
+	if restoreResume {
+		if state, err = loadResumeState(statePath, target, log); err != nil {
+			log.Error("Cannot resume restore: %v", err)
+			return err
+		}
+	}
+
+	if shadow && (dataOnly || structureOnly) {
+		log.Error("The shadow strategy restores a complete database; --structure-only and --data-only are not supported")
+		return fmt.Errorf("--strategy shadow cannot be combined with --structure-only or --data-only")
+	}
+
 	// Validate dump files exist
 	if !structureOnly {
-		if err := filesystem.ValidateDataDump(inputDir); err != nil {
+		if err := filesystem.ValidateDataDump(localDir); err != nil {
 			log.Error("Data dump file not found: %v", err)
 			return err
 		}
 	}
 	if !dataOnly {
-		if err := filesystem.ValidateStructureDump(inputDir); err != nil {
+		if err := filesystem.ValidateStructureDump(localDir); err != nil {
 			log.Error("Structure dump file not found: %v", err)
 			return err
 		}
@@ -98,19 +168,218 @@ func runRestore(cmd *cobra.Command, args []string) error {
 	}
 	log.Success("Connected to target database: %s/%s", cfg.Target.Host, cfg.Target.Database)
 
+	if err := checkProtected(cfg, cfg.Target); err != nil {
+		log.Error("%v", err)
+		return err
+	}
+	if _, err := postgres.CompileGrants(cfg.Grants); err != nil {
+		log.Error("Invalid grants: %v", err)
+		return err
+	}
+
+	// The source is not needed for a restore, so it is only compared with
+	// the target when it can be reached
+	if cfg.Source.Host != "" && cfg.Source.Database != "" {
+		same, err := postgres.SameDatabase(ctx, cfg.Source, cfg.Target)
+		if err != nil {
+			log.Warning("Could not compare source and target: %v", err)
+		} else if same {
+			log.Error("Source and target are the same database (%s/%s)", cfg.Target.Host, cfg.Target.Database)
+			return fmt.Errorf("refusing to restore over the source database")
+		}
+	}
+
+	// A selective restore replaces only the selected objects
+	selection := postgres.RestoreSelection{
+		Tables:        restoreTables,
+		ExcludeTables: restoreExcludeTable,
+		ObjectTypes:   restoreObjectTypes,
+	}
+	selective := !selection.IsEmpty()
+
+	// Check the extensions to create when preparing the target. They are
+	// discovered on the source when it is configured and reachable, and
+	// taken from the configuration alone otherwise.
+	var extensions []postgres.Extension
+	if !restoreResume && !selective {
+		discover := cfg.Source.Host != "" && cfg.Source.Database != ""
+		if discover {
+			if err := postgres.TestConnection(cfg.Source); err != nil {
+				log.Warning("Source database not reachable, using configured extensions only: %v", err)
+				discover = false
+			}
+		}
+		if extensions, err = resolveExtensions(ctx, cfg, discover, log); err != nil {
+			log.Error("Extension check failed: %v", err)
+			return err
+		}
+	}
+
+	structureDump, dataDump := filesystem.GetDumpPaths(localDir)
+
+	// Prepare dumps for reading, decrypting them if needed
+	var structureInput, dataInput dumpInput
+	if !dataOnly {
+		if structureInput, err = openDumpInput(structureDump, cfg, nil, false, log); err != nil {
+			log.Error("Failed to open structure dump: %v", err)
+			return err
+		}
+		defer structureInput.Close()
+	}
+	if !structureOnly {
+		if dataInput, err = openDumpInput(dataDump, cfg, nil, restoreResumable || restoreResume, log); err != nil {
+			log.Error("Failed to open data dump: %v", err)
+			return err
+		}
+		defer dataInput.Close()
+	}
+
+	// Set up encryption for the pre-restore backup
+	enc, err := artifactEncryptor(cfg, log)
+	if err != nil {
+		log.Error("Encryption setup failed: %v", err)
+		return err
+	}
+
+	if selective && shadow {
+		log.Error("--strategy shadow cannot be combined with --table, --exclude-table or --object-type")
+		return fmt.Errorf("--strategy shadow cannot be combined with a selective restore")
+	}
+	if selective && (restoreResume || restoreResumable) {
+		log.Error("--resume and --resumable cannot be combined with --table, --exclude-table or --object-type")
+		return fmt.Errorf("--resume and --resumable cannot be combined with a selective restore")
+	}
+
+	// Build pg_restore list files for a selective restore
+	var structureList, dataList string
+	if selective {
+		structureList, dataList, err = buildRestoreLists(ctx, target, selection, structureInput, dataInput, log)
+		if err != nil {
+			log.Error("Failed to build selective restore list: %v", err)
+			return err
+		}
+		defer os.Remove(structureList)
+		defer os.Remove(dataList)
+	}
+
+	// With --resumable, data is restored entry by entry so that progress
+	// can be recorded and resumed; otherwise a single parallel pg_restore
+	// loads it. Tracking needs random access to the data dump, which
+	// streamed encrypted dumps don't offer.
+	var dataEntries []postgres.TOCEntry
+	tracked := (restoreResumable || restoreResume) && !structureOnly && dataInput.Decryptor == nil
+	if tracked {
+		entries, err := postgres.ReadTOC(dataInput.Path, nil)
+		if err != nil {
+			log.Error("Failed to read data dump: %v", err)
+			return err
+		}
+		dataEntries = postgres.DataEntries(entries, "public")
+		fingerprint := postgres.TOCFingerprint(dataEntries)
+
+		if state == nil {
+			state = postgres.NewRestoreState(statePath, postgres.RestoreTarget(target), fingerprint)
+			state.DataOnly = dataOnly
+		} else if state.Fingerprint != fingerprint {
+			log.Error("The data dump differs from the one of the interrupted restore")
+			return fmt.Errorf("cannot resume: data dump changed since the restore started")
+		}
+	} else if restoreResume || restoreResumable {
+		if structureOnly {
+			log.Error("Only data restores record their progress; --resume and --resumable don't apply to --structure-only")
+			return fmt.Errorf("cannot track progress of a structure-only restore")
+		}
+		log.Error("The data dump is streamed encrypted (encryption.required), so progress cannot be tracked")
+		return fmt.Errorf("cannot track progress of a restore of a streamed encrypted dump")
+	}
+
+	// Read the privileges of the structure dump and check their roles
+	// before the target is modified. A selective restore gives back only
+	// those of the objects it restores.
+	var privileges []string
+	if !dataOnly && (!selective || structureList != "") {
+		var entries []postgres.TOCEntry
+		if selective {
+			if entries, err = readRestoreList(structureList); err != nil {
+				log.Error("%v", err)
+				return err
+			}
+		}
+		privileges, err = dumpPrivileges(ctx, cfg, target, cfg.Options.Privileges, structureInput.Path, structureInput.Decryptor, entries, log)
+		if err != nil {
+			log.Error("Privilege check failed: %v", err)
+			return err
+		}
+	} else if dataOnly && cfg.Options.Privileges != "" && cfg.Options.Privileges != postgres.PrivilegesReset {
+		log.Info("Privileges are restored with the structure only; a data-only restore leaves them as they are")
+	}
+
 	if dryRun {
+		if len(privileges) > 0 {
+			log.DryRun("Would restore %d privilege statements from the dump after ownership", len(privileges))
+		}
+		if restoreResume {
+			log.DryRun("Would resume restore: %d of %d data entries remaining",
+				len(postgres.PendingEntries(dataEntries, state)), len(dataEntries))
+		}
+		if shadow {
+			log.DryRun("Would restore into %s and swap it with %s", target.Database, cfg.Target.Database)
+		}
 		log.DryRun("Dry run mode - no changes will be made")
+		if cfg.Source.Host != "" {
+			if err := showOwnershipPlan(ctx, cfg, log); err != nil {
+				log.Warning("Failed to plan ownership: %v", err)
+			}
+		}
 		log.Success("Dry run completed successfully")
 		return nil
 	}
 
-	structureDump, dataDump := filesystem.GetDumpPaths(inputDir)
+	if err := confirmTarget(cfg.Target, "restore into", assumeYes); err != nil {
+		log.Error("%v", err)
+		return err
+	}
+
+	// Large objects are restored only when the data dump contains them
+	includeLargeObjects := false
+	if !structureOnly {
+		includeLargeObjects, err = postgres.DumpHasLargeObjects(dataInput.Path, dataInput.Decryptor)
+		if err != nil {
+			log.Warning("Failed to inspect data dump for large objects: %v", err)
+		} else if includeLargeObjects {
+			log.Info("Data dump contains large objects; they will be restored")
+		}
+	}
 
-	// Backup target (unless skipped)
-	if !skipBackup && !cfg.Options.SkipBackup {
+	if restoreResume {
+		log.Phase("Verify restored data")
+		reloaded, truncated, err := postgres.VerifyResume(ctx, target, dataEntries, state)
+		if err != nil {
+			log.Error("Failed to verify restored data: %v", err)
+			return err
+		}
+		for _, e := range reloaded {
+			log.Warning("%s.%s is recorded as loaded but missing on the target; it will be loaded again", e.Schema, e.Name)
+		}
+		for _, table := range truncated {
+			log.Warning("Truncated partially loaded table %s", table)
+		}
+		log.Success("Resuming restore: %d of %d data entries remaining",
+			len(postgres.PendingEntries(dataEntries, state)), len(dataEntries))
+	} else if state != nil {
+		if err := state.Save(); err != nil {
+			log.Error("Failed to write restore state: %v", err)
+			return err
+		}
+		log.Info("Recording restore progress in %s", state.Path())
+	}
+
+	// Backup target (unless skipped or resuming, in which case the backup
+	// was taken by the interrupted run)
+	if !skipBackup && !cfg.Options.SkipBackup && !restoreResume {
 		log.Phase("Backup target database")
 
-		backupFile := filesystem.GetBackupPath(inputDir)
+		backupFile := filesystem.GetBackupPath(localDir)
 		if err := postgres.BackupDatabase(
 			cfg.Target.Host,
 			cfg.Target.Port,
@@ -118,77 +387,422 @@ func runRestore(cmd *cobra.Command, args []string) error {
 			cfg.Target.AdminPassword,
 			cfg.Target.Database,
 			backupFile,
+			enc,
 		); err != nil {
 			log.Error("Backup failed: %v", err)
 			return err
 		}
 		log.Success("Backup completed: %s", backupFile)
+
+		// Keep the backup next to the dumps it was taken for, under a name
+		// of its own
+		if remote != nil {
+			key := filesystem.GetRestoreBackupName(time.Now())
+			if err := remote.Put(ctx, backupFile, key); err != nil {
+				log.Error("Failed to upload backup: %v", err)
+				return err
+			}
+			log.Success("Backup uploaded: %s", remote.Location(key))
+		}
 	}
 
-	// Prepare target (terminate connections, drop/recreate schema, create extensions)
-	log.Phase("Prepare target database")
-	log.Info("Preparing target database...")
-	if err := postgres.PrepareTarget(ctx, cfg.Target, cfg.Options.Extensions); err != nil {
-		log.Error("Failed to prepare target: %v", err)
-		return err
+	// Prepare target (close sessions, drop/recreate schema, create extensions).
+	// A selective restore keeps the rest of the target intact and instead drops
+	// only the objects it recreates.
+	var blocked []postgres.ConnectGrant
+	if restoreResume {
+		log.Info("Resuming: skipping target preparation, restored objects are kept")
+	} else if shadow {
+		log.Phase("Create shadow database")
+		if err := postgres.CreateShadowDatabase(ctx, cfg.Target, target.Database); err != nil {
+			log.Error("Failed to create shadow database: %v", err)
+			return err
+		}
+		if err := postgres.PrepareTarget(ctx, target, extensions); err != nil {
+			log.Error("Failed to prepare shadow database: %v", err)
+			return err
+		}
+		log.Success("Shadow database %s created; %s stays online during the restore", target.Database, cfg.Target.Database)
+	} else if selective {
+		log.Info("Selective restore: skipping target preparation, existing objects are kept")
+		if dataOnly {
+			log.Warning("Data-only selective restore appends to existing tables; truncate them first to avoid duplicate rows")
+		}
+	} else {
+		log.Phase("Prepare target database")
+		reopen, revoked, err := drainTarget(ctx, cfg, target, log)
+		if err != nil {
+			log.Error("Failed to close sessions on target: %v", err)
+			return err
+		}
+		defer reopen()
+		blocked = revoked
+
+		log.Info("Preparing target database...")
+		if err := postgres.PrepareTarget(ctx, target, extensions); err != nil {
+			log.Error("Failed to prepare target: %v", err)
+			return err
+		}
+		log.Success("Target prepared (schema recreated, extensions created)")
 	}
-	log.Success("Target prepared (schema recreated, extensions created)")
 
-	// Restore structure (unless data-only)
-	if !dataOnly {
-		log.Phase("Restore database structure")
-		log.Info("Restoring database structure (parallel jobs: %d)...", cfg.Options.ParallelJobs)
-		if err := postgres.RestoreStructure(postgres.RestoreOptions{
-			Host:         cfg.Target.Host,
-			Port:         cfg.Target.Port,
-			User:         cfg.Target.AdminUser,
-			Password:     cfg.Target.AdminPassword,
-			Database:     cfg.Target.Database,
+	// Restore the structure in two sections around the data: pre-data
+	// (tables, types, functions) first, and post-data (indexes, constraints,
+	// triggers) once the rows are loaded
+	restoreStructure := !dataOnly && (!selective || structureList != "")
+
+	// Restore pre-data (unless data-only)
+	if restoreStructure && !restoreResume {
+		log.Phase("Restore pre-data section")
+		sectionStart := time.Now()
+		log.Info("Restoring pre-data section (parallel jobs: %d)...", cfg.Options.ParallelJobs)
+		preDataDiag := postgres.NewDiagnosticLog(ignoreRules)
+		err = postgres.RestoreSection(postgres.RestoreOptions{
+			Host:         target.Host,
+			Port:         target.Port,
+			User:         target.AdminUser,
+			Password:     target.AdminPassword,
+			Database:     target.Database,
 			Schema:       "public",
-			InputFile:    structureDump,
+			InputFile:    structureInput.Path,
 			ParallelJobs: cfg.Options.ParallelJobs,
-		}); err != nil {
-			log.Error("Structure restore failed: %v", err)
+			Decryptor:    structureInput.Decryptor,
+			ListFile:     structureList,
+			Clean:        selective,
+			Diagnostics:  preDataDiag,
+		}, postgres.SectionPreData)
+		logDiagnostics(log, "Pre-data restore", preDataDiag)
+		if err != nil {
+			log.Error("Pre-data restore failed: %v", err)
 			return err
 		}
-		log.Success("Structure restored successfully")
+		log.Success("Pre-data restored in %s", time.Since(sectionStart).Round(time.Second))
+
+		if state != nil {
+			state.PreDataDone = true
+			if err := state.Save(); err != nil {
+				log.Error("Failed to write restore state: %v", err)
+				return err
+			}
+		}
 	}
 
 	// Restore data (unless structure-only)
-	if !structureOnly {
+	if tracked {
+		log.Phase("Restore database data")
+		sectionStart := time.Now()
+		pending := postgres.PendingEntries(dataEntries, state)
+		log.Info("Restoring %d data entries (parallel jobs: %d)...", len(pending), cfg.Options.DataParallelJobs)
+
+		done := 0
+		dataDiag := postgres.NewDiagnosticLog(ignoreRules)
+		err := postgres.RestoreDataEntries(ctx, postgres.RestoreOptions{
+			Host:         target.Host,
+			Port:         target.Port,
+			User:         target.AdminUser,
+			Password:     target.AdminPassword,
+			Database:     target.Database,
+			InputFile:    dataInput.Path,
+			ParallelJobs: cfg.Options.DataParallelJobs,
+			Diagnostics:  dataDiag,
+		}, pending, state, func(e postgres.TOCEntry) {
+			done++
+			log.Debug("Restored %s %s.%s (%d/%d)", e.Type, e.Schema, e.Name, done, len(pending))
+		})
+		logDiagnostics(log, "Data restore", dataDiag)
+		if err != nil {
+			log.Error("Data restore failed after %d of %d entries: %v", done, len(pending), err)
+			log.Info("Fix the cause and run restore again with --resume to continue")
+			return err
+		}
+		log.Success("Data restored in %s", time.Since(sectionStart).Round(time.Second))
+	} else if !structureOnly && (!selective || dataList != "") {
 		log.Phase("Restore database data")
+		sectionStart := time.Now()
 		log.Info("Restoring database data (parallel jobs: %d)...", cfg.Options.DataParallelJobs)
-		if err := postgres.RestoreData(postgres.RestoreOptions{
-			Host:         cfg.Target.Host,
-			Port:         cfg.Target.Port,
-			User:         cfg.Target.AdminUser,
-			Password:     cfg.Target.AdminPassword,
-			Database:     cfg.Target.Database,
+		dataDiag := postgres.NewDiagnosticLog(ignoreRules)
+		err = postgres.RestoreData(postgres.RestoreOptions{
+			Host:         target.Host,
+			Port:         target.Port,
+			User:         target.AdminUser,
+			Password:     target.AdminPassword,
+			Database:     target.Database,
 			Schema:       "public",
-			InputFile:    dataDump,
+			InputFile:    dataInput.Path,
 			ParallelJobs: cfg.Options.DataParallelJobs,
-		}); err != nil {
+			Decryptor:    dataInput.Decryptor,
+			LargeObjects: includeLargeObjects,
+			ListFile:     dataList,
+			Diagnostics:  dataDiag,
+		})
+		logDiagnostics(log, "Data restore", dataDiag)
+		if err != nil {
 			log.Error("Data restore failed: %v", err)
 			return err
 		}
-		log.Success("Data restored successfully")
+		log.Success("Data restored in %s", time.Since(sectionStart).Round(time.Second))
+	}
+
+	// Restore post-data (unless data-only). A resumed restore may have
+	// created some of it already, so existing objects are replaced.
+	if restoreStructure {
+		log.Phase("Restore post-data section")
+		sectionStart := time.Now()
+		log.Info("Restoring post-data section (parallel jobs: %d)...", cfg.Options.PostDataParallelJobs)
+		postDataDiag := postgres.NewDiagnosticLog(ignoreRules)
+		err = postgres.RestoreSection(postgres.RestoreOptions{
+			Host:         target.Host,
+			Port:         target.Port,
+			User:         target.AdminUser,
+			Password:     target.AdminPassword,
+			Database:     target.Database,
+			Schema:       "public",
+			InputFile:    structureInput.Path,
+			ParallelJobs: cfg.Options.PostDataParallelJobs,
+			Decryptor:    structureInput.Decryptor,
+			ListFile:     structureList,
+			Clean:        selective || restoreResume,
+			Diagnostics:  postDataDiag,
+		}, postgres.SectionPostData)
+		logDiagnostics(log, "Post-data restore", postDataDiag)
+		if err != nil {
+			log.Error("Post-data restore failed: %v", err)
+			if state != nil {
+				log.Info("Fix the cause and run restore again with --resume to continue")
+			}
+			return err
+		}
+		log.Success("Post-data restored in %s", time.Since(sectionStart).Round(time.Second))
+	}
+
+	// Post-restore maintenance (if configured)
+	if !structureOnly && maintenanceEnabled(cfg.Options.Maintenance) {
+		log.Phase("Post-restore maintenance")
+		runMaintenance(ctx, cfg.Options.Maintenance, target, log)
 	}
 
 	// Configure ownership
 	log.Phase("Configure ownership")
-	if err := postgres.CreateAppUserIfNotExists(ctx, cfg.Target, cfg.Target.AppUser); err != nil {
+	if err := ensureAppUser(ctx, cfg, target, log); err != nil {
 		log.Error("Failed to create app user: %v", err)
 		return err
 	}
 
-	log.Info("Transferring ownership to %s...", cfg.Target.AppUser)
-	if err := postgres.TransferOwnership(ctx, cfg.Target, cfg.Target.AppUser); err != nil {
+	// Source owners come from the structure dump, or from the source
+	// database for a data-only restore
+	mapping, err := roleMapping(ctx, cfg, structureInput.Path == "" && cfg.Source.Host != "", structureInput, log)
+	if err != nil {
+		log.Error("Failed to read source owners: %v", err)
+		return err
+	}
+	if err := transferOwnership(ctx, cfg, target, mapping, blocked, filepath.Dir(statePath), log); err != nil {
 		log.Error("Ownership transfer failed: %v", err)
 		return err
 	}
+	if err := restoreDumpPrivileges(ctx, target, privileges, log); err != nil {
+		log.Error("%v", err)
+		return err
+	}
 	log.Success("Ownership configured successfully")
 
+	// Validate the shadow database and swap it in
+	if shadow {
+		log.Phase("Validate shadow database")
+		if err := validateShadow(ctx, cfg, target, structureInput, log); err != nil {
+			log.Error("Shadow database validation failed: %v", err)
+			log.Info("%s was left untouched; the restored data remains in %s for inspection", cfg.Target.Database, target.Database)
+			return err
+		}
+
+		log.Phase("Swap databases")
+		retired := postgres.RetiredDatabaseName(cfg.Target.Database, time.Now())
+		downtime, err := postgres.SwapDatabases(ctx, cfg.Target, target.Database, retired,
+			cfg.Options.DrainTimeout, cfg.Options.TerminateConnections())
+		if err != nil {
+			log.Error("Swap failed: %v", err)
+			log.Info("%s was left untouched; the restored data remains in %s", cfg.Target.Database, target.Database)
+			return err
+		}
+		log.Success("Swapped %s into place (unavailable for %s)", cfg.Target.Database, downtime.Round(time.Millisecond))
+		log.Info("Previous database kept as %s; rename it back to roll back", retired)
+	}
+
+	if state != nil {
+		if err := state.Remove(); err != nil {
+			log.Warning("%v", err)
+		}
+	}
+
 	log.Success("Restore completed in %s", time.Since(startTime).Round(time.Second))
 
+	return nil
+}
+
+// buildRestoreLists writes pg_restore list files selecting the requested
+// entries of the structure and data dumps. An archive with nothing selected
+// gets an empty path so that its restore is skipped.
+func buildRestoreLists(ctx context.Context, target config.TargetConfig, sel postgres.RestoreSelection, structureInput, dataInput dumpInput, log *logger.Logger) (structureList, dataList string, err error) {
+	exists, closeChecker, err := postgres.TargetObjectChecker(ctx, target)
+	if err != nil {
+		return "", "", err
+	}
+	defer closeChecker()
+
+	dataSel := sel
+	if !dataOnly {
+		entries, err := postgres.ReadTOC(structureInput.Path, structureInput.Decryptor)
+		if err != nil {
+			return "", "", err
+		}
+
+		selected, err := postgres.SelectTOCEntries(entries, sel, exists)
+		if err != nil {
+			return "", "", err
+		}
+		log.Info("Selected %d of %d structure entries", len(selected), len(entries))
+		for _, e := range selected {
+			log.Debug("  %s %s.%s", e.Type, e.Schema, e.Name)
+		}
+
+		if len(selected) > 0 {
+			if structureList, err = writeRestoreList(selected, "structure"); err != nil {
+				return "", "", err
+			}
+		}
+
+		// Data follows the relations chosen from the structure, including
+		// the sequences they own but not relations pulled in as dependencies
+		if len(sel.Tables) > 0 {
+			direct, err := postgres.SelectTOCEntries(entries, sel, func(postgres.TOCEntry) (bool, error) { return true, nil })
+			if err != nil {
+				return "", "", err
+			}
+			dataSel.Tables = postgres.RelationNames(direct)
+		}
+	}
+
+	if !structureOnly && (len(sel.Tables) == 0 || len(dataSel.Tables) > 0) {
+		entries, err := postgres.ReadTOC(dataInput.Path, dataInput.Decryptor)
+		if err != nil {
+			return "", "", err
+		}
+
+		selected, err := postgres.SelectTOCEntries(entries, dataSel, exists)
+		if err != nil {
+			return "", "", err
+		}
+		log.Info("Selected %d of %d data entries", len(selected), len(entries))
+		for _, e := range selected {
+			log.Debug("  %s %s.%s", e.Type, e.Schema, e.Name)
+		}
+
+		if len(selected) > 0 {
+			if dataList, err = writeRestoreList(selected, "data"); err != nil {
+				return "", "", err
+			}
+		}
+	}
+
+	if structureList == "" && dataList == "" {
+		return "", "", fmt.Errorf("no dump entries match the requested tables and object types")
+	}
+
+	return structureList, dataList, nil
+}
+
+// writeRestoreList writes selected entries to a temporary list file
+func writeRestoreList(entries []postgres.TOCEntry, name string) (string, error) {
+	f, err := os.CreateTemp("", "cloudm-"+name+"-*.list")
+	if err != nil {
+		return "", fmt.Errorf("failed to create restore list: %w", err)
+	}
+	f.Close()
+
+	if err := postgres.WriteTOCList(entries, f.Name()); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// readRestoreList reads the entries of a pg_restore list file
+func readRestoreList(path string) ([]postgres.TOCEntry, error) {
+	listing, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read restore list: %w", err)
+	}
+	return postgres.ParseTOC(string(listing))
+}
+
+// loadResumeState loads the state of an interrupted restore and applies the
+// options it was started with
+func loadResumeState(statePath string, target config.TargetConfig, log *logger.Logger) (*postgres.RestoreState, error) {
+	state, err := postgres.LoadRestoreState(statePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("no interrupted restore found (%s missing)", statePath)
+		}
+		return nil, err
+	}
+
+	if state.Target != postgres.RestoreTarget(target) {
+		return nil, fmt.Errorf("the interrupted restore targeted %s, not %s", state.Target, postgres.RestoreTarget(target))
+	}
+
+	// Only data restores record their progress
+	if structureOnly || state.DataOnly != dataOnly {
+		log.Info("Using the options of the interrupted restore (data-only: %t)", state.DataOnly)
+		structureOnly, dataOnly = false, state.DataOnly
+	}
+
+	if !dataOnly && !state.PreDataDone {
+		return nil, fmt.Errorf("the pre-data restore did not complete; run restore without --resume to start over")
+	}
+
+	log.Info("Resuming restore started at %s", state.StartedAt.Format(time.RFC3339))
+	return state, nil
+}
+
+// validateShadow checks the shadow database before it is swapped in: every
+// table of the structure dump must exist in it. Row counts are compared
+// with the source only as a warning, since the source keeps changing after
+// the dump and both counts are statistics estimates.
+func validateShadow(ctx context.Context, cfg *config.Config, shadow config.TargetConfig, structure dumpInput, log *logger.Logger) error {
+	entries, err := postgres.ReadTOC(structure.Path, structure.Decryptor)
+	if err != nil {
+		return err
+	}
+	missing, err := postgres.MissingTables(ctx, shadow, entries)
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("tables of the dump missing from %s: %s", shadow.Database, strings.Join(missing, ", "))
+	}
+	log.Success("Shadow database has every table of the dump")
+
+	if cfg.Source.Host == "" || cfg.Source.Database == "" {
+		log.Info("No source database configured; skipping row count comparison")
+		return nil
+	}
+
+	sourceStats, err := postgres.GetTableStats(ctx, cfg.Source, "public")
+	if err != nil {
+		log.Warning("Failed to get source stats: %v", err)
+		return nil
+	}
+	targetStats, err := postgres.GetTargetTableStats(ctx, shadow, "public")
+	if err != nil {
+		log.Warning("Failed to get shadow database stats: %v", err)
+		return nil
+	}
+
+	report, hasDiscrepancy := postgres.CompareTableStats(sourceStats, targetStats)
+	log.Debug("%s", report)
+	if hasDiscrepancy {
+		log.Warning("Estimated row counts of %s differ from the source, which may have changed since the dump:\n%s", shadow.Database, report)
+	} else {
+		log.Success("Estimated row counts match the source (%d tables)", len(sourceStats))
+	}
 	return nil
 }
\ No newline at end of file
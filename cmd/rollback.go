@@ -0,0 +1,174 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/1CL0UD/cloudm-cli/internal/config"
+	"github.com/1CL0UD/cloudm-cli/internal/filesystem"
+	"github.com/1CL0UD/cloudm-cli/internal/logger"
+	"github.com/1CL0UD/cloudm-cli/internal/postgres"
+	"github.com/1CL0UD/cloudm-cli/pkg/executor"
+	"github.com/spf13/cobra"
+)
+
+var (
+	rollbackID     string
+	rollbackLatest bool
+	rollbackDir    string
+)
+
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback",
+	Short: "Restore a pre-migration backup",
+	Long: `List the pre-migration backups kept in the migration directories, or restore
+one of them onto the target database. Without --id or --latest the backups are
+listed and nothing is restored.`,
+	RunE: runRollback,
+}
+
+func init() {
+	rollbackCmd.Flags().StringVar(&rollbackID, "id", "", "id of the backup to restore, as listed")
+	rollbackCmd.Flags().BoolVar(&rollbackLatest, "latest", false, "restore the most recent backup")
+	rollbackCmd.Flags().StringVar(&rollbackDir, "dir", "", "directory holding the migration directories (default is options.output_dir)")
+}
+
+func runRollback(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+	startTime := time.Now()
+
+	// Initialize logger
+	log, err := logger.New(logger.LoggerOptions{
+		Verbose: verbose,
+		LogFile: logFile,
+		NoColor: noColor,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to initialize logger: %w", err)
+	}
+	defer log.Close()
+
+	if rollbackID != "" && rollbackLatest {
+		return fmt.Errorf("--id and --latest cannot be used together")
+	}
+
+	// Load configuration
+	configPath := cfgFile
+	if configPath == "" {
+		configPath = "db.yaml"
+	}
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		log.Error("Failed to load configuration: %v", err)
+		return err
+	}
+
+	baseDir := rollbackDir
+	if baseDir == "" {
+		baseDir = cfg.Options.OutputDir
+	}
+	if baseDir == "" {
+		baseDir = "./migrations"
+	}
+
+	backups, err := filesystem.ListBackups(baseDir)
+	if err != nil {
+		log.Error("Failed to list backups: %v", err)
+		return err
+	}
+
+	if rollbackID == "" && !rollbackLatest {
+		printBackups(baseDir, backups)
+		return nil
+	}
+
+	if len(backups) == 0 {
+		return fmt.Errorf("no backups found in %s", baseDir)
+	}
+	backup := backups[0]
+	if rollbackID != "" {
+		if backup, err = filesystem.FindBackup(backups, rollbackID); err != nil {
+			log.Error("%v", err)
+			return err
+		}
+	}
+
+	log.Info("Selected backup %s: %s (%s)", backup.ID, backup.Path, backup.SizeString())
+
+	// Validate target configuration
+	if cfg.Target.Host == "" || cfg.Target.Database == "" {
+		log.Error("Target database configuration is incomplete")
+		return fmt.Errorf("target database configuration is incomplete")
+	}
+
+	// A backup is only restored onto the database it was taken from
+	target := postgres.RestoreTarget(cfg.Target)
+	if backup.Target == "" {
+		log.Warning("Backup %s does not record its source database; make sure it belongs to %s", backup.ID, target)
+	} else if backup.Target != target {
+		log.Error("Backup %s was taken from %s, but the configured target is %s", backup.ID, backup.Target, target)
+		return fmt.Errorf("backup %s does not belong to target %s", backup.ID, target)
+	}
+
+	if err := checkProtected(cfg, cfg.Target); err != nil {
+		log.Error("%v", err)
+		return err
+	}
+
+	// Initialize executor
+	exec := executor.New(log, dryRun)
+
+	// Check required tools
+	if err := exec.CheckRequiredTools(); err != nil {
+		log.Error("Pre-flight check failed: %v", err)
+		return err
+	}
+
+	// Test connection
+	if err := postgres.TestTargetConnection(cfg.Target); err != nil {
+		log.Error("Failed to connect to target database: %v", err)
+		return err
+	}
+	log.Success("Connected to target database: %s/%s", cfg.Target.Host, cfg.Target.Database)
+
+	if dryRun {
+		log.DryRun("Would restore %s onto %s/%s", backup.Path, cfg.Target.Host, cfg.Target.Database)
+		log.Success("Dry run completed successfully")
+		return nil
+	}
+
+	if err := confirmTarget(cfg.Target, "roll back", assumeYes); err != nil {
+		log.Error("%v", err)
+		return err
+	}
+
+	log.Phase("ROLLBACK: Restore backup " + backup.ID)
+	if err := restoreBackup(ctx, cfg, backup.Path, nil, log); err != nil {
+		log.Error("Rollback failed: %v", err)
+		return err
+	}
+
+	log.Success("Target rolled back to %s in %s", backup.ID, time.Since(startTime).Round(time.Second))
+	return nil
+}
+
+// printBackups prints the backups found under a directory as a table
+func printBackups(baseDir string, backups []filesystem.BackupInfo) {
+	if len(backups) == 0 {
+		fmt.Printf("No backups found in %s\n", baseDir)
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tCREATED\tTARGET\tSIZE")
+	for _, b := range backups {
+		target := b.Target
+		if target == "" {
+			target = "unknown"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", b.ID, b.CreatedAt.Format("2006-01-02 15:04:05"), target, b.SizeString())
+	}
+	w.Flush()
+}
\ No newline at end of file
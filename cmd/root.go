@@ -1,16 +1,22 @@
 package cmd
 
 import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
 var (
-	cfgFile  string
-	dryRun   bool
-	verbose  bool
-	noColor  bool
-	logFile  string
+	cfgFile   string
+	dryRun    bool
+	verbose   bool
+	noColor   bool
+	logFile   string
+	assumeYes bool
 )
 
 var rootCmd = &cobra.Command{
@@ -20,7 +26,11 @@ var rootCmd = &cobra.Command{
 }
 
 func Execute() error {
-	return rootCmd.Execute()
+	// An interrupt cancels the context of the running command, which then
+	// returns through its deferred cleanup
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return rootCmd.ExecuteContext(ctx)
 }
 
 func init() {
@@ -32,13 +42,19 @@ func init() {
 	rootCmd.PersistentFlags().BoolVar(&verbose, "verbose", false, "verbose logging")
 	rootCmd.PersistentFlags().BoolVar(&noColor, "no-color", false, "disable colored output")
 	rootCmd.PersistentFlags().StringVar(&logFile, "log-file", "", "custom log file path")
+	rootCmd.PersistentFlags().BoolVar(&assumeYes, "yes", false, "confirm destructive operations when not running in a terminal")
 
 	// Add subcommands
 	rootCmd.AddCommand(migrateCmd)
 	rootCmd.AddCommand(dumpCmd)
 	rootCmd.AddCommand(restoreCmd)
 	rootCmd.AddCommand(backupCmd)
+	rootCmd.AddCommand(rollbackCmd)
+	rootCmd.AddCommand(ownershipCmd)
+	rootCmd.AddCommand(appUserCmd)
 	rootCmd.AddCommand(validateCmd)
+	rootCmd.AddCommand(inspectCmd)
+	rootCmd.AddCommand(keygenCmd)
 	rootCmd.AddCommand(versionCmd)
 }
 
@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/1CL0UD/cloudm-cli/internal/config"
+	"github.com/1CL0UD/cloudm-cli/internal/postgres"
+)
+
+// checkProtected refuses to modify a target matching one of the configured
+// protected_targets patterns. A pattern is matched against the database
+// name, host/database and host:port/database.
+func checkProtected(cfg *config.Config, target config.TargetConfig) error {
+	names := []string{
+		target.Database,
+		fmt.Sprintf("%s/%s", target.Host, target.Database),
+		fmt.Sprintf("%s:%d/%s", target.Host, target.Port, target.Database),
+	}
+
+	for _, pattern := range cfg.Options.ProtectedTargets {
+		for _, name := range names {
+			// An invalid pattern protects everything rather than nothing
+			if matched, err := path.Match(pattern, name); matched || err != nil {
+				return fmt.Errorf("target %s is protected by pattern %q in options.protected_targets", names[2], pattern)
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkNotSource refuses to modify the target when it is the source
+// database itself
+func checkNotSource(ctx context.Context, cfg *config.Config) error {
+	same, err := postgres.SameDatabase(ctx, cfg.Source, cfg.Target)
+	if err != nil {
+		return err
+	}
+	if same {
+		return fmt.Errorf("source and target are the same database (%s/%s); refusing to overwrite the source",
+			cfg.Target.Host, cfg.Target.Database)
+	}
+	return nil
+}
+
+// confirmTarget asks for the name of the target database to be typed before
+// it is overwritten. With a terminal attached the prompt is always shown;
+// without one the operation is refused unless assumeYes is set.
+func confirmTarget(target config.TargetConfig, action string, assumeYes bool) error {
+	if !isInteractive() {
+		if assumeYes {
+			return nil
+		}
+		return fmt.Errorf("refusing to %s %s/%s without confirmation; use --yes in non-interactive mode",
+			action, target.Host, target.Database)
+	}
+
+	fmt.Printf("This will %s database %s on %s. All current data in it will be replaced.\n",
+		action, target.Database, target.Host)
+	fmt.Printf("Type the database name to continue: ")
+
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("failed to read confirmation: %w", err)
+	}
+	if strings.TrimSpace(answer) != target.Database {
+		return fmt.Errorf("confirmation did not match %s, aborting", target.Database)
+	}
+
+	return nil
+}
+
+// isInteractive reports whether standard input is a terminal
+func isInteractive() bool {
+	stat, err := os.Stdin.Stat()
+	if err != nil {
+		return false
+	}
+	return stat.Mode()&os.ModeCharDevice != 0
+}
\ No newline at end of file
@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/1CL0UD/cloudm-cli/internal/config"
+	"github.com/1CL0UD/cloudm-cli/internal/logger"
+	"github.com/1CL0UD/cloudm-cli/internal/postgres"
+)
+
+// drainTarget closes the sessions on the target before it is prepared. With
+// drain_timeout set, CONNECT is revoked from every role but the admin user
+// and sessions get that long to end on their own; those left are
+// terminated when terminate_connections allows it. The returned function
+// gives CONNECT back and must be called once the target may be used again,
+// including after a failure. The grants it gives back are returned too.
+func drainTarget(ctx context.Context, cfg *config.Config, target config.TargetConfig, log *logger.Logger) (func(), []postgres.ConnectGrant, error) {
+	reopen := func() {}
+	database := target.Database
+
+	var remaining []postgres.Session
+	var revoked []postgres.ConnectGrant
+	var err error
+	if cfg.Options.DrainTimeout > 0 {
+		var kept []string
+		revoked, kept, err = postgres.BlockConnections(ctx, target, database)
+		if err != nil {
+			return reopen, nil, err
+		}
+		if len(revoked) > 0 {
+			log.Info("Revoked CONNECT on %s from %s", database, connectGrantees(revoked))
+			reopen = func() {
+				if err := postgres.AllowConnections(context.Background(), target, database, revoked); err != nil {
+					log.Warning("Failed to restore CONNECT on %s: %v", database, err)
+					return
+				}
+				log.Info("Restored CONNECT on %s", database)
+			}
+		}
+		if len(kept) > 0 {
+			log.Warning("%s still hold CONNECT on %s, granted by another role, and can reconnect while sessions drain",
+				strings.Join(kept, ", "), database)
+		}
+
+		log.Info("Waiting up to %s for sessions on %s to end...", cfg.Options.DrainTimeout, database)
+		remaining, err = postgres.DrainSessions(ctx, target, database, cfg.Options.DrainTimeout, func(sessions []postgres.Session) {
+			log.Info("%d sessions still connected", len(sessions))
+			logSessions(log, sessions)
+		})
+		if err != nil {
+			reopen()
+			return func() {}, nil, err
+		}
+	} else if remaining, err = postgres.ListSessions(ctx, target, database); err != nil {
+		return reopen, nil, err
+	}
+
+	if len(remaining) == 0 {
+		log.Success("No sessions connected to %s", database)
+		return reopen, revoked, nil
+	}
+
+	if !cfg.Options.TerminateConnections() {
+		logSessions(log, remaining)
+		reopen()
+		return func() {}, nil, fmt.Errorf("%d sessions still connected to %s and terminate_connections is disabled", len(remaining), database)
+	}
+
+	log.Info("Terminating %d sessions on %s...", len(remaining), database)
+	if err := postgres.TerminateConnections(ctx, target, database); err != nil {
+		reopen()
+		return func() {}, nil, err
+	}
+
+	return reopen, revoked, nil
+}
+
+// connectGrantees lists the roles of CONNECT grants
+func connectGrantees(grants []postgres.ConnectGrant) string {
+	names := make([]string, len(grants))
+	for i, g := range grants {
+		names[i] = g.Role
+		if g.Role == "" {
+			names[i] = "PUBLIC"
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// logSessions lists sessions with what they are doing
+func logSessions(log *logger.Logger, sessions []postgres.Session) {
+	for _, s := range sessions {
+		log.Info("  pid %d: user=%s application=%q state=%s query age=%s",
+			s.PID, s.User, s.ApplicationName, s.State, s.QueryAge.Round(time.Second))
+	}
+}
\ No newline at end of file
@@ -1,7 +1,6 @@
 package cmd
 
 import (
-	"context"
 	"fmt"
 	"time"
 
@@ -28,7 +27,7 @@ func init() {
 }
 
 func runValidate(cmd *cobra.Command, args []string) error {
-	ctx := context.Background()
+	ctx := cmd.Context()
 
 	// Initialize logger
 	log, err := logger.New(logger.LoggerOptions{
@@ -119,6 +118,31 @@ func runValidate(cmd *cobra.Command, args []string) error {
 		}
 	}
 
+	// Compare large objects
+	sourceLargeObjects, err := postgres.GetLargeObjectStats(ctx, cfg.Source)
+	if err != nil {
+		log.Warning("Failed to get source large object stats: %v", err)
+	} else if sourceLargeObjects.Count > 0 {
+		targetLargeObjects, err := postgres.GetTargetLargeObjectStats(ctx, cfg.Target)
+		if err != nil {
+			log.Warning("Failed to get target large object stats: %v", err)
+		} else {
+			loReport, loDiscrepancy := postgres.CompareLargeObjectStats(sourceLargeObjects, targetLargeObjects)
+			if detailed {
+				fmt.Println(loReport)
+			}
+			if err := logger.AppendLargeObjectReport(sourceLargeObjects, targetLargeObjects, reportPath); err != nil {
+				log.Warning("Failed to add large objects to validation report: %v", err)
+			}
+			if loDiscrepancy {
+				hasDiscrepancy = true
+				log.Warning("Large objects differ: source %d (%d bytes), target %d (%d bytes)",
+					sourceLargeObjects.Count, sourceLargeObjects.TotalBytes,
+					targetLargeObjects.Count, targetLargeObjects.TotalBytes)
+			}
+		}
+	}
+
 	// Summary
 	if hasDiscrepancy {
 		log.Warning("⚠ Discrepancies found between source and target databases!")
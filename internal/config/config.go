@@ -1,9 +1,16 @@
 package config
 
+import (
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
 type Config struct {
 	Source  DatabaseConfig   `yaml:"source"`
 	Target  TargetConfig     `yaml:"target"`
 	Options MigrationOptions `yaml:"options"`
+	Grants  []GrantConfig    `yaml:"grants"`
 }
 
 type DatabaseConfig struct {
@@ -17,19 +24,140 @@ type DatabaseConfig struct {
 
 type TargetConfig struct {
 	DatabaseConfig  `yaml:",inline"`
-	AdminUser       string `yaml:"admin_user"`
-	AdminPassword   string `yaml:"admin_password"`
-	AppUser         string `yaml:"app_user"`
-	AppUserPassword string `yaml:"app_user_password"`
+	AdminUser       string       `yaml:"admin_user"`
+	AdminPassword   string       `yaml:"admin_password"`
+	AppUser         string       `yaml:"app_user"`
+	AppUserPassword string       `yaml:"app_user_password"`
+	AppUserSecret   SecretConfig `yaml:"app_user_secret"`
+}
+
+// SecretConfig chooses where a generated password is kept: a file readable
+// only by its owner, a credential helper command that reads the password
+// on stdin, or both
+type SecretConfig struct {
+	File    string   `yaml:"file"`
+	Command []string `yaml:"command"`
 }
 
 type MigrationOptions struct {
-	ParallelJobs     int      `yaml:"parallel_jobs"`
-	DataParallelJobs int      `yaml:"data_parallel_jobs"`
-	ExcludeTables    []string `yaml:"exclude_tables"`
-	OutputDir        string   `yaml:"output_dir"`
-	KeepDumps        bool     `yaml:"keep_dumps"`
-	SkipBackup       bool     `yaml:"skip_backup"`
-	TerminateConns   bool     `yaml:"terminate_connections"`
-	Extensions       []string `yaml:"extensions"`
+	ParallelJobs         int                 `yaml:"parallel_jobs"`
+	DataParallelJobs     int                 `yaml:"data_parallel_jobs"`
+	PostDataParallelJobs int                 `yaml:"post_data_parallel_jobs"`
+	ExcludeTables        []string            `yaml:"exclude_tables"`
+	OutputDir            string              `yaml:"output_dir"`
+	KeepDumps            bool                `yaml:"keep_dumps"`
+	SkipBackup           bool                `yaml:"skip_backup"`
+	RollbackOnFailure    bool                `yaml:"rollback_on_failure"`
+	ProtectedTargets     []string            `yaml:"protected_targets"`
+	RoleMap              RoleMapConfig       `yaml:"role_map"`
+	SaveOwnershipSQL     bool                `yaml:"save_ownership_sql"`
+	Privileges           string              `yaml:"privileges"`
+	TerminateConns       *bool               `yaml:"terminate_connections"`
+	DrainTimeout         time.Duration       `yaml:"drain_timeout"`
+	Extensions           []ExtensionConfig   `yaml:"extensions"`
+	SkipLargeObjects     bool                `yaml:"skip_large_objects"`
+	LargeObjectBatches   bool                `yaml:"large_object_batches"`
+	Encryption           EncryptionConfig    `yaml:"encryption"`
+	Storage              StorageConfig       `yaml:"storage"`
+	RestoreIgnore        []RestoreIgnoreRule `yaml:"restore_ignore"`
+	Maintenance          MaintenanceConfig   `yaml:"maintenance"`
+}
+
+// TerminateConnections reports whether sessions left on the target are
+// terminated before it is prepared, which is the default
+func (o MigrationOptions) TerminateConnections() bool {
+	return o.TerminateConns == nil || *o.TerminateConns
+}
+
+// RoleMapConfig maps the owners of source objects to target roles. Objects
+// of unmapped owners go to the default role, which is the app user unless
+// set. Schemas lists the schemas whose objects change owner, public by
+// default.
+type RoleMapConfig struct {
+	Default string            `yaml:"default"`
+	Roles   map[string]string `yaml:"roles"`
+	Schemas []string          `yaml:"schemas"`
+}
+
+// ExtensionConfig names an extension to create on the target. Schema and
+// version override what is found on the source; skip leaves it out.
+type ExtensionConfig struct {
+	Name    string `yaml:"name"`
+	Schema  string `yaml:"schema"`
+	Version string `yaml:"version"`
+	Skip    bool   `yaml:"skip"`
+}
+
+// UnmarshalYAML accepts a bare extension name as well as a mapping
+func (e *ExtensionConfig) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*e = ExtensionConfig{Name: value.Value}
+		return nil
+	}
+	type plain ExtensionConfig
+	return value.Decode((*plain)(e))
+}
+
+// GrantConfig declares the privileges of a role on a database and the
+// objects of its schemas, as a profile (owner, readwrite, readonly or
+// custom) extended by explicit privileges
+type GrantConfig struct {
+	Role       string          `yaml:"role"`
+	Profile    string          `yaml:"profile"`
+	Schemas    []string        `yaml:"schemas"`
+	Privileges PrivilegeConfig `yaml:"privileges"`
+}
+
+// PrivilegeConfig lists privileges per object kind
+type PrivilegeConfig struct {
+	Database  []string `yaml:"database"`
+	Schema    []string `yaml:"schema"`
+	Tables    []string `yaml:"tables"`
+	Sequences []string `yaml:"sequences"`
+	Functions []string `yaml:"functions"`
+}
+
+// MaintenanceConfig selects the steps run after data is restored
+type MaintenanceConfig struct {
+	Analyze                  bool `yaml:"analyze"`
+	Vacuum                   bool `yaml:"vacuum"`
+	RefreshMaterializedViews bool `yaml:"refresh_materialized_views"`
+	ResetSequences           bool `yaml:"reset_sequences"`
+}
+
+// RestoreIgnoreRule marks matching pg_restore diagnostics as harmless
+type RestoreIgnoreRule struct {
+	Message  string `yaml:"message"`
+	Category string `yaml:"category"`
+	Object   string `yaml:"object"`
+	Reason   string `yaml:"reason"`
+}
+
+type EncryptionConfig struct {
+	Enabled        bool     `yaml:"enabled"`
+	Required       bool     `yaml:"required"`
+	Passphrase     string   `yaml:"passphrase"`
+	PassphraseFile string   `yaml:"passphrase_file"`
+	Recipients     []string `yaml:"recipients"`
+	IdentityFile   string   `yaml:"identity_file"`
+}
+
+type StorageConfig struct {
+	URL  string     `yaml:"url"`
+	S3   S3Config   `yaml:"s3"`
+	SFTP SFTPConfig `yaml:"sftp"`
+}
+
+type S3Config struct {
+	Endpoint     string `yaml:"endpoint"`
+	Region       string `yaml:"region"`
+	AccessKey    string `yaml:"access_key"`
+	SecretKey    string `yaml:"secret_key"`
+	SessionToken string `yaml:"session_token"`
+	PathStyle    bool   `yaml:"path_style"`
+}
+
+type SFTPConfig struct {
+	IdentityFile   string `yaml:"identity_file"`
+	KnownHostsFile string `yaml:"known_hosts_file"`
 }
\ No newline at end of file
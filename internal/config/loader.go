@@ -3,6 +3,8 @@ package config
 import (
 	"fmt"
 	"os"
+	"path"
+	"regexp"
 	"strings"
 
 	"gopkg.in/yaml.v3"
@@ -33,6 +35,9 @@ func Load(path string) (*Config, error) {
 	if cfg.Options.DataParallelJobs == 0 {
 		cfg.Options.DataParallelJobs = 2
 	}
+	if cfg.Options.PostDataParallelJobs == 0 {
+		cfg.Options.PostDataParallelJobs = cfg.Options.ParallelJobs
+	}
 	if cfg.Options.OutputDir == "" {
 		cfg.Options.OutputDir = "./migrations"
 	}
@@ -64,6 +69,25 @@ func ExpandEnvVars(cfg *Config) error {
 	cfg.Target.AdminPassword = expandString(cfg.Target.AdminPassword)
 	cfg.Target.AppUser = expandString(cfg.Target.AppUser)
 	cfg.Target.AppUserPassword = expandString(cfg.Target.AppUserPassword)
+	cfg.Target.AppUserSecret.File = expandString(cfg.Target.AppUserSecret.File)
+
+	// Expand encryption settings
+	cfg.Options.Encryption.Passphrase = expandString(cfg.Options.Encryption.Passphrase)
+	cfg.Options.Encryption.PassphraseFile = expandString(cfg.Options.Encryption.PassphraseFile)
+	cfg.Options.Encryption.IdentityFile = expandString(cfg.Options.Encryption.IdentityFile)
+	for i, r := range cfg.Options.Encryption.Recipients {
+		cfg.Options.Encryption.Recipients[i] = expandString(r)
+	}
+
+	// Expand storage settings
+	cfg.Options.Storage.URL = expandString(cfg.Options.Storage.URL)
+	cfg.Options.Storage.S3.Endpoint = expandString(cfg.Options.Storage.S3.Endpoint)
+	cfg.Options.Storage.S3.Region = expandString(cfg.Options.Storage.S3.Region)
+	cfg.Options.Storage.S3.AccessKey = expandString(cfg.Options.Storage.S3.AccessKey)
+	cfg.Options.Storage.S3.SecretKey = expandString(cfg.Options.Storage.S3.SecretKey)
+	cfg.Options.Storage.S3.SessionToken = expandString(cfg.Options.Storage.S3.SessionToken)
+	cfg.Options.Storage.SFTP.IdentityFile = expandString(cfg.Options.Storage.SFTP.IdentityFile)
+	cfg.Options.Storage.SFTP.KnownHostsFile = expandString(cfg.Options.Storage.SFTP.KnownHostsFile)
 
 	// If password file is specified, read password from file
 	if cfg.Source.PasswordFile != "" && cfg.Source.Password == "" {
@@ -82,6 +106,14 @@ func ExpandEnvVars(cfg *Config) error {
 		cfg.Target.Password = strings.TrimSpace(string(password))
 	}
 
+	if cfg.Options.Encryption.PassphraseFile != "" && cfg.Options.Encryption.Passphrase == "" {
+		passphrase, err := os.ReadFile(cfg.Options.Encryption.PassphraseFile)
+		if err != nil {
+			return fmt.Errorf("failed to read encryption passphrase file: %w", err)
+		}
+		cfg.Options.Encryption.Passphrase = strings.TrimSpace(string(passphrase))
+	}
+
 	return nil
 }
 
@@ -120,6 +152,13 @@ func Validate(cfg *Config) error {
 		errors = append(errors, "target.app_user is required")
 	}
 
+	errors = append(errors, validateEncryption(cfg.Options.Encryption)...)
+	errors = append(errors, validateRestoreIgnore(cfg.Options.RestoreIgnore)...)
+	errors = append(errors, validateExtensions(cfg.Options.Extensions)...)
+	errors = append(errors, validateProtectedTargets(cfg.Options.ProtectedTargets)...)
+	errors = append(errors, validateGrants(cfg.Grants)...)
+	errors = append(errors, validatePrivileges(cfg.Options.Privileges)...)
+
 	if len(errors) > 0 {
 		return fmt.Errorf("configuration validation failed:\n  - %s", strings.Join(errors, "\n  - "))
 	}
@@ -127,9 +166,109 @@ func Validate(cfg *Config) error {
 	return nil
 }
 
+// ValidateEncryption validates the encryption settings on their own, for
+// commands that don't need the full configuration
+func ValidateEncryption(cfg *Config) error {
+	errors := validateEncryption(cfg.Options.Encryption)
+	if len(errors) > 0 {
+		return fmt.Errorf("configuration validation failed:\n  - %s", strings.Join(errors, "\n  - "))
+	}
+	return nil
+}
+
+// validateEncryption returns the problems found in the encryption settings
+func validateEncryption(enc EncryptionConfig) []string {
+	var errors []string
+
+	if enc.Required && !enc.Enabled {
+		errors = append(errors, "options.encryption.required is set but encryption is not enabled")
+	}
+	if enc.Enabled && enc.Passphrase == "" && len(enc.Recipients) == 0 {
+		errors = append(errors, "options.encryption requires a passphrase, passphrase_file or recipients")
+	}
+
+	return errors
+}
+
 // expandString replaces ${VAR} or $VAR with environment variable values
 func expandString(s string) string {
 	return os.Expand(s, func(key string) string {
 		return os.Getenv(key)
 	})
+}
+
+// validateRestoreIgnore checks pg_restore ignore rules
+func validateRestoreIgnore(rules []RestoreIgnoreRule) []string {
+	var errors []string
+
+	for i, rule := range rules {
+		if rule.Message == "" && rule.Category == "" && rule.Object == "" {
+			errors = append(errors, fmt.Sprintf("options.restore_ignore[%d] needs a message, category or object", i))
+		}
+		if rule.Message != "" {
+			if _, err := regexp.Compile(rule.Message); err != nil {
+				errors = append(errors, fmt.Sprintf("options.restore_ignore[%d].message is not a valid regular expression: %v", i, err))
+			}
+		}
+	}
+
+	return errors
+}
+
+// validateExtensions checks extension entries
+func validateExtensions(extensions []ExtensionConfig) []string {
+	var errors []string
+
+	for i, ext := range extensions {
+		if ext.Name == "" {
+			errors = append(errors, fmt.Sprintf("options.extensions[%d] needs a name", i))
+		}
+	}
+
+	return errors
+}
+
+// validateProtectedTargets checks protected target patterns
+func validateProtectedTargets(patterns []string) []string {
+	var errors []string
+
+	for i, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			errors = append(errors, fmt.Sprintf("options.protected_targets[%d] is not a valid pattern: %v", i, err))
+		}
+	}
+
+	return errors
+}
+
+// validatePrivileges checks how privileges recorded in dumps are handled
+func validatePrivileges(mode string) []string {
+	switch mode {
+	case "", "reset", "preserve", "map":
+		return nil
+	}
+	return []string{fmt.Sprintf("options.privileges must be reset, preserve or map, not %q", mode)}
+}
+
+// validateGrants checks grants entries
+func validateGrants(grants []GrantConfig) []string {
+	var errors []string
+
+	for i, g := range grants {
+		if g.Role == "" {
+			errors = append(errors, fmt.Sprintf("grants[%d] needs a role", i))
+		}
+		switch strings.ToLower(g.Profile) {
+		case "owner", "readwrite", "readonly":
+		case "", "custom":
+			p := g.Privileges
+			if len(p.Database)+len(p.Schema)+len(p.Tables)+len(p.Sequences)+len(p.Functions) == 0 {
+				errors = append(errors, fmt.Sprintf("grants[%d] needs a profile or privileges", i))
+			}
+		default:
+			errors = append(errors, fmt.Sprintf("grants[%d].profile must be owner, readwrite, readonly or custom", i))
+		}
+	}
+
+	return errors
 }
\ No newline at end of file
@@ -0,0 +1,570 @@
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/1CL0UD/cloudm-cli/internal/config"
+)
+
+// Encrypted files start with this magic line, followed by a header holding
+// one wrapped copy of the file key per recipient or passphrase, and then the
+// payload as a sequence of AES-256-GCM chunks.
+const magic = "cloudm-enc/v1\n"
+
+const (
+	publicKeyPrefix  = "cmpub1"
+	privateKeyPrefix = "cmkey1"
+
+	stanzaPassphrase = 1
+	stanzaX25519     = 2
+
+	fileKeySize      = 32
+	saltSize         = 16
+	chunkSize        = 64 * 1024
+	pbkdf2Iterations = 600000
+)
+
+// ErrNoMatchingKey is returned when none of the configured identities or
+// passphrases can open an encrypted file
+var ErrNoMatchingKey = errors.New("no configured identity or passphrase can decrypt this file")
+
+type Encryptor struct {
+	passphrase string
+	recipients []*ecdh.PublicKey
+}
+
+type Decryptor struct {
+	passphrase string
+	identities []*ecdh.PrivateKey
+}
+
+// NewEncryptor creates an encryptor from configuration. It returns nil when
+// encryption is disabled.
+func NewEncryptor(cfg config.EncryptionConfig) (*Encryptor, error) {
+	if !cfg.Enabled {
+		if cfg.Required {
+			return nil, fmt.Errorf("encryption is required but not enabled")
+		}
+		return nil, nil
+	}
+
+	e := &Encryptor{passphrase: cfg.Passphrase}
+	for _, r := range cfg.Recipients {
+		pub, err := ParsePublicKey(r)
+		if err != nil {
+			return nil, err
+		}
+		e.recipients = append(e.recipients, pub)
+	}
+
+	if e.passphrase == "" && len(e.recipients) == 0 {
+		return nil, fmt.Errorf("encryption is enabled but neither a passphrase nor recipients are configured")
+	}
+
+	return e, nil
+}
+
+// NewDecryptor creates a decryptor from configuration. Missing keys are not
+// an error here; they surface when a file cannot be opened.
+func NewDecryptor(cfg config.EncryptionConfig) (*Decryptor, error) {
+	d := &Decryptor{passphrase: cfg.Passphrase}
+
+	if cfg.IdentityFile != "" {
+		ids, err := ReadIdentityFile(cfg.IdentityFile)
+		if err != nil {
+			return nil, err
+		}
+		d.identities = ids
+	}
+
+	return d, nil
+}
+
+// WithSessionKey adds a recipient whose private key only lives in memory and
+// returns a decryptor for it, so that a process can read back the files it
+// encrypted without access to the recipients' identities
+func (e *Encryptor) WithSessionKey() (*Decryptor, error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate session key: %w", err)
+	}
+
+	e.recipients = append(e.recipients, key.PublicKey())
+	return &Decryptor{passphrase: e.passphrase, identities: []*ecdh.PrivateKey{key}}, nil
+}
+
+// Encrypt returns a writer that encrypts everything written to it into dst.
+// Close must be called to write the final chunk; it does not close dst.
+func (e *Encryptor) Encrypt(dst io.Writer) (io.WriteCloser, error) {
+	fileKey := make([]byte, fileKeySize)
+	if _, err := rand.Read(fileKey); err != nil {
+		return nil, fmt.Errorf("failed to generate file key: %w", err)
+	}
+
+	var header bytes.Buffer
+	header.WriteString(magic)
+	header.WriteByte(byte(len(e.recipients) + boolToInt(e.passphrase != "")))
+
+	if e.passphrase != "" {
+		salt := make([]byte, saltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, fmt.Errorf("failed to generate salt: %w", err)
+		}
+		wrapKey, err := pbkdf2.Key(sha256.New, e.passphrase, salt, pbkdf2Iterations, 32)
+		if err != nil {
+			return nil, fmt.Errorf("failed to derive key: %w", err)
+		}
+		wrapped, err := seal(wrapKey, fileKey)
+		if err != nil {
+			return nil, err
+		}
+
+		header.WriteByte(stanzaPassphrase)
+		header.Write(salt)
+		binary.Write(&header, binary.BigEndian, uint32(pbkdf2Iterations))
+		header.Write(wrapped)
+	}
+
+	for _, recipient := range e.recipients {
+		ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate ephemeral key: %w", err)
+		}
+		shared, err := ephemeral.ECDH(recipient)
+		if err != nil {
+			return nil, fmt.Errorf("failed to derive shared secret: %w", err)
+		}
+		wrapKey, err := x25519WrapKey(shared, ephemeral.PublicKey().Bytes(), recipient.Bytes())
+		if err != nil {
+			return nil, err
+		}
+		wrapped, err := seal(wrapKey, fileKey)
+		if err != nil {
+			return nil, err
+		}
+
+		header.WriteByte(stanzaX25519)
+		header.Write(ephemeral.PublicKey().Bytes())
+		header.Write(wrapped)
+	}
+
+	payloadSalt := make([]byte, saltSize)
+	if _, err := rand.Read(payloadSalt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	header.Write(payloadSalt)
+
+	aead, err := payloadAEAD(fileKey, payloadSalt)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := dst.Write(header.Bytes()); err != nil {
+		return nil, fmt.Errorf("failed to write encryption header: %w", err)
+	}
+
+	headerHash := sha256.Sum256(header.Bytes())
+	return &writer{dst: dst, aead: aead, aad: headerHash[:]}, nil
+}
+
+// Decrypt returns a reader yielding the plaintext of an encrypted stream
+func (d *Decryptor) Decrypt(src io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(src)
+	var header bytes.Buffer
+	r := io.TeeReader(br, &header)
+
+	prefix := make([]byte, len(magic))
+	if _, err := io.ReadFull(r, prefix); err != nil || string(prefix) != magic {
+		return nil, fmt.Errorf("not an encrypted file")
+	}
+
+	count := make([]byte, 1)
+	if _, err := io.ReadFull(r, count); err != nil {
+		return nil, fmt.Errorf("truncated encryption header: %w", err)
+	}
+
+	var fileKey []byte
+	for i := 0; i < int(count[0]); i++ {
+		kind := make([]byte, 1)
+		if _, err := io.ReadFull(r, kind); err != nil {
+			return nil, fmt.Errorf("truncated encryption header: %w", err)
+		}
+
+		switch kind[0] {
+		case stanzaPassphrase:
+			body := make([]byte, saltSize+4+fileKeySize+16)
+			if _, err := io.ReadFull(r, body); err != nil {
+				return nil, fmt.Errorf("truncated encryption header: %w", err)
+			}
+			if fileKey != nil || d.passphrase == "" {
+				continue
+			}
+			salt := body[:saltSize]
+			iterations := binary.BigEndian.Uint32(body[saltSize : saltSize+4])
+			wrapKey, err := pbkdf2.Key(sha256.New, d.passphrase, salt, int(iterations), 32)
+			if err != nil {
+				return nil, fmt.Errorf("failed to derive key: %w", err)
+			}
+			if key, err := open(wrapKey, body[saltSize+4:]); err == nil {
+				fileKey = key
+			}
+
+		case stanzaX25519:
+			body := make([]byte, 32+fileKeySize+16)
+			if _, err := io.ReadFull(r, body); err != nil {
+				return nil, fmt.Errorf("truncated encryption header: %w", err)
+			}
+			if fileKey != nil {
+				continue
+			}
+			ephemeral, err := ecdh.X25519().NewPublicKey(body[:32])
+			if err != nil {
+				return nil, fmt.Errorf("invalid ephemeral key in encryption header: %w", err)
+			}
+			for _, id := range d.identities {
+				shared, err := id.ECDH(ephemeral)
+				if err != nil {
+					continue
+				}
+				wrapKey, err := x25519WrapKey(shared, body[:32], id.PublicKey().Bytes())
+				if err != nil {
+					return nil, err
+				}
+				if key, err := open(wrapKey, body[32:]); err == nil {
+					fileKey = key
+					break
+				}
+			}
+
+		default:
+			return nil, fmt.Errorf("unknown key type %d in encryption header", kind[0])
+		}
+	}
+
+	payloadSalt := make([]byte, saltSize)
+	if _, err := io.ReadFull(r, payloadSalt); err != nil {
+		return nil, fmt.Errorf("truncated encryption header: %w", err)
+	}
+
+	if fileKey == nil {
+		return nil, ErrNoMatchingKey
+	}
+
+	aead, err := payloadAEAD(fileKey, payloadSalt)
+	if err != nil {
+		return nil, err
+	}
+
+	headerHash := sha256.Sum256(header.Bytes())
+	return &reader{src: br, aead: aead, aad: headerHash[:]}, nil
+}
+
+// EncryptFile encrypts src into dst
+func (e *Encryptor) EncryptFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", src, err)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", dst, err)
+	}
+	defer out.Close()
+
+	w, err := e.Encrypt(out)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, in); err != nil {
+		return fmt.Errorf("failed to encrypt %s: %w", src, err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to encrypt %s: %w", src, err)
+	}
+	return out.Close()
+}
+
+// DecryptFile decrypts src into dst, creating dst readable by the owner only
+func (d *Decryptor) DecryptFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", src, err)
+	}
+	defer in.Close()
+
+	r, err := d.Decrypt(in)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt %s: %w", src, err)
+	}
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", dst, err)
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, r); err != nil {
+		os.Remove(dst)
+		return fmt.Errorf("failed to decrypt %s: %w", src, err)
+	}
+	return out.Close()
+}
+
+// IsEncrypted reports whether a file starts with the encryption header
+func IsEncrypted(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	prefix := make([]byte, len(magic))
+	n, err := io.ReadFull(f, prefix)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return false, err
+	}
+	return string(prefix[:n]) == magic, nil
+}
+
+// GenerateKeyPair creates a new X25519 identity and returns it together
+// with its public recipient string
+func GenerateKeyPair() (identity, recipient string, err error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate key: %w", err)
+	}
+
+	identity = privateKeyPrefix + base64.RawURLEncoding.EncodeToString(key.Bytes())
+	recipient = publicKeyPrefix + base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes())
+	return identity, recipient, nil
+}
+
+// ParsePublicKey parses a recipient string
+func ParsePublicKey(s string) (*ecdh.PublicKey, error) {
+	encoded, ok := strings.CutPrefix(strings.TrimSpace(s), publicKeyPrefix)
+	if !ok {
+		return nil, fmt.Errorf("invalid recipient %q: expected %s prefix", s, publicKeyPrefix)
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recipient %q: %w", s, err)
+	}
+	pub, err := ecdh.X25519().NewPublicKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recipient %q: %w", s, err)
+	}
+	return pub, nil
+}
+
+// ReadIdentityFile reads private keys from a file, one per line. Empty lines
+// and lines starting with # are ignored.
+func ReadIdentityFile(path string) ([]*ecdh.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read identity file: %w", err)
+	}
+
+	var ids []*ecdh.PrivateKey
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		encoded, ok := strings.CutPrefix(line, privateKeyPrefix)
+		if !ok {
+			return nil, fmt.Errorf("invalid identity in %s: expected %s prefix", path, privateKeyPrefix)
+		}
+		raw, err := base64.RawURLEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid identity in %s: %w", path, err)
+		}
+		key, err := ecdh.X25519().NewPrivateKey(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid identity in %s: %w", path, err)
+		}
+		ids = append(ids, key)
+	}
+
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("no identities found in %s", path)
+	}
+	return ids, nil
+}
+
+// writer encrypts a stream in fixed-size chunks. Every chunk but the last is
+// exactly chunkSize bytes of plaintext; the last one is shorter, possibly
+// empty, and flagged in its nonce so that truncation is detected.
+type writer struct {
+	dst     io.Writer
+	aead    cipher.AEAD
+	aad     []byte
+	buf     []byte
+	counter uint64
+	closed  bool
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, fmt.Errorf("write to closed encryption stream")
+	}
+
+	n := len(p)
+	for len(p) > 0 {
+		take := min(chunkSize-len(w.buf), len(p))
+		w.buf = append(w.buf, p[:take]...)
+		p = p[take:]
+
+		if len(w.buf) == chunkSize {
+			if err := w.flush(false); err != nil {
+				return n - len(p), err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (w *writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.flush(true)
+}
+
+func (w *writer) flush(final bool) error {
+	sealed := w.aead.Seal(nil, chunkNonce(w.counter, final), w.buf, w.aad)
+	w.counter++
+	w.buf = w.buf[:0]
+
+	_, err := w.dst.Write(sealed)
+	return err
+}
+
+type reader struct {
+	src     io.Reader
+	aead    cipher.AEAD
+	aad     []byte
+	buf     []byte
+	counter uint64
+	done    bool
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *reader) next() error {
+	sealed := make([]byte, chunkSize+r.aead.Overhead())
+	n, err := io.ReadFull(r.src, sealed)
+	final := false
+	switch {
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		if n < r.aead.Overhead() {
+			return fmt.Errorf("encrypted file is truncated")
+		}
+		final = true
+	case err != nil:
+		return err
+	}
+
+	plain, err := r.aead.Open(nil, chunkNonce(r.counter, final), sealed[:n], r.aad)
+	if err != nil {
+		return fmt.Errorf("encrypted file is corrupted or was tampered with")
+	}
+
+	r.counter++
+	r.buf = plain
+	r.done = final
+	return nil
+}
+
+// chunkNonce builds the nonce of a payload chunk from its index and whether
+// it is the last chunk
+func chunkNonce(counter uint64, final bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[3:11], counter)
+	if final {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// payloadAEAD derives the payload cipher from the file key
+func payloadAEAD(fileKey, salt []byte) (cipher.AEAD, error) {
+	key, err := hkdf.Key(sha256.New, fileKey, salt, "cloudm-cli payload", 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive payload key: %w", err)
+	}
+	return newGCM(key)
+}
+
+// x25519WrapKey derives the key wrapping the file key for a recipient
+func x25519WrapKey(shared, ephemeral, recipient []byte) ([]byte, error) {
+	salt := append(append([]byte{}, ephemeral...), recipient...)
+	key, err := hkdf.Key(sha256.New, shared, salt, "cloudm-cli x25519", 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive wrapping key: %w", err)
+	}
+	return key, nil
+}
+
+// seal wraps a file key. Wrapping keys are never reused, so a zero nonce is safe.
+func seal(key, plaintext []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nil, make([]byte, aead.NonceSize()), plaintext, nil), nil
+}
+
+// open unwraps a file key
+func open(key, ciphertext []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Open(nil, make([]byte, aead.NonceSize()), ciphertext, nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
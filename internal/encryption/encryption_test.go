@@ -0,0 +1,207 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"errors"
+	"io"
+	"testing"
+)
+
+// newKeyPair returns an encryptor for a fresh X25519 recipient and a
+// decryptor holding its identity
+func newKeyPair(t *testing.T) (*Encryptor, *Decryptor) {
+	t.Helper()
+	_, recipient, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateKeyPair() error: %v", err)
+	}
+	pub, err := ParsePublicKey(recipient)
+	if err != nil {
+		t.Fatalf("ParsePublicKey() error: %v", err)
+	}
+	enc := &Encryptor{recipients: []*ecdh.PublicKey{pub}}
+	dec, err := enc.WithSessionKey()
+	if err != nil {
+		t.Fatalf("WithSessionKey() error: %v", err)
+	}
+	return enc, dec
+}
+
+func encrypt(t *testing.T, enc *Encryptor, plain []byte) []byte {
+	t.Helper()
+	var out bytes.Buffer
+	w, err := enc.Encrypt(&out)
+	if err != nil {
+		t.Fatalf("Encrypt() error: %v", err)
+	}
+	if _, err := w.Write(plain); err != nil {
+		t.Fatalf("Write() error: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+	return out.Bytes()
+}
+
+func decrypt(dec *Decryptor, sealed []byte) ([]byte, error) {
+	r, err := dec.Decrypt(bytes.NewReader(sealed))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// payload returns n bytes of varying content
+func payload(n int) []byte {
+	p := make([]byte, n)
+	for i := range p {
+		p[i] = byte(i*7 + i/251)
+	}
+	return p
+}
+
+// sealedChunk is the size of a full payload chunk once encrypted
+const sealedChunk = chunkSize + 16
+
+func TestRoundTrip(t *testing.T) {
+	enc, dec := newKeyPair(t)
+
+	tests := []struct {
+		name string
+		size int
+	}{
+		{"empty", 0},
+		{"short", 100},
+		{"one chunk", chunkSize},
+		{"several chunks", 3*chunkSize + 100},
+		{"exact chunks", 2 * chunkSize},
+	}
+
+	for _, tt := range tests {
+		plain := payload(tt.size)
+		got, err := decrypt(dec, encrypt(t, enc, plain))
+		if err != nil {
+			t.Errorf("%s: decrypt error: %v", tt.name, err)
+			continue
+		}
+		if !bytes.Equal(got, plain) {
+			t.Errorf("%s: decrypted %d bytes, want the %d bytes written", tt.name, len(got), len(plain))
+		}
+	}
+}
+
+func TestPassphrase(t *testing.T) {
+	enc := &Encryptor{passphrase: "correct horse"}
+	sealed := encrypt(t, enc, payload(1000))
+
+	got, err := decrypt(&Decryptor{passphrase: "correct horse"}, sealed)
+	if err != nil || !bytes.Equal(got, payload(1000)) {
+		t.Errorf("decrypt with passphrase = %d bytes, %v; want the plaintext", len(got), err)
+	}
+
+	if _, err := decrypt(&Decryptor{passphrase: "wrong horse"}, sealed); !errors.Is(err, ErrNoMatchingKey) {
+		t.Errorf("decrypt with wrong passphrase error = %v, want ErrNoMatchingKey", err)
+	}
+}
+
+func TestWrongIdentity(t *testing.T) {
+	enc, _ := newKeyPair(t)
+	_, other := newKeyPair(t)
+	sealed := encrypt(t, enc, payload(1000))
+
+	if _, err := decrypt(other, sealed); !errors.Is(err, ErrNoMatchingKey) {
+		t.Errorf("decrypt with other identity error = %v, want ErrNoMatchingKey", err)
+	}
+	if _, err := decrypt(&Decryptor{}, sealed); !errors.Is(err, ErrNoMatchingKey) {
+		t.Errorf("decrypt without keys error = %v, want ErrNoMatchingKey", err)
+	}
+}
+
+func TestTamperedStream(t *testing.T) {
+	enc, dec := newKeyPair(t)
+	plain := payload(3*chunkSize + 100)
+	sealed := encrypt(t, enc, plain)
+	header := len(sealed) - 3*sealedChunk - (100 + 16)
+
+	// chunk returns the bounds of the i-th sealed chunk
+	chunk := func(i int) (int, int) {
+		return header + i*sealedChunk, header + (i+1)*sealedChunk
+	}
+
+	tests := []struct {
+		name   string
+		modify func([]byte) []byte
+	}{
+		{"truncated at chunk boundary", func(b []byte) []byte {
+			_, end := chunk(1)
+			return b[:end]
+		}},
+		{"truncated inside chunk", func(b []byte) []byte {
+			start, _ := chunk(1)
+			return b[:start+1000]
+		}},
+		{"final chunk removed", func(b []byte) []byte {
+			_, end := chunk(2)
+			return b[:end]
+		}},
+		{"chunks reordered", func(b []byte) []byte {
+			s0, e0 := chunk(0)
+			s1, e1 := chunk(1)
+			out := append([]byte{}, b[:s0]...)
+			out = append(out, b[s1:e1]...)
+			out = append(out, b[s0:e0]...)
+			return append(out, b[e1:]...)
+		}},
+		{"chunk duplicated", func(b []byte) []byte {
+			s0, e0 := chunk(0)
+			out := append([]byte{}, b[:e0]...)
+			out = append(out, b[s0:e0]...)
+			return append(out, b[e0:]...)
+		}},
+		{"payload byte flipped", func(b []byte) []byte {
+			start, _ := chunk(1)
+			b[start+10] ^= 1
+			return b
+		}},
+		{"payload salt flipped", func(b []byte) []byte {
+			b[header-1] ^= 1
+			return b
+		}},
+		{"trailing data", func(b []byte) []byte {
+			return append(b, 0)
+		}},
+	}
+
+	for _, tt := range tests {
+		modified := tt.modify(append([]byte{}, sealed...))
+		if got, err := decrypt(dec, modified); err == nil {
+			t.Errorf("%s: decrypted %d bytes, want an error", tt.name, len(got))
+		}
+	}
+}
+
+func TestEmptyStreamTruncated(t *testing.T) {
+	enc, dec := newKeyPair(t)
+	sealed := encrypt(t, enc, nil)
+
+	// Without its final empty chunk, an empty stream is a bare header
+	if got, err := decrypt(dec, sealed[:len(sealed)-16]); err == nil {
+		t.Errorf("decrypted %d bytes from a stream without chunks, want an error", len(got))
+	}
+
+	// Multiples of the chunk size end with an empty final chunk, too
+	sealed = encrypt(t, enc, payload(chunkSize))
+	if got, err := decrypt(dec, sealed[:len(sealed)-16]); err == nil {
+		t.Errorf("decrypted %d bytes without the final chunk, want an error", len(got))
+	}
+}
+
+func TestNotEncrypted(t *testing.T) {
+	_, dec := newKeyPair(t)
+	for _, input := range [][]byte{nil, []byte("PGDMP"), []byte(magic[:5])} {
+		if _, err := dec.Decrypt(bytes.NewReader(input)); err == nil {
+			t.Errorf("Decrypt(%q) succeeded, want an error", input)
+		}
+	}
+}
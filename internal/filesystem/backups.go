@@ -0,0 +1,97 @@
+package filesystem
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// BackupInfo describes a pre-migration backup found in a migration directory
+type BackupInfo struct {
+	ID        string    `json:"-"`
+	Path      string    `json:"-"`
+	Size      int64     `json:"-"`
+	Target    string    `json:"target"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SizeString returns the size of the backup in human-readable format
+func (b BackupInfo) SizeString() string {
+	return formatBytes(b.Size)
+}
+
+// GetBackupInfoPath returns the path of the file describing a backup
+func GetBackupInfoPath(migrationDir string) string {
+	return filepath.Join(migrationDir, "backup_info.json")
+}
+
+// WriteBackupInfo records which target the backup of a migration directory
+// was taken from
+func WriteBackupInfo(migrationDir, target string) error {
+	data, err := json.MarshalIndent(BackupInfo{Target: target, CreatedAt: time.Now()}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode backup info: %w", err)
+	}
+	if err := os.WriteFile(GetBackupInfoPath(migrationDir), data, 0644); err != nil {
+		return fmt.Errorf("failed to write backup info: %w", err)
+	}
+	return nil
+}
+
+// ListBackups returns the backups in the migration directories under
+// baseDir, newest first. Directories without a backup are skipped, and the
+// target is left empty for backups taken before it was recorded.
+func ListBackups(baseDir string) ([]BackupInfo, error) {
+	entries, err := os.ReadDir(baseDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory: %w", err)
+	}
+
+	var backups []BackupInfo
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		dir := filepath.Join(baseDir, entry.Name())
+
+		stat, err := os.Stat(GetBackupPath(dir))
+		if err != nil {
+			continue
+		}
+
+		info := BackupInfo{}
+		if data, err := os.ReadFile(GetBackupInfoPath(dir)); err == nil {
+			if err := json.Unmarshal(data, &info); err != nil {
+				return nil, fmt.Errorf("failed to parse %s: %w", GetBackupInfoPath(dir), err)
+			}
+		}
+		info.ID = entry.Name()
+		info.Path = GetBackupPath(dir)
+		info.Size = stat.Size()
+		if info.CreatedAt.IsZero() {
+			info.CreatedAt = stat.ModTime()
+		}
+
+		backups = append(backups, info)
+	}
+
+	// Directory names are timestamps, so this is newest first
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].ID > backups[j].ID
+	})
+
+	return backups, nil
+}
+
+// FindBackup returns the backup with the given id
+func FindBackup(backups []BackupInfo, id string) (BackupInfo, error) {
+	for _, b := range backups {
+		if b.ID == id {
+			return b, nil
+		}
+	}
+	return BackupInfo{}, fmt.Errorf("no backup with id %s", id)
+}
\ No newline at end of file
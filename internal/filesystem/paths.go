@@ -31,6 +31,36 @@ func GetBackupPath(migrationDir string) string {
 	return filepath.Join(migrationDir, "backup_pre_migration.dump")
 }
 
+// GetRestoreBackupName returns the name under which the backup taken by a
+// restore is uploaded, so that it doesn't replace the pre-migration backup
+// stored next to the same dumps
+func GetRestoreBackupName(t time.Time) string {
+	return "backup_pre_restore_" + t.Format("20060102_150405") + ".dump"
+}
+
+// GetRestoreStatePath returns the path of the state file of a restore
+func GetRestoreStatePath(migrationDir string) string {
+	return filepath.Join(migrationDir, "restore_state.json")
+}
+
+// GetOwnershipSQLPath returns the path of the saved ownership plan
+func GetOwnershipSQLPath(migrationDir string) string {
+	return filepath.Join(migrationDir, "ownership.sql")
+}
+
+// GetOwnershipSnapshotPath returns the path of the owners and privileges
+// recorded before ownership changes
+func GetOwnershipSnapshotPath(migrationDir string) string {
+	return filepath.Join(migrationDir, "ownership_undo.json")
+}
+
+// GetAppUserSecretPath returns the default file holding the generated
+// password of the app user. It lies outside the migration directories so
+// that it is never uploaded with their artifacts.
+func GetAppUserSecretPath(outputDir, appUser string) string {
+	return filepath.Join(outputDir, "secrets", appUser+".password")
+}
+
 // GetLogPaths returns paths for log files
 func GetLogPaths(migrationDir string) (mainLog, timeLog, validationLog string) {
 	mainLog = filepath.Join(migrationDir, "migration.log")
@@ -76,6 +106,21 @@ func ValidateDataDump(migrationDir string) error {
 	return nil
 }
 
+// ListDumpFiles returns the dump files present in a migration directory
+func ListDumpFiles(migrationDir string) []string {
+	structure, data := GetDumpPaths(migrationDir)
+	backup := GetBackupPath(migrationDir)
+
+	var files []string
+	for _, file := range []string{structure, data, backup} {
+		if FileExists(file) {
+			files = append(files, file)
+		}
+	}
+
+	return files
+}
+
 // FileExists checks if a file exists
 func FileExists(path string) bool {
 	_, err := os.Stat(path)
@@ -0,0 +1,53 @@
+package filesystem
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// WriteSecretFile writes a secret to a file readable only by its owner. The
+// file is replaced atomically, so a failed write leaves the previous secret
+// in place.
+func WriteSecretFile(path, secret string) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create secret directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create secret file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to restrict secret file: %w", err)
+	}
+	if _, err := tmp.WriteString(secret + "\n"); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write secret file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write secret file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write secret file: %w", err)
+	}
+	return nil
+}
+
+// StagedSecretPath returns where a new secret waits next to the secret file
+// it is to replace
+func StagedSecretPath(path string) string {
+	return path + ".new"
+}
+
+// CommitSecretFile replaces a secret file with the secret staged next to it
+func CommitSecretFile(path string) error {
+	if err := os.Rename(StagedSecretPath(path), path); err != nil {
+		return fmt.Errorf("failed to replace secret file: %w", err)
+	}
+	return nil
+}
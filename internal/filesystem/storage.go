@@ -0,0 +1,198 @@
+package filesystem
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/1CL0UD/cloudm-cli/internal/config"
+)
+
+// Storage stores migration artifacts under a root location. Keys are
+// slash-separated paths relative to that root.
+type Storage interface {
+	// Put uploads a local file to key
+	Put(ctx context.Context, localPath, key string) error
+	// Get downloads key to a local file
+	Get(ctx context.Context, key, localPath string) error
+	// List returns the keys below prefix
+	List(ctx context.Context, prefix string) ([]string, error)
+	// Location returns the full location of key, for display
+	Location(key string) string
+}
+
+// IsRemoteLocation reports whether a location refers to a storage backend
+// other than the local disk
+func IsRemoteLocation(location string) bool {
+	scheme, _, ok := strings.Cut(location, "://")
+	return ok && scheme != "file"
+}
+
+// NewStorage opens the storage backend for a location: a local path or
+// file:// URL, s3://bucket/prefix or sftp://user@host[:port]/path
+func NewStorage(location string, cfg config.StorageConfig) (Storage, error) {
+	if !strings.Contains(location, "://") {
+		return &LocalStorage{Root: location}, nil
+	}
+
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid storage location %q: %w", location, err)
+	}
+
+	switch u.Scheme {
+	case "file":
+		return &LocalStorage{Root: u.Path}, nil
+	case "s3":
+		if u.Host == "" {
+			return nil, fmt.Errorf("invalid storage location %q: missing bucket", location)
+		}
+		return NewS3Storage(u.Host, strings.Trim(u.Path, "/"), cfg.S3), nil
+	case "sftp":
+		if u.Host == "" {
+			return nil, fmt.Errorf("invalid storage location %q: missing host", location)
+		}
+		return NewSFTPStorage(u, cfg.SFTP), nil
+	default:
+		return nil, fmt.Errorf("unsupported storage scheme %q", u.Scheme)
+	}
+}
+
+// UploadDir uploads every regular file of a local directory below prefix
+// and returns the locations written. Files named in done are skipped; those
+// uploaded are added to it when it isn't nil.
+func UploadDir(ctx context.Context, st Storage, localDir, prefix string, done map[string]bool) ([]string, error) {
+	entries, err := os.ReadDir(localDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory: %w", err)
+	}
+
+	var uploaded []string
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || done[entry.Name()] {
+			continue
+		}
+		key := path.Join(prefix, entry.Name())
+		if err := st.Put(ctx, filepath.Join(localDir, entry.Name()), key); err != nil {
+			return uploaded, err
+		}
+		uploaded = append(uploaded, st.Location(key))
+		if done != nil {
+			done[entry.Name()] = true
+		}
+	}
+
+	return uploaded, nil
+}
+
+// FetchDumps downloads the dump files found at the root of a storage
+// location into a local directory and returns their local paths
+func FetchDumps(ctx context.Context, st Storage, localDir string) ([]string, error) {
+	keys, err := st.List(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(localDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	wanted := map[string]bool{}
+	structure, data := GetDumpPaths("")
+	for _, name := range []string{structure, data, GetBackupPath("")} {
+		wanted[name] = true
+	}
+
+	var fetched []string
+	for _, key := range keys {
+		name := path.Base(key)
+		if !wanted[name] || strings.Contains(strings.Trim(key, "/"), "/") {
+			continue
+		}
+		localPath := filepath.Join(localDir, name)
+		if err := st.Get(ctx, key, localPath); err != nil {
+			return fetched, err
+		}
+		fetched = append(fetched, localPath)
+	}
+
+	if len(fetched) == 0 {
+		return nil, fmt.Errorf("no dump files found at %s", st.Location(""))
+	}
+	return fetched, nil
+}
+
+// LocalStorage stores artifacts in a directory on the local disk
+type LocalStorage struct {
+	Root string
+}
+
+func (s *LocalStorage) Put(ctx context.Context, localPath, key string) error {
+	dst := filepath.Join(s.Root, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	return copyFile(localPath, dst)
+}
+
+func (s *LocalStorage) Get(ctx context.Context, key, localPath string) error {
+	return copyFile(filepath.Join(s.Root, filepath.FromSlash(key)), localPath)
+}
+
+func (s *LocalStorage) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	root := filepath.Join(s.Root, filepath.FromSlash(prefix))
+	err := filepath.WalkDir(root, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			rel, err := filepath.Rel(s.Root, p)
+			if err != nil {
+				return err
+			}
+			keys = append(keys, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", root, err)
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *LocalStorage) Location(key string) string {
+	return filepath.Join(s.Root, filepath.FromSlash(key))
+}
+
+// copyFile copies a file, creating the destination readable by the owner only
+func copyFile(src, dst string) error {
+	if filepath.Clean(src) == filepath.Clean(dst) {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", src, err)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", dst, err)
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, in); err != nil {
+		return fmt.Errorf("failed to copy %s: %w", src, err)
+	}
+	return out.Close()
+}
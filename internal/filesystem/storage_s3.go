@@ -0,0 +1,398 @@
+package filesystem
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/1CL0UD/cloudm-cli/internal/config"
+)
+
+const (
+	// s3PartSize is the size of multipart upload parts; files up to this
+	// size are uploaded with a single PUT
+	s3PartSize = 64 * 1024 * 1024
+
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+// S3Storage stores artifacts in an S3-compatible object store. Requests are
+// signed with AWS Signature Version 4, so any endpoint speaking the S3 API
+// (AWS, MinIO, Ceph, ...) can be used.
+type S3Storage struct {
+	Bucket       string
+	Prefix       string
+	Endpoint     *url.URL
+	Region       string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	PathStyle    bool
+	// PartSize is the size of multipart upload parts; zero means s3PartSize
+	PartSize int64
+	Client   *http.Client
+}
+
+// NewS3Storage creates an S3 backend. Credentials and region not set in the
+// configuration are taken from the standard AWS environment variables.
+func NewS3Storage(bucket, prefix string, cfg config.S3Config) *S3Storage {
+	s := &S3Storage{
+		Bucket:       bucket,
+		Prefix:       prefix,
+		Region:       firstNonEmpty(cfg.Region, os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), "us-east-1"),
+		AccessKey:    firstNonEmpty(cfg.AccessKey, os.Getenv("AWS_ACCESS_KEY_ID")),
+		SecretKey:    firstNonEmpty(cfg.SecretKey, os.Getenv("AWS_SECRET_ACCESS_KEY")),
+		SessionToken: firstNonEmpty(cfg.SessionToken, os.Getenv("AWS_SESSION_TOKEN")),
+		PathStyle:    cfg.PathStyle,
+		Client:       http.DefaultClient,
+	}
+
+	endpoint := firstNonEmpty(cfg.Endpoint, os.Getenv("AWS_ENDPOINT_URL_S3"), os.Getenv("AWS_ENDPOINT_URL"))
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", s.Region)
+	} else if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+	s.Endpoint, _ = url.Parse(endpoint)
+	if s.Endpoint == nil {
+		s.Endpoint = &url.URL{Scheme: "https", Host: endpoint}
+	}
+
+	return s
+}
+
+func (s *S3Storage) Put(ctx context.Context, localPath, key string) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", localPath, err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", localPath, err)
+	}
+
+	objectKey := s.objectKey(key)
+	if info.Size() <= s.partSize() {
+		resp, err := s.do(ctx, http.MethodPut, objectKey, nil, f, info.Size())
+		if err != nil {
+			return fmt.Errorf("failed to upload %s: %w", s.Location(key), err)
+		}
+		resp.Body.Close()
+		return nil
+	}
+
+	if err := s.putMultipart(ctx, f, info.Size(), objectKey); err != nil {
+		return fmt.Errorf("failed to upload %s: %w", s.Location(key), err)
+	}
+	return nil
+}
+
+func (s *S3Storage) Get(ctx context.Context, key, localPath string) error {
+	resp, err := s.do(ctx, http.MethodGet, s.objectKey(key), nil, nil, 0)
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %w", s.Location(key), err)
+	}
+	defer resp.Body.Close()
+
+	out, err := os.OpenFile(localPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", localPath, err)
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, resp.Body); err != nil {
+		os.Remove(localPath)
+		return fmt.Errorf("failed to download %s: %w", s.Location(key), err)
+	}
+	return out.Close()
+}
+
+func (s *S3Storage) List(ctx context.Context, prefix string) ([]string, error) {
+	fullPrefix := s.objectKey(prefix)
+	if fullPrefix != "" && !strings.HasSuffix(fullPrefix, "/") {
+		fullPrefix += "/"
+	}
+
+	var keys []string
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {fullPrefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		resp, err := s.do(ctx, http.MethodGet, "", query, nil, 0)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", s.Location(prefix), err)
+		}
+
+		var result struct {
+			Contents []struct {
+				Key string `xml:"Key"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse listing of %s: %w", s.Location(prefix), err)
+		}
+
+		for _, c := range result.Contents {
+			rel := strings.TrimPrefix(c.Key, s.objectKey(""))
+			keys = append(keys, strings.TrimPrefix(rel, "/"))
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *S3Storage) Location(key string) string {
+	return "s3://" + path.Join(s.Bucket, s.objectKey(key))
+}
+
+// putMultipart uploads a large file in parts
+func (s *S3Storage) putMultipart(ctx context.Context, f *os.File, size int64, objectKey string) error {
+	resp, err := s.do(ctx, http.MethodPost, objectKey, url.Values{"uploads": {""}}, nil, 0)
+	if err != nil {
+		return err
+	}
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err = xml.NewDecoder(resp.Body).Decode(&initiated)
+	resp.Body.Close()
+	if err != nil || initiated.UploadID == "" {
+		return fmt.Errorf("failed to start multipart upload: %v", err)
+	}
+
+	type part struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	}
+	var parts []part
+
+	abort := func() {
+		if resp, err := s.do(context.Background(), http.MethodDelete, objectKey,
+			url.Values{"uploadId": {initiated.UploadID}}, nil, 0); err == nil {
+			resp.Body.Close()
+		}
+	}
+
+	partSize := s.partSize()
+	for offset, number := int64(0), 1; offset < size; offset, number = offset+partSize, number+1 {
+		length := min(partSize, size-offset)
+		query := url.Values{
+			"partNumber": {fmt.Sprintf("%d", number)},
+			"uploadId":   {initiated.UploadID},
+		}
+		resp, err := s.do(ctx, http.MethodPut, objectKey, query, io.NewSectionReader(f, offset, length), length)
+		if err != nil {
+			abort()
+			return fmt.Errorf("failed to upload part %d: %w", number, err)
+		}
+		resp.Body.Close()
+		parts = append(parts, part{PartNumber: number, ETag: resp.Header.Get("ETag")})
+	}
+
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []part   `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		abort()
+		return err
+	}
+
+	resp, err = s.do(ctx, http.MethodPost, objectKey, url.Values{"uploadId": {initiated.UploadID}},
+		bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		abort()
+		return fmt.Errorf("failed to complete multipart upload: %w", err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// do sends a signed request and returns the response when it succeeded
+func (s *S3Storage) do(ctx context.Context, method, objectKey string, query url.Values, body io.Reader, size int64) (*http.Response, error) {
+	u := *s.Endpoint
+	if s.PathStyle {
+		u.Path = "/" + s.Bucket
+		if objectKey != "" {
+			u.Path += "/" + objectKey
+		}
+	} else {
+		u.Host = s.Bucket + "." + u.Host
+		u.Path = "/" + objectKey
+	}
+	u.RawPath = uriEncode(u.Path, false)
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	} else {
+		req.Body = http.NoBody
+	}
+
+	s.sign(req, u.RawPath, time.Now().UTC())
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s %s: %s: %s", method, u.Path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+// sign adds AWS Signature Version 4 headers to a request whose path is
+// already URI-encoded
+func (s *S3Storage) sign(req *http.Request, encodedPath string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", unsignedPayload)
+	if s.SessionToken != "" {
+		req.Header.Set("x-amz-security-token", s.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(req.Header.Get(name))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		encodedPath,
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		unsignedPayload,
+	}, "\n")
+
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKey, scope, signedHeaders, signature))
+}
+
+// partSize returns the size of multipart upload parts
+func (s *S3Storage) partSize() int64 {
+	if s.PartSize > 0 {
+		return s.PartSize
+	}
+	return s3PartSize
+}
+
+// objectKey returns the object key for a key relative to the prefix
+func (s *S3Storage) objectKey(key string) string {
+	return strings.Trim(path.Join(s.Prefix, key), "/")
+}
+
+// canonicalQuery encodes query parameters sorted by name, as required by
+// the signature
+func canonicalQuery(query url.Values) string {
+	if len(query) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var parts []string
+	for _, name := range names {
+		for _, value := range query[name] {
+			parts = append(parts, uriEncode(name, true)+"="+uriEncode(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode percent-encodes everything but unreserved characters, keeping
+// slashes unless encodeSlash is set
+func uriEncode(s string, encodeSlash bool) string {
+	var sb strings.Builder
+	for _, b := range []byte(s) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9',
+			b == '-', b == '_', b == '.', b == '~':
+			sb.WriteByte(b)
+		case b == '/' && !encodeSlash:
+			sb.WriteByte(b)
+		default:
+			fmt.Fprintf(&sb, "%%%02X", b)
+		}
+	}
+	return sb.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
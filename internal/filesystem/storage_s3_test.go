@@ -0,0 +1,468 @@
+package filesystem
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/1CL0UD/cloudm-cli/internal/config"
+)
+
+const (
+	testBucket    = "artifacts"
+	testAccessKey = "AKIDEXAMPLE"
+	testSecretKey = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+	testRegion    = "eu-central-1"
+	testToken     = "session-token"
+)
+
+// fakeS3 is a minimal S3-compatible server in the manner of MinIO. It
+// checks the SigV4 signature of every request independently of the client
+// and keeps objects and multipart uploads in memory.
+type fakeS3 struct {
+	mu sync.Mutex
+	// rejected holds the errors of requests whose signature didn't verify
+	rejected []string
+	objects  map[string][]byte
+	uploads  map[string]map[int][]byte
+	aborted  int
+	nextID   int
+	pageSize int
+	// failPart makes the upload of that part number fail
+	failPart int
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{
+		objects:  make(map[string][]byte),
+		uploads:  make(map[string]map[int][]byte),
+		pageSize: 2,
+	}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := verifySignature(r); err != nil {
+		f.mu.Lock()
+		f.rejected = append(f.rejected, fmt.Sprintf("%s %s: %v", r.Method, r.URL, err))
+		f.mu.Unlock()
+		http.Error(w, "SignatureDoesNotMatch: "+err.Error(), http.StatusForbidden)
+		return
+	}
+
+	// Path-style requests name the bucket in the path, virtual-hosted ones
+	// in the host
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	if !strings.HasPrefix(r.Host, testBucket+".") {
+		bucket, rest, _ := strings.Cut(key, "/")
+		if bucket != testBucket {
+			http.Error(w, "NoSuchBucket", http.StatusNotFound)
+			return
+		}
+		key = rest
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.ContentLength >= 0 && int64(len(body)) != r.ContentLength {
+		http.Error(w, "IncompleteBody", http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	query := r.URL.Query()
+	uploadID := query.Get("uploadId")
+	switch {
+	case r.Method == http.MethodGet && key == "" && query.Get("list-type") == "2":
+		f.list(w, query)
+
+	case r.Method == http.MethodGet:
+		data, ok := f.objects[key]
+		if !ok {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+
+	case r.Method == http.MethodPut && uploadID == "":
+		f.objects[key] = body
+
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		f.nextID++
+		id := fmt.Sprintf("upload-%d", f.nextID)
+		f.uploads[id] = make(map[int][]byte)
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><Bucket>%s</Bucket><Key>%s</Key><UploadId>%s</UploadId></InitiateMultipartUploadResult>",
+			testBucket, key, id)
+
+	case r.Method == http.MethodPut:
+		parts, ok := f.uploads[uploadID]
+		if !ok {
+			http.Error(w, "NoSuchUpload", http.StatusNotFound)
+			return
+		}
+		var number int
+		fmt.Sscan(query.Get("partNumber"), &number)
+		if number == f.failPart {
+			http.Error(w, "InternalError", http.StatusInternalServerError)
+			return
+		}
+		parts[number] = body
+		w.Header().Set("ETag", partETag(body))
+
+	case r.Method == http.MethodPost:
+		parts, ok := f.uploads[uploadID]
+		if !ok {
+			http.Error(w, "NoSuchUpload", http.StatusNotFound)
+			return
+		}
+		var complete struct {
+			Parts []struct {
+				PartNumber int
+				ETag       string
+			} `xml:"Part"`
+		}
+		if err := xml.Unmarshal(body, &complete); err != nil {
+			http.Error(w, "MalformedXML", http.StatusBadRequest)
+			return
+		}
+		var data []byte
+		for i, p := range complete.Parts {
+			part, ok := parts[p.PartNumber]
+			if p.PartNumber != i+1 || !ok || p.ETag != partETag(part) {
+				http.Error(w, "InvalidPart", http.StatusBadRequest)
+				return
+			}
+			data = append(data, part...)
+		}
+		if len(complete.Parts) != len(parts) {
+			http.Error(w, "InvalidPart", http.StatusBadRequest)
+			return
+		}
+		f.objects[key] = data
+		delete(f.uploads, uploadID)
+		fmt.Fprintf(w, "<CompleteMultipartUploadResult><Key>%s</Key></CompleteMultipartUploadResult>", key)
+
+	case r.Method == http.MethodDelete && uploadID != "":
+		delete(f.uploads, uploadID)
+		f.aborted++
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "NotImplemented", http.StatusNotImplemented)
+	}
+}
+
+// list answers a ListObjectsV2 request, a few keys per page
+func (f *fakeS3) list(w http.ResponseWriter, query url.Values) {
+	var keys []string
+	for key := range f.objects {
+		if strings.HasPrefix(key, query.Get("prefix")) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	start := 0
+	if token := query.Get("continuation-token"); token != "" {
+		fmt.Sscan(token, &start)
+	}
+	end := min(start+f.pageSize, len(keys))
+
+	var sb strings.Builder
+	sb.WriteString("<ListBucketResult>")
+	for _, key := range keys[start:end] {
+		sb.WriteString("<Contents><Key>")
+		xml.EscapeText(&sb, []byte(key))
+		sb.WriteString("</Key></Contents>")
+	}
+	if end < len(keys) {
+		fmt.Fprintf(&sb, "<IsTruncated>true</IsTruncated><NextContinuationToken>%d</NextContinuationToken>", end)
+	} else {
+		sb.WriteString("<IsTruncated>false</IsTruncated>")
+	}
+	sb.WriteString("</ListBucketResult>")
+	w.Write([]byte(sb.String()))
+}
+
+func partETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// verifySignature checks the AWS Signature Version 4 of a request the way
+// the server side computes it
+func verifySignature(r *http.Request) error {
+	auth, ok := strings.CutPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ")
+	if !ok {
+		return fmt.Errorf("missing or unsupported Authorization header")
+	}
+	fields := make(map[string]string)
+	for _, field := range strings.Split(auth, ", ") {
+		name, value, _ := strings.Cut(field, "=")
+		fields[name] = value
+	}
+
+	credential := strings.Split(fields["Credential"], "/")
+	if len(credential) != 5 || credential[0] != testAccessKey || credential[2] != testRegion ||
+		credential[3] != "s3" || credential[4] != "aws4_request" {
+		return fmt.Errorf("invalid credential %q", fields["Credential"])
+	}
+
+	amzDate := r.Header.Get("x-amz-date")
+	signedAt, err := time.Parse("20060102T150405Z", amzDate)
+	if err != nil || !strings.HasPrefix(amzDate, credential[1]) || time.Since(signedAt).Abs() > 15*time.Minute {
+		return fmt.Errorf("invalid request date %q", amzDate)
+	}
+	if r.Header.Get("x-amz-security-token") != testToken {
+		return fmt.Errorf("missing session token")
+	}
+
+	signedHeaders := strings.Split(fields["SignedHeaders"], ";")
+	for _, required := range []string{"host", "x-amz-content-sha256", "x-amz-date", "x-amz-security-token"} {
+		found := false
+		for _, name := range signedHeaders {
+			found = found || name == required
+		}
+		if !found {
+			return fmt.Errorf("%s is not signed", required)
+		}
+	}
+	var canonicalHeaders strings.Builder
+	for _, name := range signedHeaders {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+
+	// Query parameters are sorted and encoded with %20 for spaces
+	query := r.URL.Query()
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var params []string
+	for _, name := range names {
+		for _, value := range query[name] {
+			params = append(params, awsEscape(name)+"="+awsEscape(value))
+		}
+	}
+
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		r.URL.EscapedPath(),
+		strings.Join(params, "&"),
+		canonicalHeaders.String(),
+		fields["SignedHeaders"],
+		r.Header.Get("x-amz-content-sha256"),
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	scope := strings.Join(credential[1:], "/")
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + testSecretKey)
+	for _, part := range append(credential[1:], stringToSign) {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(part))
+		key = mac.Sum(nil)
+	}
+	if want := hex.EncodeToString(key); fields["Signature"] != want {
+		return fmt.Errorf("signature %s does not match %s", fields["Signature"], want)
+	}
+	return nil
+}
+
+func awsEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+// newTestS3 starts a fake S3 server and returns a storage using it. For
+// virtual-hosted style, every host is dialled at the server's address.
+func newTestS3(t *testing.T, pathStyle bool) (*fakeS3, *S3Storage) {
+	t.Helper()
+	fake := newFakeS3()
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	endpoint := server.URL
+	client := server.Client()
+	if !pathStyle {
+		addr := server.Listener.Addr().String()
+		endpoint = "http://s3.test"
+		client = &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, addr)
+			},
+		}}
+	}
+
+	st := NewS3Storage(testBucket, "runs", config.S3Config{
+		Endpoint:     endpoint,
+		Region:       testRegion,
+		AccessKey:    testAccessKey,
+		SecretKey:    testSecretKey,
+		SessionToken: testToken,
+		PathStyle:    pathStyle,
+	})
+	st.Client = client
+	st.PartSize = 1024
+	return fake, st
+}
+
+func writeTestFile(t *testing.T, dir, name string, data []byte) string {
+	t.Helper()
+	p := filepath.Join(dir, name)
+	if err := os.WriteFile(p, data, 0600); err != nil {
+		t.Fatalf("failed to write %s: %v", p, err)
+	}
+	return p
+}
+
+func testData(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	return data
+}
+
+func TestS3PutGetList(t *testing.T) {
+	for _, pathStyle := range []bool{true, false} {
+		fake, st := newTestS3(t, pathStyle)
+		ctx := context.Background()
+		dir := t.TempDir()
+
+		files := map[string][]byte{
+			"20240101_120000/structure.dump":   testData(100),
+			"20240101_120000/data.dump":        testData(2500), // three parts
+			"20240101_120000/exact.dump":       testData(2048), // two full parts
+			"20240101_120000/a b+c=d&e.dump":   testData(10),
+			"20240101_120000/empty.dump":       nil,
+			"20240102_080000/structure.dump":   testData(5),
+			"20240102_080000/nested/log.txt":   testData(5),
+			"20240102_080000/ünïcödé-ñame.sql": testData(5),
+		}
+		for key, data := range files {
+			local := writeTestFile(t, dir, "upload", data)
+			if err := st.Put(ctx, local, key); err != nil {
+				t.Fatalf("path style %t: Put(%q) error: %v", pathStyle, key, err)
+			}
+		}
+		if len(fake.uploads) != 0 {
+			t.Errorf("path style %t: %d multipart uploads left open", pathStyle, len(fake.uploads))
+		}
+		if got := fake.objects["runs/20240101_120000/data.dump"]; !bytes.Equal(got, files["20240101_120000/data.dump"]) {
+			t.Errorf("path style %t: multipart object holds %d bytes, want %d", pathStyle, len(got), 2500)
+		}
+
+		for key, data := range files {
+			local := filepath.Join(dir, "download")
+			if err := st.Get(ctx, key, local); err != nil {
+				t.Fatalf("path style %t: Get(%q) error: %v", pathStyle, key, err)
+			}
+			got, err := os.ReadFile(local)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("path style %t: Get(%q) = %d bytes, want %d", pathStyle, key, len(got), len(data))
+			}
+		}
+
+		if len(fake.rejected) > 0 {
+			t.Errorf("path style %t: requests rejected: %q", pathStyle, fake.rejected)
+		}
+
+		keys, err := st.List(ctx, "20240101_120000")
+		if err != nil {
+			t.Fatalf("path style %t: List() error: %v", pathStyle, err)
+		}
+		want := []string{
+			"20240101_120000/a b+c=d&e.dump",
+			"20240101_120000/data.dump",
+			"20240101_120000/empty.dump",
+			"20240101_120000/exact.dump",
+			"20240101_120000/structure.dump",
+		}
+		if strings.Join(keys, "\n") != strings.Join(want, "\n") {
+			t.Errorf("path style %t: List() = %q, want %q", pathStyle, keys, want)
+		}
+
+		if err := st.Get(ctx, "missing.dump", filepath.Join(dir, "missing")); err == nil {
+			t.Errorf("path style %t: Get of a missing key succeeded", pathStyle)
+		}
+	}
+}
+
+func TestS3FetchDumps(t *testing.T) {
+	_, st := newTestS3(t, true)
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	structure, data := GetDumpPaths("")
+	for _, name := range []string{structure, data, "migration.log"} {
+		if err := st.Put(ctx, writeTestFile(t, dir, name, []byte(name)), name); err != nil {
+			t.Fatalf("Put(%q) error: %v", name, err)
+		}
+	}
+
+	fetched, err := FetchDumps(ctx, st, filepath.Join(dir, "fetched"))
+	if err != nil {
+		t.Fatalf("FetchDumps() error: %v", err)
+	}
+	if len(fetched) != 2 {
+		t.Errorf("FetchDumps() = %q, want the structure and data dumps", fetched)
+	}
+}
+
+func TestS3MultipartAbort(t *testing.T) {
+	fake, st := newTestS3(t, true)
+	fake.failPart = 2
+
+	local := writeTestFile(t, t.TempDir(), "data.dump", testData(2500))
+	if err := st.Put(context.Background(), local, "data.dump"); err == nil {
+		t.Fatal("Put() succeeded although a part failed")
+	}
+	if fake.aborted != 1 || len(fake.uploads) != 0 {
+		t.Errorf("aborted %d uploads with %d left open, want the upload aborted", fake.aborted, len(fake.uploads))
+	}
+	if _, ok := fake.objects["runs/data.dump"]; ok {
+		t.Error("failed multipart upload created the object")
+	}
+}
+
+func TestS3WrongSecret(t *testing.T) {
+	fake, st := newTestS3(t, true)
+	st.SecretKey = "not-the-secret"
+
+	local := writeTestFile(t, t.TempDir(), "structure.dump", testData(10))
+	err := st.Put(context.Background(), local, "structure.dump")
+	if err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("Put() with wrong secret error = %v, want 403", err)
+	}
+	if len(fake.rejected) != 1 {
+		t.Errorf("server rejected %d requests, want 1", len(fake.rejected))
+	}
+}
@@ -0,0 +1,148 @@
+package filesystem
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/1CL0UD/cloudm-cli/internal/config"
+)
+
+// SFTPStorage stores artifacts on a remote host through the OpenSSH sftp
+// client, so authentication follows the usual ssh setup (agent, keys,
+// ~/.ssh/config) in addition to the configured identity file
+type SFTPStorage struct {
+	User           string
+	Host           string
+	Port           string
+	Root           string
+	IdentityFile   string
+	KnownHostsFile string
+}
+
+// NewSFTPStorage creates an SFTP backend for sftp://user@host[:port]/path
+func NewSFTPStorage(u *url.URL, cfg config.SFTPConfig) *SFTPStorage {
+	root := u.Path
+	if root == "" {
+		root = "."
+	}
+
+	return &SFTPStorage{
+		User:           u.User.Username(),
+		Host:           u.Hostname(),
+		Port:           u.Port(),
+		Root:           root,
+		IdentityFile:   cfg.IdentityFile,
+		KnownHostsFile: cfg.KnownHostsFile,
+	}
+}
+
+func (s *SFTPStorage) Put(ctx context.Context, localPath, key string) error {
+	remote := s.remotePath(key)
+
+	// Create missing parent directories; "-" makes sftp ignore failures for
+	// directories that already exist
+	var batch strings.Builder
+	dir := path.Dir(remote)
+	var parents []string
+	for d := dir; d != "." && d != "/" && d != ""; d = path.Dir(d) {
+		parents = append([]string{d}, parents...)
+	}
+	for _, p := range parents {
+		fmt.Fprintf(&batch, "-mkdir %s\n", quoteSFTP(p))
+	}
+	fmt.Fprintf(&batch, "put %s %s\n", quoteSFTP(localPath), quoteSFTP(remote))
+
+	if _, err := s.run(ctx, batch.String()); err != nil {
+		return fmt.Errorf("failed to upload %s: %w", s.Location(key), err)
+	}
+	return nil
+}
+
+func (s *SFTPStorage) Get(ctx context.Context, key, localPath string) error {
+	batch := fmt.Sprintf("get %s %s\n", quoteSFTP(s.remotePath(key)), quoteSFTP(localPath))
+	if _, err := s.run(ctx, batch); err != nil {
+		return fmt.Errorf("failed to download %s: %w", s.Location(key), err)
+	}
+	return nil
+}
+
+// List returns the files directly below prefix; sftp has no recursive
+// listing, which is enough for the flat layout of migration directories
+func (s *SFTPStorage) List(ctx context.Context, prefix string) ([]string, error) {
+	dir := s.remotePath(prefix)
+	output, err := s.run(ctx, fmt.Sprintf("ls -1 %s\n", quoteSFTP(dir)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", s.Location(prefix), err)
+	}
+
+	var keys []string
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "sftp>") {
+			continue
+		}
+		keys = append(keys, path.Join(prefix, path.Base(line)))
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *SFTPStorage) Location(key string) string {
+	host := s.Host
+	if s.Port != "" {
+		host += ":" + s.Port
+	}
+	if s.User != "" {
+		host = s.User + "@" + host
+	}
+	return "sftp://" + host + s.remotePath(key)
+}
+
+// remotePath returns the remote path of a key
+func (s *SFTPStorage) remotePath(key string) string {
+	return path.Join(s.Root, key)
+}
+
+// run executes an sftp batch and returns its output
+func (s *SFTPStorage) run(ctx context.Context, batch string) (string, error) {
+	args := []string{"-b", "-", "-o", "BatchMode=yes"}
+	if s.Port != "" {
+		args = append(args, "-P", s.Port)
+	}
+	if s.IdentityFile != "" {
+		args = append(args, "-i", s.IdentityFile)
+	}
+	if s.KnownHostsFile != "" {
+		args = append(args, "-o", "UserKnownHostsFile="+s.KnownHostsFile)
+	}
+
+	target := s.Host
+	if s.User != "" {
+		target = s.User + "@" + s.Host
+	}
+	args = append(args, target)
+
+	cmd := exec.CommandContext(ctx, "sftp", args...)
+	cmd.Stdin = strings.NewReader(batch)
+
+	var stdout, stderr strings.Builder
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("sftp failed: %w\nstderr: %s", err, stderr.String())
+	}
+
+	return stdout.String(), nil
+}
+
+// quoteSFTP quotes a path for an sftp batch command
+func quoteSFTP(p string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(p) + `"`
+}
@@ -16,6 +16,16 @@ type MigrationReport struct {
 	Files        []string
 	Success      bool
 	ErrorMessage string
+	Rollback     *RollbackReport
+}
+
+// RollbackReport records the automatic restore of the pre-migration backup
+// after a failed migration
+type RollbackReport struct {
+	Backup   string
+	Duration time.Duration
+	Success  bool
+	Error    string
 }
 
 type PhaseReport struct {
@@ -39,6 +49,17 @@ func GenerateReport(report MigrationReport, outputPath string) error {
 	}
 	sb.WriteString("\n")
 
+	if report.Rollback != nil {
+		sb.WriteString("Rollback:\n")
+		sb.WriteString(fmt.Sprintf("  Backup: %s\n", report.Rollback.Backup))
+		sb.WriteString(fmt.Sprintf("  Duration: %s\n", formatDuration(report.Rollback.Duration)))
+		sb.WriteString(fmt.Sprintf("  Status: %s\n", statusString(report.Rollback.Success)))
+		if report.Rollback.Error != "" {
+			sb.WriteString(fmt.Sprintf("  Error: %s\n", report.Rollback.Error))
+		}
+		sb.WriteString("\n")
+	}
+
 	sb.WriteString("Phase Breakdown:\n")
 	for _, phase := range report.Phases {
 		sb.WriteString(fmt.Sprintf("  %s: %s\n", phase.Name, formatDuration(phase.Duration)))
@@ -120,6 +141,30 @@ func GenerateValidationReport(source, target []postgres.TableStats, outputPath s
 	return os.WriteFile(outputPath, []byte(sb.String()), 0644)
 }
 
+// AppendLargeObjectReport appends a large object comparison to a validation report
+func AppendLargeObjectReport(source, target postgres.LargeObjectStats, outputPath string) error {
+	f, err := os.OpenFile(outputPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open validation report: %w", err)
+	}
+	defer f.Close()
+
+	report, hasDiscrepancy := postgres.CompareLargeObjectStats(source, target)
+
+	var sb strings.Builder
+	sb.WriteString("\n")
+	sb.WriteString(report)
+	sb.WriteString("\n")
+	if hasDiscrepancy {
+		sb.WriteString("⚠ WARNING: Large object discrepancies found!\n")
+	} else {
+		sb.WriteString("✓ All large objects match.\n")
+	}
+
+	_, err = f.WriteString(sb.String())
+	return err
+}
+
 // formatDuration formats a duration as HH:MM:SS
 func formatDuration(d time.Duration) string {
 	d = d.Round(time.Second)
@@ -0,0 +1,509 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/1CL0UD/cloudm-cli/internal/config"
+	"github.com/jackc/pgx/v5"
+)
+
+// Drift is a difference between the owners and privileges found on the
+// target and those the ownership plan produces, with the statement fixing it
+type Drift struct {
+	Object  string
+	Problem string
+	Fix     string
+	// LargeObjects is set when the fix changes the owner of large objects,
+	// which runs outside a transaction
+	LargeObjects *LargeObjectChange
+	// ActAs are the roles the admin user must act as to apply the fix
+	ActAs []string
+	// createsRole and altersDatabase mark fixes that need CREATEROLE or
+	// CREATEDB; grantee is the role a privilege fix grants to
+	createsRole    string
+	altersDatabase bool
+	grantee        string
+}
+
+// allPrivileges are the privileges ALL stands for on each kind of object
+var allPrivileges = map[string][]string{
+	"database":  {"CONNECT", "CREATE", "TEMPORARY"},
+	"schema":    {"USAGE", "CREATE"},
+	"tables":    {"SELECT", "INSERT", "UPDATE", "DELETE", "TRUNCATE", "REFERENCES", "TRIGGER"},
+	"sequences": {"USAGE", "SELECT", "UPDATE"},
+	"functions": {"EXECUTE"},
+}
+
+// defaultACLKinds are the kinds of objects of default privileges, with the
+// code pg_default_acl uses for them
+var defaultACLKinds = []struct {
+	code     string
+	category string
+	keyword  string
+}{
+	{"r", "tables", "TABLES"},
+	{"S", "sequences", "SEQUENCES"},
+	{"f", "functions", "FUNCTIONS"},
+}
+
+// aclEntries builds the expression listing the privileges granted in an ACL
+// column as privilege:grantee, leaving out PUBLIC
+func aclEntries(column string) string {
+	return fmt.Sprintf(`coalesce((
+		SELECT array_agg(a.privilege_type || ':' || pg_get_userbyid(a.grantee))
+		FROM aclexplode(%s) a
+		WHERE a.grantee <> 0), '{}')`, column)
+}
+
+// aclObjectQueries list the database, the given schemas and the tables,
+// sequences and functions in them with their kind of privileges, GRANT
+// keyword, schema, quoted name, owner and granted privileges
+var aclObjectQueries = []string{
+	`SELECT 'database', 'DATABASE', '', quote_ident(d.datname), pg_get_userbyid(d.datdba), ` + aclEntries("d.datacl") + `
+	FROM pg_database d
+	WHERE d.datname = current_database() AND $1::text[] IS NOT NULL`,
+
+	`SELECT 'schema', 'SCHEMA', n.nspname, quote_ident(n.nspname), pg_get_userbyid(n.nspowner), ` + aclEntries("n.nspacl") + `
+	FROM pg_namespace n
+	WHERE n.nspname = ANY($1)`,
+
+	`SELECT CASE c.relkind WHEN 'S' THEN 'sequences' ELSE 'tables' END,
+		CASE c.relkind WHEN 'S' THEN 'SEQUENCE' ELSE 'TABLE' END,
+		n.nspname, quote_ident(n.nspname) || '.' || quote_ident(c.relname),
+		pg_get_userbyid(c.relowner), ` + aclEntries("c.relacl") + `
+	FROM pg_class c
+	JOIN pg_namespace n ON n.oid = c.relnamespace
+	WHERE n.nspname = ANY($1) AND c.relkind IN ('r', 'p', 'v', 'm', 'f', 'S')
+	ORDER BY n.nspname, c.relname`,
+
+	`SELECT 'functions', 'FUNCTION', n.nspname,
+		quote_ident(n.nspname) || '.' || quote_ident(p.proname) || '(' || pg_get_function_identity_arguments(p.oid) || ')',
+		pg_get_userbyid(p.proowner), ` + aclEntries("p.proacl") + `
+	FROM pg_proc p
+	JOIN pg_namespace n ON n.oid = p.pronamespace
+	WHERE n.nspname = ANY($1) AND p.prokind IN ('f', 'w')
+	ORDER BY n.nspname, p.proname`,
+}
+
+// aclObject is an object with the privileges granted on it by grantee
+type aclObject struct {
+	Category string
+	Keyword  string
+	Schema   string
+	Identity string
+	Owner    string
+	Grants   map[string][]string
+}
+
+// AuditOwnership compares the owners, privileges and default privileges on
+// the target with those the ownership plan of the role mapping and grants
+// rules produces, and returns the differences. Only the privileges of roles
+// the plan grants to are compared; others are left alone.
+func AuditOwnership(ctx context.Context, cfg config.TargetConfig, mapping RoleMapping, grants []GrantRule) ([]Drift, error) {
+	connStr := GetTargetConnectionString(cfg)
+	conn, err := pgx.Connect(ctx, connStr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	objects, err := listOwnedObjects(ctx, conn, mapping.Schemas)
+	if err != nil {
+		return nil, err
+	}
+	largeObjects, err := listLargeObjectOwners(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	roles, err := listRoles(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	// Without grants rules the plan grants everything to the default role,
+	// and default privileges apply to objects created by the admin user
+	rules := grants
+	creators := func(schema string) []string {
+		return defaultCreators(rules, schema, mapping.Default)
+	}
+	if len(grants) == 0 {
+		all := []string{"ALL"}
+		grants = []GrantRule{{
+			Role:       mapping.Default,
+			Schemas:    mapping.Schemas,
+			Privileges: PrivilegeSet{Database: all, Schema: all, Tables: all, Sequences: all, Functions: all},
+		}}
+		creators = func(string) []string { return []string{cfg.AdminUser} }
+	}
+
+	schemas := OwnershipSchemas(mapping, grants)
+	aclObjects, err := listACLObjects(ctx, conn, schemas)
+	if err != nil {
+		return nil, err
+	}
+	defaults, err := listDefaultACLs(ctx, conn, schemas)
+	if err != nil {
+		return nil, err
+	}
+
+	var drifts []Drift
+
+	// Roles
+	expectedRoles := []string{mapping.Default}
+	for _, o := range objects {
+		expectedRoles = append(expectedRoles, mapping.OwnerFor(o.ObjectKey))
+	}
+	for _, r := range grants {
+		expectedRoles = append(expectedRoles, r.Role)
+	}
+	largeObjectOwner, mapped := mapping.LargeObjectOwner()
+	if mapped && len(largeObjects) > 0 {
+		expectedRoles = append(expectedRoles, largeObjectOwner)
+	}
+	for _, role := range expectedRoles {
+		if _, ok := roles[role]; ok || role == "" {
+			continue
+		}
+		roles[role] = false
+		drifts = append(drifts, Drift{
+			Object:  "role " + role,
+			Problem: "does not exist",
+			Fix:     "CREATE ROLE " + quoteIdent(role) + " NOLOGIN",
+
+			createsRole: role,
+		})
+	}
+
+	// Owners, recording the owner each object has once fixed
+	owners := make(map[string]string)
+	for _, o := range aclObjects {
+		if o.Category == "database" && o.Owner != mapping.Default {
+			drift := ownerDrift("DATABASE", o.Identity, o.Owner, mapping.Default)
+			drift.altersDatabase = true
+			drifts = append(drifts, drift)
+			owners[o.Identity] = mapping.Default
+		}
+	}
+	for _, o := range objects {
+		owner := mapping.OwnerFor(o.ObjectKey)
+		if owner == o.Owner || (superuserOwnedKinds[o.Kind] && !roles[owner]) {
+			continue
+		}
+		drifts = append(drifts, ownerDrift(o.Kind, o.Identity, o.Owner, owner))
+		owners[o.Identity] = owner
+	}
+	for _, lo := range largeObjects {
+		if !mapped || lo.Owner == largeObjectOwner {
+			continue
+		}
+		change := LargeObjectChange{From: lo.Owner, To: largeObjectOwner, Count: lo.Count}
+		drifts = append(drifts, Drift{
+			Object:       fmt.Sprintf("%d large objects", lo.Count),
+			Problem:      fmt.Sprintf("owned by %s instead of %s", lo.Owner, largeObjectOwner),
+			Fix:          change.SQL(),
+			LargeObjects: &change,
+			ActAs:        []string{lo.Owner, largeObjectOwner},
+		})
+	}
+
+	// Privileges on objects, granted by their owner
+	for _, o := range aclObjects {
+		owner := o.Owner
+		if fixed, ok := owners[o.Identity]; ok {
+			owner = fixed
+		}
+		expected, exact := expectedPrivileges(grants, o.Category, func(r GrantRule) bool {
+			return o.Category == "database" || containsString(r.Schemas, o.Schema)
+		})
+		for _, role := range sortedKeys(expected) {
+			if role == o.Owner {
+				continue
+			}
+			name := strings.ToLower(o.Keyword) + " " + o.Identity
+			target := o.Keyword + " " + o.Identity
+			drifts = append(drifts, privilegeDrifts(name, owner, role, expected[role], o.Grants[role], exact[role],
+				func(privileges, role string) string { return "GRANT " + privileges + " ON " + target + " TO " + role },
+				func(privileges, role string) string {
+					return "REVOKE " + privileges + " ON " + target + " FROM " + role
+				})...)
+		}
+	}
+
+	// Default privileges
+	for _, schema := range schemas {
+		for _, creator := range creators(schema) {
+			for _, kind := range defaultACLKinds {
+				expected, exact := expectedPrivileges(grants, kind.category, func(r GrantRule) bool {
+					return r.Role != creator && containsString(r.Schemas, schema)
+				})
+				actual := defaults[defaultACLKey{creator, schema, kind.code}]
+				for _, role := range sortedKeys(expected) {
+					name := fmt.Sprintf("default privileges of %s on %s in schema %s", creator, strings.ToLower(kind.keyword), schema)
+					prefix := fmt.Sprintf("ALTER DEFAULT PRIVILEGES FOR ROLE %s IN SCHEMA %s ", quoteIdent(creator), quoteIdent(schema))
+					drifts = append(drifts, privilegeDrifts(name, creator, role, expected[role], actual[role], exact[role],
+						func(privileges, role string) string {
+							return prefix + "GRANT " + privileges + " ON " + kind.keyword + " TO " + role
+						},
+						func(privileges, role string) string {
+							return prefix + "REVOKE " + privileges + " ON " + kind.keyword + " FROM " + role
+						})...)
+				}
+			}
+		}
+	}
+
+	return drifts, nil
+}
+
+// PlanFixes builds the plan applying the fixes of drifts found in the
+// given schemas. When the admin user is not a superuser, it is granted
+// membership in the roles the fixes act as for the length of the plan, and
+// the fixes it cannot apply are left out and reported.
+func PlanFixes(ctx context.Context, cfg config.TargetConfig, schemas []string, drifts []Drift) (OwnershipPlan, error) {
+	connStr := GetTargetConnectionString(cfg)
+	conn, err := pgx.Connect(ctx, connStr)
+	if err != nil {
+		return OwnershipPlan{}, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	admin, err := readAdminAccess(ctx, conn, schemas)
+	if err != nil {
+		return OwnershipPlan{}, err
+	}
+
+	return buildFixPlan(drifts, &admin), nil
+}
+
+// buildFixPlan builds the plan applying the fixes of drifts as the admin
+// user
+func buildFixPlan(drifts []Drift, admin *AdminAccess) OwnershipPlan {
+	var plan OwnershipPlan
+	add := func(stmt string) {
+		plan.Statements = append(plan.Statements, stmt)
+	}
+	managed := admin != nil && !admin.Superuser
+	plan.Managed = managed
+	members := &memberships{admin: admin, plan: &plan, add: add, granted: make(map[string]bool), denied: make(map[string]bool)}
+	impossible := func(format string, args ...any) {
+		plan.Impossible = append(plan.Impossible, fmt.Sprintf(format, args...))
+	}
+
+	uncreated := make(map[string]bool)
+	for _, d := range drifts {
+		switch {
+		case d.createsRole != "" && managed && !admin.CreateRole:
+			impossible("create role %s (%s lacks CREATEROLE)", d.createsRole, admin.User)
+			uncreated[d.createsRole] = true
+			members.denied[d.createsRole] = true
+		case d.createsRole != "":
+			plan.CreatedRoles = append(plan.CreatedRoles, d.createsRole)
+			add(d.Fix)
+		case d.altersDatabase && managed && !admin.CreateDB:
+			impossible("fix %s: %s (%s lacks CREATEDB)", d.Object, d.Problem, admin.User)
+		case uncreated[d.grantee]:
+			impossible("fix %s: %s (the role was not created)", d.Object, d.Problem)
+		case !members.actAsAll(d.ActAs...):
+			impossible("fix %s: %s (%s cannot be granted membership in %s)",
+				d.Object, d.Problem, admin.User, members.blocked(d.ActAs...))
+		case d.LargeObjects != nil:
+			plan.LargeObjects = append(plan.LargeObjects, *d.LargeObjects)
+		default:
+			add(d.Fix)
+		}
+	}
+
+	if managed {
+		members.revokeAll()
+	}
+
+	return plan
+}
+
+// ownerDrift reports an object owned by the wrong role
+func ownerDrift(kind, identity, actual, expected string) Drift {
+	return Drift{
+		Object:  strings.ToLower(kind) + " " + identity,
+		Problem: fmt.Sprintf("owned by %s instead of %s", actual, expected),
+		Fix:     alterOwnerSQL(kind, identity, expected),
+		ActAs:   []string{actual, expected},
+	}
+}
+
+// expectedPrivileges returns the privileges the grants rules selected by
+// match give each role on a kind of object, and whether they are all of
+// them, in which case other privileges the role holds are not extra
+func expectedPrivileges(rules []GrantRule, category string, match func(GrantRule) bool) (map[string][]string, map[string]bool) {
+	expected := make(map[string][]string)
+	all := make(map[string]bool)
+	for _, r := range rules {
+		if !match(r) {
+			continue
+		}
+		for _, p := range privilegesFor(r.Privileges, category) {
+			if p == "ALL" {
+				all[r.Role] = true
+				for _, a := range allPrivileges[category] {
+					expected[r.Role] = appendUnique(expected[r.Role], a)
+				}
+				continue
+			}
+			if p == "TEMP" {
+				p = "TEMPORARY"
+			}
+			expected[r.Role] = appendUnique(expected[r.Role], p)
+		}
+	}
+	return expected, all
+}
+
+// privilegesFor returns the privileges of a set on a kind of object
+func privilegesFor(set PrivilegeSet, category string) []string {
+	switch category {
+	case "database":
+		return set.Database
+	case "schema":
+		return set.Schema
+	case "tables":
+		return set.Tables
+	case "sequences":
+		return set.Sequences
+	case "functions":
+		return set.Functions
+	}
+	return nil
+}
+
+// privilegeDrifts compares the privileges a role holds with those expected.
+// Missing privileges are granted by the statement grant builds and, unless
+// the role is expected to hold all of them, extra ones revoked by the
+// statement revoke builds; both take the privileges and the quoted role.
+// The statements are run as grantor.
+func privilegeDrifts(object, grantor, role string, expected, actual []string, all bool, grant, revoke func(privileges, role string) string) []Drift {
+	var missing, extra []string
+	for _, p := range expected {
+		if !containsString(actual, p) {
+			missing = append(missing, p)
+		}
+	}
+	if !all {
+		for _, p := range actual {
+			if !containsString(expected, p) {
+				extra = append(extra, p)
+			}
+		}
+	}
+
+	var drifts []Drift
+	if len(missing) > 0 {
+		list := privilegeList(missing)
+		drifts = append(drifts, Drift{
+			Object:  object,
+			Problem: fmt.Sprintf("%s lacks %s", role, list),
+			Fix:     grant(list, quoteIdent(role)),
+			ActAs:   []string{grantor},
+			grantee: role,
+		})
+	}
+	if len(extra) > 0 {
+		list := privilegeList(extra)
+		drifts = append(drifts, Drift{
+			Object:  object,
+			Problem: fmt.Sprintf("%s has extra %s", role, list),
+			Fix:     revoke(list, quoteIdent(role)),
+			ActAs:   []string{grantor},
+			grantee: role,
+		})
+	}
+	return drifts
+}
+
+// listACLObjects returns the database, the given schemas and the tables,
+// sequences and functions in them with their privileges
+func listACLObjects(ctx context.Context, conn *pgx.Conn, schemas []string) ([]aclObject, error) {
+	var objects []aclObject
+	for _, query := range aclObjectQueries {
+		rows, err := conn.Query(ctx, query, schemas)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list privileges: %w", err)
+		}
+		found, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (aclObject, error) {
+			var o aclObject
+			var entries []string
+			err := row.Scan(&o.Category, &o.Keyword, &o.Schema, &o.Identity, &o.Owner, &entries)
+			o.Grants = parseACLEntries(entries)
+			return o, err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list privileges: %w", err)
+		}
+		objects = append(objects, found...)
+	}
+	return objects, nil
+}
+
+// defaultACLKey identifies the default privileges of objects created by a
+// role in a schema
+type defaultACLKey struct {
+	Role   string
+	Schema string
+	Kind   string
+}
+
+// listDefaultACLs returns the default privileges set in the given schemas
+func listDefaultACLs(ctx context.Context, conn *pgx.Conn, schemas []string) (map[defaultACLKey]map[string][]string, error) {
+	rows, err := conn.Query(ctx, `
+		SELECT pg_get_userbyid(d.defaclrole), n.nspname, d.defaclobjtype::text, `+aclEntries("d.defaclacl")+`
+		FROM pg_default_acl d
+		JOIN pg_namespace n ON n.oid = d.defaclnamespace
+		WHERE n.nspname = ANY($1)`, schemas)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list default privileges: %w", err)
+	}
+
+	defaults := make(map[defaultACLKey]map[string][]string)
+	var key defaultACLKey
+	var entries []string
+	_, err = pgx.ForEachRow(rows, []any{&key.Role, &key.Schema, &key.Kind, &entries}, func() error {
+		defaults[key] = parseACLEntries(entries)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list default privileges: %w", err)
+	}
+
+	return defaults, nil
+}
+
+// parseACLEntries groups privilege:grantee entries by grantee
+func parseACLEntries(entries []string) map[string][]string {
+	grants := make(map[string][]string)
+	for _, e := range entries {
+		privilege, grantee, ok := strings.Cut(e, ":")
+		if ok {
+			grants[grantee] = appendUnique(grants[grantee], privilege)
+		}
+	}
+	return grants
+}
+
+// appendUnique appends s to list unless it is already there
+func appendUnique(list []string, s string) []string {
+	if containsString(list, s) {
+		return list
+	}
+	return append(list, s)
+}
+
+// sortedKeys returns the keys of a map in order
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
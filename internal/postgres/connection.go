@@ -3,6 +3,8 @@ package postgres
 import (
 	"context"
 	"fmt"
+	"net"
+	"strings"
 	"time"
 
 	"github.com/1CL0UD/cloudm-cli/internal/config"
@@ -93,4 +95,66 @@ func GetTargetPostgresConnectionString(cfg config.TargetConfig) string {
 // Connect establishes a connection to the database
 func Connect(ctx context.Context, connStr string) (*pgx.Conn, error) {
 	return pgx.Connect(ctx, connStr)
+}
+
+// SameDatabase reports whether the source and target configurations lead to
+// the same database on the same server. Servers are compared by their system
+// identifier when both can read it, and by resolved address and port
+// otherwise.
+func SameDatabase(ctx context.Context, source config.DatabaseConfig, target config.TargetConfig) (bool, error) {
+	srcConn, err := pgx.Connect(ctx, GetConnectionString(source))
+	if err != nil {
+		return false, fmt.Errorf("failed to connect to source database: %w", err)
+	}
+	defer srcConn.Close(ctx)
+
+	dstConn, err := pgx.Connect(ctx, GetTargetConnectionString(target))
+	if err != nil {
+		return false, fmt.Errorf("failed to connect to target database: %w", err)
+	}
+	defer dstConn.Close(ctx)
+
+	var srcDB, dstDB string
+	if err := srcConn.QueryRow(ctx, "SELECT current_database()").Scan(&srcDB); err != nil {
+		return false, fmt.Errorf("failed to read source database name: %w", err)
+	}
+	if err := dstConn.QueryRow(ctx, "SELECT current_database()").Scan(&dstDB); err != nil {
+		return false, fmt.Errorf("failed to read target database name: %w", err)
+	}
+	if srcDB != dstDB {
+		return false, nil
+	}
+
+	// pg_control_system() may be restricted, e.g. on managed services
+	const systemID = "SELECT system_identifier::text FROM pg_control_system()"
+	var srcID, dstID string
+	if srcConn.QueryRow(ctx, systemID).Scan(&srcID) == nil && dstConn.QueryRow(ctx, systemID).Scan(&dstID) == nil {
+		return srcID == dstID, nil
+	}
+
+	return source.Port == target.Port && sameHost(source.Host, target.Host), nil
+}
+
+// sameHost reports whether two host names resolve to a common address
+func sameHost(a, b string) bool {
+	if strings.EqualFold(a, b) {
+		return true
+	}
+
+	addrsA, errA := net.LookupHost(a)
+	addrsB, errB := net.LookupHost(b)
+	if errA != nil || errB != nil {
+		return false
+	}
+
+	seen := make(map[string]bool, len(addrsA))
+	for _, addr := range addrsA {
+		seen[addr] = true
+	}
+	for _, addr := range addrsB {
+		if seen[addr] {
+			return true
+		}
+	}
+	return false
 }
\ No newline at end of file
@@ -0,0 +1,162 @@
+package postgres
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/1CL0UD/cloudm-cli/internal/config"
+	"github.com/jackc/pgx/v5"
+)
+
+// scramIterations is the iteration count of SCRAM-SHA-256 verifiers, the
+// same as the server's default
+const scramIterations = 4096
+
+// GeneratePassword returns a random password with 256 bits of entropy
+func GeneratePassword() string {
+	return rand.Text() + rand.Text()
+}
+
+// scramVerifier computes the SCRAM-SHA-256 verifier of a password, so that
+// the password itself never reaches the server or its logs. Passwords
+// outside printable ASCII are returned as they are and hashed by the
+// server, which normalizes them first.
+func scramVerifier(password string) (string, error) {
+	for _, r := range password {
+		if r < 0x20 || r > 0x7e {
+			return password, nil
+		}
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+	salted, err := pbkdf2.Key(sha256.New, password, salt, scramIterations, sha256.Size)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	clientKey := hmacSHA256(salted, "Client Key")
+	storedKey := sha256.Sum256(clientKey)
+	serverKey := hmacSHA256(salted, "Server Key")
+
+	enc := base64.StdEncoding
+	return fmt.Sprintf("SCRAM-SHA-256$%d:%s$%s:%s", scramIterations,
+		enc.EncodeToString(salt), enc.EncodeToString(storedKey[:]), enc.EncodeToString(serverKey)), nil
+}
+
+// hmacSHA256 returns the HMAC-SHA-256 of message under key
+func hmacSHA256(key []byte, message string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(message))
+	return mac.Sum(nil)
+}
+
+// RoleExists reports whether a role exists on the target
+func RoleExists(ctx context.Context, cfg config.TargetConfig, role string) (bool, error) {
+	connStr := GetTargetConnectionString(cfg)
+	conn, err := pgx.Connect(ctx, connStr)
+	if err != nil {
+		return false, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	var exists bool
+	err = conn.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM pg_roles WHERE rolname = $1)", role).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to check if role %s exists: %w", role, err)
+	}
+	return exists, nil
+}
+
+// CreateAppUser creates the app user with the SCRAM-SHA-256 verifier of a
+// password
+func CreateAppUser(ctx context.Context, cfg config.TargetConfig, appUser, password string) error {
+	verifier, err := scramVerifier(password)
+	if err != nil {
+		return err
+	}
+
+	connStr := GetTargetConnectionString(cfg)
+	conn, err := pgx.Connect(ctx, connStr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	if _, err := conn.Exec(ctx, createUserSQL(appUser, verifier)); err != nil {
+		return fmt.Errorf("failed to create user %s: %w", appUser, err)
+	}
+	return nil
+}
+
+// RotatePassword changes the password of a role to the SCRAM-SHA-256
+// verifier of a new one. Changing a password does not end the sessions
+// already opened with the old one, so it returns the sessions of the role
+// that started before the change.
+func RotatePassword(ctx context.Context, cfg config.TargetConfig, role, password string) ([]Session, error) {
+	verifier, err := scramVerifier(password)
+	if err != nil {
+		return nil, err
+	}
+
+	connStr := GetTargetConnectionString(cfg)
+	conn, err := pgx.Connect(ctx, connStr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	if _, err := conn.Exec(ctx, alterPasswordSQL(role, verifier)); err != nil {
+		return nil, fmt.Errorf("failed to change password of %s: %w", role, err)
+	}
+
+	var changedAt time.Time
+	if err := conn.QueryRow(ctx, "SELECT clock_timestamp()").Scan(&changedAt); err != nil {
+		return nil, fmt.Errorf("failed to read server time: %w", err)
+	}
+
+	return listRoleSessions(ctx, conn, role, changedAt)
+}
+
+// ListRoleSessions returns the sessions of a role on every database of the
+// target server
+func ListRoleSessions(ctx context.Context, cfg config.TargetConfig, role string) ([]Session, error) {
+	connStr := GetTargetPostgresConnectionString(cfg)
+	conn, err := pgx.Connect(ctx, connStr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to postgres database: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	return listRoleSessions(ctx, conn, role, time.Now())
+}
+
+// listRoleSessions returns the sessions of a role that started before a
+// given time
+func listRoleSessions(ctx context.Context, conn *pgx.Conn, role string, before time.Time) ([]Session, error) {
+	rows, err := conn.Query(ctx, sessionColumns+`
+		WHERE usename = $1 AND backend_start < $2 AND pid <> pg_backend_pid()
+		ORDER BY backend_start, pid`, role, before)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions of %s: %w", role, err)
+	}
+
+	sessions, err := pgx.CollectRows(rows, scanSession)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions of %s: %w", role, err)
+	}
+	return sessions, nil
+}
+
+// alterPasswordSQL builds the statement changing the password of a role
+func alterPasswordSQL(role, password string) string {
+	return fmt.Sprintf("ALTER ROLE %s WITH PASSWORD %s", quoteIdent(role), quoteLiteral(password))
+}
\ No newline at end of file
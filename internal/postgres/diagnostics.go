@@ -0,0 +1,361 @@
+package postgres
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/1CL0UD/cloudm-cli/internal/config"
+)
+
+// Diagnostic severities
+const (
+	SeverityFatal   = "fatal"
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Diagnostic is a message reported by pg_restore, attributed to the TOC
+// entry being processed when it was emitted
+type Diagnostic struct {
+	Severity string
+	Category string
+	SQLState string
+	Object   string
+	DumpID   int
+	Message  string
+	Detail   string
+	Command  string
+
+	// Ignored is set when an ignore rule matched, with the rule's reason
+	Ignored bool
+	Reason  string
+}
+
+// String formats a diagnostic for logs and error messages
+func (d Diagnostic) String() string {
+	var sb strings.Builder
+	sb.WriteString(d.Severity)
+	if d.Category != "" {
+		fmt.Fprintf(&sb, " [%s]", d.Category)
+	}
+	if d.Object != "" {
+		fmt.Fprintf(&sb, " %s", d.Object)
+	}
+	fmt.Fprintf(&sb, ": %s", d.Message)
+	return sb.String()
+}
+
+// IgnoreRule marks matching diagnostics as harmless. Every non-empty field
+// must match: Message is a regular expression, Object a wildcard pattern
+// on "TYPE schema.name".
+type IgnoreRule struct {
+	Message  *regexp.Regexp
+	Category string
+	Object   string
+	Reason   string
+}
+
+// DefaultIgnoreRules cover messages expected when restoring into a
+// prepared or managed target
+var DefaultIgnoreRules = []config.RestoreIgnoreRule{
+	{Message: `^schema "public" already exists$`, Reason: "the public schema is recreated before restore"},
+	{Message: `^extension "[^"]+" already exists$`, Reason: "extensions are created before restore"},
+	{Message: `^must be owner of extension `, Object: "COMMENT *", Reason: "extension comments need the extension owner"},
+}
+
+// Matches reports whether a rule applies to a diagnostic
+func (r IgnoreRule) Matches(d Diagnostic) bool {
+	if r.Message != nil && !r.Message.MatchString(d.Message) {
+		return false
+	}
+	if r.Category != "" && r.Category != d.Category && r.Category != d.SQLState {
+		return false
+	}
+	if r.Object != "" {
+		ok, err := path.Match(r.Object, d.Object)
+		if err != nil || !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// CompileIgnoreRules compiles the default ignore rules followed by the
+// configured ones
+func CompileIgnoreRules(rules []config.RestoreIgnoreRule) ([]IgnoreRule, error) {
+	all := append(append([]config.RestoreIgnoreRule(nil), DefaultIgnoreRules...), rules...)
+
+	compiled := make([]IgnoreRule, 0, len(all))
+	for _, r := range all {
+		rule := IgnoreRule{Category: r.Category, Object: r.Object, Reason: r.Reason}
+		if r.Message != "" {
+			re, err := regexp.Compile(r.Message)
+			if err != nil {
+				return nil, fmt.Errorf("invalid ignore rule message %q: %w", r.Message, err)
+			}
+			rule.Message = re
+		}
+		if rule.Reason == "" {
+			rule.Reason = "ignored by configuration"
+		}
+		compiled = append(compiled, rule)
+	}
+	return compiled, nil
+}
+
+// DiagnosticLog collects the classified diagnostics of the pg_restore runs
+// of a phase. It is safe for concurrent use.
+type DiagnosticLog struct {
+	rules []IgnoreRule
+
+	mu          sync.Mutex
+	diagnostics []Diagnostic
+}
+
+// NewDiagnosticLog creates a log applying the given ignore rules
+func NewDiagnosticLog(rules []IgnoreRule) *DiagnosticLog {
+	return &DiagnosticLog{rules: rules}
+}
+
+// Add classifies diagnostics against the ignore rules and records them.
+// It returns the diagnostics that are errors and were not ignored.
+func (l *DiagnosticLog) Add(diagnostics []Diagnostic) []Diagnostic {
+	var failures []Diagnostic
+	for i := range diagnostics {
+		d := &diagnostics[i]
+		for _, rule := range l.rules {
+			if rule.Matches(*d) {
+				d.Ignored = true
+				d.Reason = rule.Reason
+				break
+			}
+		}
+		if !d.Ignored && d.Severity != SeverityWarning {
+			failures = append(failures, *d)
+		}
+	}
+
+	l.mu.Lock()
+	l.diagnostics = append(l.diagnostics, diagnostics...)
+	l.mu.Unlock()
+
+	return failures
+}
+
+// Diagnostics returns all recorded diagnostics
+func (l *DiagnosticLog) Diagnostics() []Diagnostic {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]Diagnostic(nil), l.diagnostics...)
+}
+
+// DiagnosticSummary counts diagnostics of a phase
+type DiagnosticSummary struct {
+	Errors   int
+	Warnings int
+	Ignored  int
+
+	// ByCategory counts errors and warnings that were not ignored
+	ByCategory map[string]int
+	// ByReason counts ignored diagnostics per rule reason
+	ByReason map[string]int
+}
+
+// Summary counts the recorded diagnostics
+func (l *DiagnosticLog) Summary() DiagnosticSummary {
+	s := DiagnosticSummary{ByCategory: make(map[string]int), ByReason: make(map[string]int)}
+	for _, d := range l.Diagnostics() {
+		switch {
+		case d.Ignored:
+			s.Ignored++
+			s.ByReason[d.Reason]++
+		case d.Severity == SeverityWarning:
+			s.Warnings++
+			s.ByCategory[d.Category]++
+		default:
+			s.Errors++
+			s.ByCategory[d.Category]++
+		}
+	}
+	return s
+}
+
+// SortedKeys returns the keys of a count map, most frequent first
+func SortedKeys(counts map[string]int) []string {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+// categoryPatterns map server messages to SQLSTATE classes. pg_restore
+// doesn't print SQLSTATE codes, so they are inferred from the message.
+var categoryPatterns = []struct {
+	pattern  *regexp.Regexp
+	category string
+	sqlState string
+}{
+	{regexp.MustCompile(`^duplicate key value violates unique constraint`), "unique_violation", "23505"},
+	{regexp.MustCompile(`violates foreign key constraint`), "foreign_key_violation", "23503"},
+	{regexp.MustCompile(`violates not-null constraint`), "not_null_violation", "23502"},
+	{regexp.MustCompile(`violates check constraint`), "check_violation", "23514"},
+	{regexp.MustCompile(`^relation .* already exists`), "duplicate_table", "42P07"},
+	{regexp.MustCompile(`^schema .* already exists`), "duplicate_schema", "42P06"},
+	{regexp.MustCompile(`already exists`), "duplicate_object", "42710"},
+	{regexp.MustCompile(`^role .* does not exist`), "undefined_role", "42704"},
+	{regexp.MustCompile(`^(relation|table) .* does not exist`), "undefined_table", "42P01"},
+	{regexp.MustCompile(`^function .* does not exist`), "undefined_function", "42883"},
+	{regexp.MustCompile(`^column .* does not exist`), "undefined_column", "42703"},
+	{regexp.MustCompile(`^schema .* does not exist`), "invalid_schema_name", "3F000"},
+	{regexp.MustCompile(`(could not open extension control file|extension .* is not available|is not available)`), "undefined_file", "58P01"},
+	{regexp.MustCompile(`does not exist`), "undefined_object", "42704"},
+	{regexp.MustCompile(`^(permission denied|must be owner|must be superuser|must be member)`), "insufficient_privilege", "42501"},
+	{regexp.MustCompile(`^syntax error`), "syntax_error", "42601"},
+	{regexp.MustCompile(`^invalid input syntax`), "invalid_text_representation", "22P02"},
+	{regexp.MustCompile(`(out of memory|no space left on device|could not extend file)`), "insufficient_resources", "53000"},
+	{regexp.MustCompile(`(password authentication failed|no pg_hba.conf entry)`), "invalid_authorization", "28000"},
+	{regexp.MustCompile(`(could not connect|connection to server|server closed the connection|terminating connection)`), "connection_failure", "08006"},
+	{regexp.MustCompile(`^(could not open input file|could not read input file|input file .* does not appear|unsupported version|did not find magic string)`), "invalid_archive", ""},
+}
+
+// classifyMessage infers the category and SQLSTATE of a message
+func classifyMessage(msg string) (category, sqlState string) {
+	for _, p := range categoryPatterns {
+		if p.pattern.MatchString(msg) {
+			return p.category, p.sqlState
+		}
+	}
+	return "other", ""
+}
+
+// serverMessage matches a server message embedded in a client message
+var serverMessage = regexp.MustCompile(`(ERROR|FATAL|PANIC|WARNING):\s+(.*)$`)
+
+// archiverPrefix matches the "[archiver (db)] " prefix of pg_restore < 12
+var archiverPrefix = regexp.MustCompile(`^\[[^\]]+\]\s*`)
+
+// ParseRestoreOutput parses pg_restore stderr into diagnostics. Progress
+// messages printed in verbose mode are skipped.
+func ParseRestoreOutput(stderr string) []Diagnostic {
+	var diagnostics []Diagnostic
+	var current *TOCEntry
+	var last *Diagnostic
+	inCommand := false
+
+	for _, line := range strings.Split(stderr, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			continue
+		}
+
+		body, fromRestore := strings.CutPrefix(trimmed, "pg_restore: ")
+		if !fromRestore {
+			switch {
+			case strings.HasPrefix(trimmed, "Command was:"):
+				if last != nil {
+					last.Command = strings.TrimSpace(strings.TrimPrefix(trimmed, "Command was:"))
+					inCommand = true
+				}
+			case strings.HasPrefix(trimmed, "DETAIL:"), strings.HasPrefix(trimmed, "HINT:"):
+				if last != nil {
+					last.Detail = strings.TrimSpace(joinNonEmpty(last.Detail, trimmed, "\n"))
+				}
+				inCommand = false
+			case strings.HasPrefix(trimmed, "WARNING:"):
+				d := newDiagnostic(SeverityWarning, strings.TrimSpace(strings.TrimPrefix(trimmed, "WARNING:")), current)
+				diagnostics = append(diagnostics, d)
+				last = &diagnostics[len(diagnostics)-1]
+				inCommand = false
+			case inCommand && last != nil:
+				last.Command = joinNonEmpty(last.Command, trimmed, "\n")
+			}
+			continue
+		}
+		inCommand = false
+
+		body = archiverPrefix.ReplaceAllString(body, "")
+		lower := strings.ToLower(body)
+
+		switch {
+		case strings.Contains(lower, "while processing toc"), strings.HasPrefix(lower, "while initializing"), strings.HasPrefix(lower, "while finishing"):
+			// Context follows on the next line
+			continue
+
+		case strings.HasPrefix(lower, "from toc entry "), strings.HasPrefix(lower, "error from toc entry "):
+			// Parse the untrimmed line: an entry without owner ends in a space
+			raw := line[strings.Index(line, body):]
+			idx := strings.Index(strings.ToLower(raw), "toc entry ")
+			if e, err := parseTOCLine(strings.TrimRight(raw[idx+len("toc entry "):], "\r")); err == nil {
+				current = &e
+			}
+			continue
+
+		case strings.HasPrefix(lower, "warning: errors ignored on restore"):
+			// pg_restore's own tally of the errors parsed here
+			continue
+
+		case strings.HasPrefix(lower, "warning: "):
+			d := newDiagnostic(SeverityWarning, body[len("warning: "):], current)
+			diagnostics = append(diagnostics, d)
+			last = &diagnostics[len(diagnostics)-1]
+
+		case strings.HasPrefix(lower, "error: "), strings.HasPrefix(lower, "fatal: "), strings.HasPrefix(lower, "could not execute query"):
+			msg := body
+			severity := SeverityError
+			if strings.HasPrefix(lower, "fatal: ") {
+				severity = SeverityFatal
+			}
+			if i := strings.Index(msg, ": "); i >= 0 && (strings.HasPrefix(lower, "error: ") || strings.HasPrefix(lower, "fatal: ")) {
+				msg = msg[i+2:]
+			}
+			if m := serverMessage.FindStringSubmatch(msg); m != nil {
+				msg = m[2]
+				switch m[1] {
+				case "FATAL", "PANIC":
+					severity = SeverityFatal
+				case "WARNING":
+					severity = SeverityWarning
+				}
+			}
+			d := newDiagnostic(severity, msg, current)
+			diagnostics = append(diagnostics, d)
+			last = &diagnostics[len(diagnostics)-1]
+		}
+	}
+
+	return diagnostics
+}
+
+// newDiagnostic creates a classified diagnostic for the current entry
+func newDiagnostic(severity, msg string, entry *TOCEntry) Diagnostic {
+	msg = strings.TrimSpace(msg)
+	category, sqlState := classifyMessage(msg)
+	d := Diagnostic{Severity: severity, Category: category, SQLState: sqlState, Message: msg}
+	if entry != nil {
+		d.DumpID = entry.DumpID
+		d.Object = strings.TrimSpace(entry.Type + " " + qualifiedEntryName(*entry))
+	}
+	return d
+}
+
+// joinNonEmpty joins two strings with a separator, skipping empty ones
+func joinNonEmpty(a, b, sep string) string {
+	if a == "" {
+		return b
+	}
+	if b == "" {
+		return a
+	}
+	return a + sep + b
+}
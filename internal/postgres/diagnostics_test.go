@@ -0,0 +1,125 @@
+package postgres
+
+import (
+	"testing"
+
+	"github.com/1CL0UD/cloudm-cli/internal/config"
+)
+
+func TestDefaultIgnoreRules(t *testing.T) {
+	rules, err := CompileIgnoreRules(nil)
+	if err != nil {
+		t.Fatalf("CompileIgnoreRules failed: %v", err)
+	}
+
+	tests := []struct {
+		d    Diagnostic
+		want bool
+	}{
+		{Diagnostic{Message: `schema "public" already exists`, Object: "SCHEMA public"}, true},
+		{Diagnostic{Message: `schema "app" already exists`, Object: "SCHEMA app"}, false},
+		{Diagnostic{Message: `extension "pgcrypto" already exists`, Object: "EXTENSION pgcrypto"}, true},
+		{Diagnostic{Message: `must be owner of extension plpgsql`, Object: "COMMENT EXTENSION plpgsql"}, true},
+		{Diagnostic{Message: `must be owner of extension plpgsql`, Object: "EXTENSION plpgsql"}, false},
+		{Diagnostic{Message: `must be owner of table orders`, Object: "COMMENT public.orders"}, false},
+		{Diagnostic{Message: `relation "orders" already exists`, Object: "TABLE public.orders"}, false},
+	}
+
+	for _, tt := range tests {
+		got := false
+		for _, r := range rules {
+			if r.Matches(tt.d) {
+				got = true
+				break
+			}
+		}
+		if got != tt.want {
+			t.Errorf("default rules match %q on %q = %t, want %t", tt.d.Message, tt.d.Object, got, tt.want)
+		}
+	}
+}
+
+func TestIgnoreRuleMatches(t *testing.T) {
+	d := Diagnostic{
+		Severity: SeverityError,
+		Category: "undefined_role",
+		SQLState: "42704",
+		Object:   "ACL public.orders",
+		Message:  `role "reporting" does not exist`,
+	}
+
+	tests := []struct {
+		rule config.RestoreIgnoreRule
+		want bool
+	}{
+		{config.RestoreIgnoreRule{}, true},
+		{config.RestoreIgnoreRule{Message: `^role "[^"]+" does not exist$`}, true},
+		{config.RestoreIgnoreRule{Message: `^role "admin"`}, false},
+		{config.RestoreIgnoreRule{Category: "undefined_role"}, true},
+		{config.RestoreIgnoreRule{Category: "42704"}, true},
+		{config.RestoreIgnoreRule{Category: "undefined_table"}, false},
+		{config.RestoreIgnoreRule{Object: "ACL *"}, true},
+		{config.RestoreIgnoreRule{Object: "ACL public.*"}, true},
+		{config.RestoreIgnoreRule{Object: "TABLE *"}, false},
+		{config.RestoreIgnoreRule{Object: "ACL ["}, false},
+		{config.RestoreIgnoreRule{Category: "undefined_role", Object: "COMMENT *"}, false},
+		{config.RestoreIgnoreRule{Message: "does not exist", Category: "42704", Object: "ACL *"}, true},
+	}
+
+	for _, tt := range tests {
+		rules, err := CompileIgnoreRules([]config.RestoreIgnoreRule{tt.rule})
+		if err != nil {
+			t.Errorf("CompileIgnoreRules(%+v) failed: %v", tt.rule, err)
+			continue
+		}
+		rule := rules[len(rules)-1]
+		if got := rule.Matches(d); got != tt.want {
+			t.Errorf("rule %+v matches = %t, want %t", tt.rule, got, tt.want)
+		}
+	}
+}
+
+func TestCompileIgnoreRules(t *testing.T) {
+	rules, err := CompileIgnoreRules([]config.RestoreIgnoreRule{{Category: "undefined_role"}})
+	if err != nil {
+		t.Fatalf("CompileIgnoreRules failed: %v", err)
+	}
+	if len(rules) != len(DefaultIgnoreRules)+1 {
+		t.Fatalf("CompileIgnoreRules returned %d rules, want %d", len(rules), len(DefaultIgnoreRules)+1)
+	}
+	if reason := rules[len(rules)-1].Reason; reason != "ignored by configuration" {
+		t.Errorf("configured rule without reason has reason %q", reason)
+	}
+
+	if _, err := CompileIgnoreRules([]config.RestoreIgnoreRule{{Message: "("}}); err == nil {
+		t.Error("CompileIgnoreRules accepted an invalid regular expression")
+	}
+}
+
+func TestDiagnosticLogAdd(t *testing.T) {
+	rules, err := CompileIgnoreRules(nil)
+	if err != nil {
+		t.Fatalf("CompileIgnoreRules failed: %v", err)
+	}
+	log := NewDiagnosticLog(rules)
+
+	output := `pg_restore: while PROCESSING TOC:
+pg_restore: from TOC entry 5; 2615 2200 SCHEMA - public pg_database_owner
+pg_restore: error: could not execute query: ERROR:  schema "public" already exists
+Command was: CREATE SCHEMA public;
+pg_restore: from TOC entry 215; 1259 16386 TABLE public orders app_owner
+pg_restore: error: could not execute query: ERROR:  relation "orders" already exists
+Command was: CREATE TABLE public.orders (
+    id integer
+);
+pg_restore: warning: errors ignored on restore: 2
+`
+
+	failures := log.Add(ParseRestoreOutput(output))
+	if len(failures) != 1 || failures[0].Object != "TABLE public.orders" || failures[0].Category != "duplicate_table" {
+		t.Fatalf("failures = %+v, want the duplicate table only", failures)
+	}
+	if s := log.Summary(); s.Errors != 1 || s.Ignored != 1 {
+		t.Errorf("summary = %+v, want 1 error and 1 ignored", s)
+	}
+}
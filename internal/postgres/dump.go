@@ -5,6 +5,8 @@ import (
 	"os"
 	"os/exec"
 	"strings"
+
+	"github.com/1CL0UD/cloudm-cli/internal/encryption"
 )
 
 type DumpOptions struct {
@@ -18,24 +20,26 @@ type DumpOptions struct {
 	StructureOnly bool
 	DataOnly      bool
 	ExcludeTables []string
+	LargeObjects  bool
+	Encryptor     *encryption.Encryptor
 }
 
 // DumpStructure dumps database structure (schema only)
 func DumpStructure(opts DumpOptions) error {
 	args := buildDumpArgs(opts, true, false)
-	return runPgDump(args, opts.Password)
+	return runPgDump(args, opts.Password, opts.OutputFile, opts.Encryptor)
 }
 
 // DumpData dumps database data only
 func DumpData(opts DumpOptions) error {
 	args := buildDumpArgs(opts, false, true)
-	return runPgDump(args, opts.Password)
+	return runPgDump(args, opts.Password, opts.OutputFile, opts.Encryptor)
 }
 
 // DumpFull dumps both structure and data
 func DumpFull(opts DumpOptions) error {
 	args := buildDumpArgs(opts, false, false)
-	return runPgDump(args, opts.Password)
+	return runPgDump(args, opts.Password, opts.OutputFile, opts.Encryptor)
 }
 
 // buildDumpArgs builds pg_dump command arguments
@@ -62,6 +66,11 @@ func buildDumpArgs(opts DumpOptions, structureOnly, dataOnly bool) []string {
 		args = append(args, "-a")
 	}
 
+	// Large objects are skipped by -n, so request them explicitly
+	if opts.LargeObjects && !structureOnly {
+		args = append(args, "-b")
+	}
+
 	// Exclude tables
 	for _, table := range opts.ExcludeTables {
 		args = append(args, "--exclude-table="+table)
@@ -70,25 +79,61 @@ func buildDumpArgs(opts DumpOptions, structureOnly, dataOnly bool) []string {
 	// Output format: custom (binary, compressed)
 	args = append(args, "-Fc")
 
-	// Output file
-	args = append(args, "-f", opts.OutputFile)
+	// Output file; encrypted dumps are streamed through stdout instead
+	if opts.Encryptor == nil {
+		args = append(args, "-f", opts.OutputFile)
+	}
 
 	return args
 }
 
 // runPgDump executes pg_dump with the given arguments
-func runPgDump(args []string, password string) error {
+func runPgDump(args []string, password, outputFile string, enc *encryption.Encryptor) error {
+	if err := runDumpCommand(args, password, outputFile, enc); err != nil {
+		return fmt.Errorf("pg_dump failed: %w", err)
+	}
+	return nil
+}
+
+// runDumpCommand runs pg_dump. Without an encryptor pg_dump writes the output
+// file itself; with one its stdout is encrypted into the output file so that
+// no plaintext ever reaches the disk.
+func runDumpCommand(args []string, password, outputFile string, enc *encryption.Encryptor) error {
 	cmd := exec.Command("pg_dump", args...)
 	cmd.Env = append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", password))
 
 	var stderr strings.Builder
 	cmd.Stderr = &stderr
 
+	if enc == nil {
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("%w\nstderr: %s", err, stderr.String())
+		}
+		return nil
+	}
+
+	out, err := os.OpenFile(outputFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", outputFile, err)
+	}
+	defer out.Close()
+
+	w, err := enc.Encrypt(out)
+	if err != nil {
+		return err
+	}
+	cmd.Stdout = w
+
 	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("pg_dump failed: %w\nstderr: %s", err, stderr.String())
+		out.Close()
+		os.Remove(outputFile)
+		return fmt.Errorf("%w\nstderr: %s", err, stderr.String())
 	}
 
-	return nil
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to encrypt %s: %w", outputFile, err)
+	}
+	return out.Close()
 }
 
 // GetDumpInfo returns information about a dump file
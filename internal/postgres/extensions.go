@@ -0,0 +1,221 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/1CL0UD/cloudm-cli/internal/config"
+	"github.com/jackc/pgx/v5"
+)
+
+// Extension is an extension installed in, or to be created in, a database.
+// An empty schema or version leaves the choice to CREATE EXTENSION.
+type Extension struct {
+	Name    string
+	Schema  string
+	Version string
+}
+
+// ExtensionCheck compares an extension with what the target server offers
+type ExtensionCheck struct {
+	Extension
+	Available        bool
+	VersionAvailable bool
+	DefaultVersion   string
+	InstalledVersion string
+	// Restricted tells why the admin user may not create the extension
+	Restricted string
+}
+
+// VersionMismatch reports whether the wanted version cannot be created on
+// the target, which then falls back to its default version
+func (c ExtensionCheck) VersionMismatch() bool {
+	return c.Available && c.Version != "" && !c.VersionAvailable
+}
+
+// GetExtensions lists the extensions installed in a source database in
+// creation order, so that extensions come after those they require.
+// plpgsql is part of every database and left out.
+func GetExtensions(ctx context.Context, cfg config.DatabaseConfig) ([]Extension, error) {
+	connStr := GetConnectionString(cfg)
+	conn, err := pgx.Connect(ctx, connStr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	rows, err := conn.Query(ctx, `
+		SELECT e.extname, n.nspname, e.extversion
+		FROM pg_extension e
+		JOIN pg_namespace n ON n.oid = e.extnamespace
+		WHERE e.extname <> 'plpgsql'
+		ORDER BY e.oid`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list extensions: %w", err)
+	}
+
+	extensions, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (Extension, error) {
+		var e Extension
+		err := row.Scan(&e.Name, &e.Schema, &e.Version)
+		return e, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list extensions: %w", err)
+	}
+
+	return extensions, nil
+}
+
+// MergeExtensions applies the configured extension entries to those found
+// on the source. Entries override the schema and version of a source
+// extension, remove it when skipped, and add extensions the source lacks.
+func MergeExtensions(source []Extension, entries []config.ExtensionConfig) []Extension {
+	byName := make(map[string]config.ExtensionConfig, len(entries))
+	for _, entry := range entries {
+		byName[entry.Name] = entry
+	}
+
+	var result []Extension
+	seen := make(map[string]bool)
+	for _, ext := range source {
+		seen[ext.Name] = true
+		entry, ok := byName[ext.Name]
+		if !ok {
+			result = append(result, ext)
+			continue
+		}
+		if entry.Skip {
+			continue
+		}
+		if entry.Schema != "" {
+			ext.Schema = entry.Schema
+		}
+		if entry.Version != "" {
+			ext.Version = entry.Version
+		}
+		result = append(result, ext)
+	}
+
+	for _, entry := range entries {
+		if seen[entry.Name] || entry.Skip {
+			continue
+		}
+		seen[entry.Name] = true
+		result = append(result, Extension{Name: entry.Name, Schema: entry.Schema, Version: entry.Version})
+	}
+
+	return result
+}
+
+// CheckExtensions looks up each extension in the extensions available on
+// the target server. When the admin user is not a superuser, extensions it
+// may not create are marked restricted: those missing from the allow-list
+// of a managed service, or without one, those that aren't trusted.
+func CheckExtensions(ctx context.Context, cfg config.TargetConfig, extensions []Extension) ([]ExtensionCheck, error) {
+	connStr := GetTargetConnectionString(cfg)
+	conn, err := pgx.Connect(ctx, connStr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	var superuser bool
+	if err := conn.QueryRow(ctx, "SELECT rolsuper FROM pg_roles WHERE rolname = current_user").Scan(&superuser); err != nil {
+		return nil, fmt.Errorf("failed to read privileges of admin user: %w", err)
+	}
+	var allowParam string
+	var allowed []string
+	if !superuser {
+		if allowParam, allowed, err = extensionAllowList(ctx, conn); err != nil {
+			return nil, err
+		}
+	}
+
+	checks := make([]ExtensionCheck, 0, len(extensions))
+	for _, ext := range extensions {
+		check := ExtensionCheck{Extension: ext}
+		var installed *string
+		err := conn.QueryRow(ctx, `
+			SELECT a.default_version, a.installed_version,
+				EXISTS (SELECT 1 FROM pg_available_extension_versions v WHERE v.name = a.name AND v.version = $2)
+			FROM pg_available_extensions a
+			WHERE a.name = $1`, ext.Name, ext.Version).Scan(&check.DefaultVersion, &installed, &check.VersionAvailable)
+		if errors.Is(err, pgx.ErrNoRows) {
+			checks = append(checks, check)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to check extension %s: %w", ext.Name, err)
+		}
+
+		check.Available = true
+		if installed != nil {
+			check.InstalledVersion = *installed
+		}
+		if !superuser && installed == nil {
+			if check.Restricted, err = extensionRestriction(ctx, conn, ext, allowParam, allowed); err != nil {
+				return nil, err
+			}
+		}
+		checks = append(checks, check)
+	}
+
+	return checks, nil
+}
+
+// extensionRestriction tells why a non-superuser may not create an
+// extension, or returns an empty string when it may
+func extensionRestriction(ctx context.Context, conn *pgx.Conn, ext Extension, allowParam string, allowed []string) (string, error) {
+	if allowParam != "" {
+		if containsString(allowed, "*") || containsString(allowed, ext.Name) {
+			return "", nil
+		}
+		return "not in " + allowParam, nil
+	}
+
+	var untrusted bool
+	err := conn.QueryRow(ctx, `
+		SELECT coalesce(bool_and(v.superuser AND NOT v.trusted), false)
+		FROM pg_available_extension_versions v
+		JOIN pg_available_extensions a ON a.name = v.name
+		WHERE v.name = $1 AND v.version = coalesce(nullif($2, ''), a.default_version)`, ext.Name, ext.Version).Scan(&untrusted)
+	if err != nil {
+		return "", fmt.Errorf("failed to check extension %s: %w", ext.Name, err)
+	}
+	if untrusted {
+		return "requires a superuser and is not trusted", nil
+	}
+	return "", nil
+}
+
+// CreateExtensions creates extensions in their schema and version, creating
+// the schema first when needed. Extensions that already exist are kept.
+func CreateExtensions(ctx context.Context, cfg config.TargetConfig, extensions []Extension) error {
+	connStr := GetTargetConnectionString(cfg)
+	conn, err := pgx.Connect(ctx, connStr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	for _, ext := range extensions {
+		stmt := "CREATE EXTENSION IF NOT EXISTS " + quoteIdent(ext.Name)
+		if ext.Schema != "" {
+			schema := quoteIdent(ext.Schema)
+			if _, err := conn.Exec(ctx, "CREATE SCHEMA IF NOT EXISTS "+schema); err != nil {
+				return fmt.Errorf("failed to create schema %s for extension %s: %w", ext.Schema, ext.Name, err)
+			}
+			stmt += " WITH SCHEMA " + schema
+		}
+		if ext.Version != "" {
+			stmt += " VERSION " + quoteLiteral(ext.Version)
+		}
+
+		if _, err := conn.Exec(ctx, stmt); err != nil {
+			return fmt.Errorf("failed to create extension %s: %w", ext.Name, err)
+		}
+	}
+
+	return nil
+}
\ No newline at end of file
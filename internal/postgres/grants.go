@@ -0,0 +1,202 @@
+package postgres
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/1CL0UD/cloudm-cli/internal/config"
+)
+
+// PrivilegeSet holds the privileges granted on each kind of object
+type PrivilegeSet struct {
+	Database  []string
+	Schema    []string
+	Tables    []string
+	Sequences []string
+	Functions []string
+}
+
+// GrantRule is a grants entry with its profile expanded into privileges
+type GrantRule struct {
+	Role       string
+	Owner      bool
+	Schemas    []string
+	Privileges PrivilegeSet
+}
+
+// grantProfiles are the privileges of each named profile
+var grantProfiles = map[string]PrivilegeSet{
+	"owner": {
+		Database:  []string{"CONNECT", "CREATE", "TEMPORARY"},
+		Schema:    []string{"USAGE", "CREATE"},
+		Tables:    []string{"ALL"},
+		Sequences: []string{"ALL"},
+		Functions: []string{"ALL"},
+	},
+	"readwrite": {
+		Database:  []string{"CONNECT", "TEMPORARY"},
+		Schema:    []string{"USAGE"},
+		Tables:    []string{"SELECT", "INSERT", "UPDATE", "DELETE"},
+		Sequences: []string{"USAGE", "SELECT", "UPDATE"},
+		Functions: []string{"EXECUTE"},
+	},
+	"readonly": {
+		Database:  []string{"CONNECT"},
+		Schema:    []string{"USAGE"},
+		Tables:    []string{"SELECT"},
+		Sequences: []string{"SELECT"},
+	},
+	"custom": {},
+}
+
+// validPrivileges are the privileges that may be listed for each kind of
+// object
+var validPrivileges = map[string][]string{
+	"database":  {"ALL", "CONNECT", "CREATE", "TEMPORARY", "TEMP"},
+	"schema":    {"ALL", "USAGE", "CREATE"},
+	"tables":    {"ALL", "SELECT", "INSERT", "UPDATE", "DELETE", "TRUNCATE", "REFERENCES", "TRIGGER"},
+	"sequences": {"ALL", "USAGE", "SELECT", "UPDATE"},
+	"functions": {"ALL", "EXECUTE"},
+}
+
+// CompileGrants expands the profiles of the grants entries and checks
+// their privileges
+func CompileGrants(entries []config.GrantConfig) ([]GrantRule, error) {
+	rules := make([]GrantRule, 0, len(entries))
+	for i, e := range entries {
+		profile := strings.ToLower(e.Profile)
+		if profile == "" {
+			profile = "custom"
+		}
+		base, ok := grantProfiles[profile]
+		if !ok {
+			return nil, fmt.Errorf("grants[%d]: unknown profile %q", i, e.Profile)
+		}
+
+		var set PrivilegeSet
+		var err error
+		lists := []struct {
+			kind   string
+			base   []string
+			extra  []string
+			target *[]string
+		}{
+			{"database", base.Database, e.Privileges.Database, &set.Database},
+			{"schema", base.Schema, e.Privileges.Schema, &set.Schema},
+			{"tables", base.Tables, e.Privileges.Tables, &set.Tables},
+			{"sequences", base.Sequences, e.Privileges.Sequences, &set.Sequences},
+			{"functions", base.Functions, e.Privileges.Functions, &set.Functions},
+		}
+		for _, l := range lists {
+			if *l.target, err = mergePrivileges(l.kind, l.base, l.extra); err != nil {
+				return nil, fmt.Errorf("grants[%d]: %w", i, err)
+			}
+		}
+
+		schemas := e.Schemas
+		if len(schemas) == 0 {
+			schemas = []string{"public"}
+		}
+		rules = append(rules, GrantRule{Role: e.Role, Owner: profile == "owner", Schemas: schemas, Privileges: set})
+	}
+	return rules, nil
+}
+
+// mergePrivileges adds the given privileges to those of a profile, checking
+// that they apply to the kind of object
+func mergePrivileges(kind string, base, extra []string) ([]string, error) {
+	merged := append([]string(nil), base...)
+	for _, p := range extra {
+		p = strings.ToUpper(strings.TrimSpace(p))
+		if !containsString(validPrivileges[kind], p) {
+			return nil, fmt.Errorf("invalid %s privilege %q", kind, p)
+		}
+		if !containsString(merged, p) {
+			merged = append(merged, p)
+		}
+	}
+	return merged, nil
+}
+
+// grantRuleStatements builds the statements applying grants rules
+func grantRuleStatements(db string, rules []GrantRule, defaultOwner string) []string {
+	var stmts []string
+	for _, r := range rules {
+		role := quoteIdent(r.Role)
+		p := r.Privileges
+		if len(p.Database) > 0 {
+			stmts = append(stmts, fmt.Sprintf("GRANT %s ON DATABASE %s TO %s", privilegeList(p.Database), quoteIdent(db), role))
+		}
+
+		for _, schema := range r.Schemas {
+			qs := quoteIdent(schema)
+			if len(p.Schema) > 0 {
+				stmts = append(stmts, fmt.Sprintf("GRANT %s ON SCHEMA %s TO %s", privilegeList(p.Schema), qs, role))
+			}
+			objects := []struct {
+				kind       string
+				privileges []string
+			}{
+				{"TABLES", p.Tables},
+				{"SEQUENCES", p.Sequences},
+				{"FUNCTIONS", p.Functions},
+			}
+			for _, o := range objects {
+				if len(o.privileges) > 0 {
+					stmts = append(stmts, fmt.Sprintf("GRANT %s ON ALL %s IN SCHEMA %s TO %s", privilegeList(o.privileges), o.kind, qs, role))
+				}
+			}
+
+			for _, owner := range defaultCreators(rules, schema, defaultOwner) {
+				if owner == r.Role {
+					continue
+				}
+				for _, o := range objects {
+					if len(o.privileges) > 0 {
+						stmts = append(stmts, fmt.Sprintf("ALTER DEFAULT PRIVILEGES FOR ROLE %s IN SCHEMA %s GRANT %s ON %s TO %s",
+							quoteIdent(owner), qs, privilegeList(o.privileges), o.kind, role))
+					}
+				}
+			}
+		}
+	}
+	return stmts
+}
+
+// defaultCreators returns the roles whose future objects in a schema get
+// default privileges: the owner rules of the schema, or defaultOwner when
+// there are none
+func defaultCreators(rules []GrantRule, schema, defaultOwner string) []string {
+	var creators []string
+	for _, r := range rules {
+		if r.Owner && containsString(r.Schemas, schema) {
+			creators = append(creators, r.Role)
+		}
+	}
+	if len(creators) == 0 {
+		creators = []string{defaultOwner}
+	}
+	return creators
+}
+
+// privilegeList joins privileges for a GRANT statement, sorted so that the
+// statements are stable
+func privilegeList(privileges []string) string {
+	if containsString(privileges, "ALL") {
+		return "ALL"
+	}
+	sorted := append([]string(nil), privileges...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ", ")
+}
+
+// containsString reports whether list holds s
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
\ No newline at end of file
@@ -0,0 +1,198 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/1CL0UD/cloudm-cli/internal/config"
+	"github.com/1CL0UD/cloudm-cli/internal/encryption"
+	"github.com/jackc/pgx/v5"
+)
+
+type LargeObjectStats struct {
+	Count      int64
+	TotalBytes int64
+}
+
+// GetLargeObjectStats retrieves large object statistics from a source database
+func GetLargeObjectStats(ctx context.Context, cfg config.DatabaseConfig) (LargeObjectStats, error) {
+	connStr := GetConnectionString(cfg)
+	conn, err := pgx.Connect(ctx, connStr)
+	if err != nil {
+		return LargeObjectStats{}, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	return getLargeObjectStatsFromConn(ctx, conn)
+}
+
+// GetTargetLargeObjectStats retrieves large object statistics from a target database
+func GetTargetLargeObjectStats(ctx context.Context, cfg config.TargetConfig) (LargeObjectStats, error) {
+	connStr := GetTargetConnectionString(cfg)
+	conn, err := pgx.Connect(ctx, connStr)
+	if err != nil {
+		return LargeObjectStats{}, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	return getLargeObjectStatsFromConn(ctx, conn)
+}
+
+// getLargeObjectStatsFromConn counts large objects and sums their stored size
+func getLargeObjectStatsFromConn(ctx context.Context, conn *pgx.Conn) (LargeObjectStats, error) {
+	var s LargeObjectStats
+	if err := conn.QueryRow(ctx, "SELECT count(*) FROM pg_largeobject_metadata").Scan(&s.Count); err != nil {
+		return s, fmt.Errorf("failed to count large objects: %w", err)
+	}
+
+	if s.Count == 0 {
+		return s, nil
+	}
+
+	// pg_largeobject is only readable by superusers, so fall back to lo_get
+	// which works for any role that can read the objects themselves
+	err := conn.QueryRow(ctx,
+		"SELECT COALESCE(sum(octet_length(data)), 0) FROM pg_largeobject").Scan(&s.TotalBytes)
+	if err != nil {
+		err = conn.QueryRow(ctx,
+			"SELECT COALESCE(sum(octet_length(lo_get(oid))), 0) FROM pg_largeobject_metadata").Scan(&s.TotalBytes)
+		if err != nil {
+			return s, fmt.Errorf("failed to measure large object size: %w", err)
+		}
+	}
+
+	return s, nil
+}
+
+// CompareLargeObjectStats compares source and target large object statistics
+func CompareLargeObjectStats(source, target LargeObjectStats) (report string, hasDiscrepancy bool) {
+	var sb strings.Builder
+
+	sb.WriteString("Large Object Comparison:\n")
+	sb.WriteString(fmt.Sprintf("%-40s %15s %15s %10s\n", "Metric", "Source", "Target", "Status"))
+	sb.WriteString(strings.Repeat("-", 85) + "\n")
+
+	countStatus := "OK"
+	if source.Count != target.Count {
+		countStatus = "MISMATCH"
+		hasDiscrepancy = true
+	}
+	sb.WriteString(fmt.Sprintf("%-40s %15d %15d %10s\n", "Count", source.Count, target.Count, countStatus))
+
+	sizeStatus := "OK"
+	if source.TotalBytes != target.TotalBytes {
+		sizeStatus = "MISMATCH"
+		hasDiscrepancy = true
+	}
+	sb.WriteString(fmt.Sprintf("%-40s %15d %15d %10s\n", "Total bytes", source.TotalBytes, target.TotalBytes, sizeStatus))
+
+	return sb.String(), hasDiscrepancy
+}
+
+// DumpHasLargeObjects reports whether a dump archive contains large objects
+func DumpHasLargeObjects(dumpFile string, dec *encryption.Decryptor) (bool, error) {
+	entries, err := ReadTOC(dumpFile, dec)
+	if err != nil {
+		return false, err
+	}
+
+	for _, e := range entries {
+		if largeObjectTypes[e.Type] {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// largeObjectBatch is how many large objects change owner per transaction
+// when changes are batched. Each change locks its object, so changing them
+// all at once can exhaust the lock table.
+const largeObjectBatch = 1000
+
+// LargeObjectOwner is a role owning large objects and how many it owns
+type LargeObjectOwner struct {
+	Owner string `json:"owner"`
+	Count int64  `json:"count"`
+}
+
+// LargeObjectChange gives the large objects of a role to another one
+type LargeObjectChange struct {
+	From  string
+	To    string
+	Count int64
+}
+
+// String describes the change
+func (c LargeObjectChange) String() string {
+	return fmt.Sprintf("%d large objects from %s to %s", c.Count, c.From, c.To)
+}
+
+// SQL returns the block making the change within the current transaction
+func (c LargeObjectChange) SQL() string {
+	return c.block("")
+}
+
+// BatchedSQL returns the block making the change, committing after every
+// largeObjectBatch objects. It must run outside a transaction block.
+func (c LargeObjectChange) BatchedSQL() string {
+	return c.block(fmt.Sprintf(`
+		n := n + 1;
+		IF n %% %d = 0 THEN
+			COMMIT;
+		END IF;`, largeObjectBatch))
+}
+
+// block returns the DO block changing the owner of each large object, with
+// step run after each change
+func (c LargeObjectChange) block(step string) string {
+	body := fmt.Sprintf(`DECLARE
+	lo oid;
+	n int := 0;
+BEGIN
+	FOR lo IN
+		SELECT l.oid FROM pg_largeobject_metadata l JOIN pg_roles r ON r.oid = l.lomowner
+		WHERE r.rolname = %s
+	LOOP
+		EXECUTE format('ALTER LARGE OBJECT %%s OWNER TO %%I', lo, %s);%s
+	END LOOP;
+END`, quoteLiteral(c.From), quoteLiteral(c.To), step)
+
+	if strings.Contains(body, "$lo$") {
+		return "DO " + quoteLiteral(body)
+	}
+	return "DO $lo$\n" + body + "\n$lo$"
+}
+
+// listLargeObjectOwners returns the roles owning large objects in the
+// database of a connection
+func listLargeObjectOwners(ctx context.Context, conn *pgx.Conn) ([]LargeObjectOwner, error) {
+	rows, err := conn.Query(ctx, `
+		SELECT pg_get_userbyid(lomowner), count(*)
+		FROM pg_largeobject_metadata
+		GROUP BY lomowner
+		ORDER BY 1`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list large object owners: %w", err)
+	}
+	owners, err := pgx.CollectRows(rows, pgx.RowToStructByPos[LargeObjectOwner])
+	if err != nil {
+		return nil, fmt.Errorf("failed to list large object owners: %w", err)
+	}
+	return owners, nil
+}
+
+// sameLargeObjectOwners reports whether two lists of large object owners,
+// as returned by listLargeObjectOwners, are equal
+func sameLargeObjectOwners(a, b []LargeObjectOwner) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
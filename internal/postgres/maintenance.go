@@ -0,0 +1,219 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/1CL0UD/cloudm-cli/internal/config"
+	"github.com/jackc/pgx/v5"
+)
+
+// SequenceReset describes a sequence moved up to the values of its column
+type SequenceReset struct {
+	Sequence string
+	Column   string
+	From     int64
+	To       int64
+}
+
+// AnalyzeDatabase collects planner statistics for the whole database,
+// vacuuming it first when requested
+func AnalyzeDatabase(ctx context.Context, cfg config.TargetConfig, vacuum bool) error {
+	connStr := GetTargetConnectionString(cfg)
+	conn, err := pgx.Connect(ctx, connStr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	stmt := "ANALYZE"
+	if vacuum {
+		stmt = "VACUUM (ANALYZE)"
+	}
+	if _, err := conn.Exec(ctx, stmt); err != nil {
+		return fmt.Errorf("failed to run %s: %w", stmt, err)
+	}
+
+	return nil
+}
+
+// RefreshMaterializedViews refreshes the materialized views of a schema,
+// each after the materialized views it reads from, directly or through
+// views. It returns the refreshed views in order.
+func RefreshMaterializedViews(ctx context.Context, cfg config.TargetConfig, schema string) ([]string, error) {
+	connStr := GetTargetConnectionString(cfg)
+	conn, err := pgx.Connect(ctx, connStr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	rows, err := conn.Query(ctx, `
+		SELECT c.oid, c.relkind::text, quote_ident(n.nspname) || '.' || quote_ident(c.relname), n.nspname = $1
+		FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE c.relkind IN ('m', 'v')
+		  AND n.nspname NOT IN ('pg_catalog', 'information_schema')`, schema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list materialized views: %w", err)
+	}
+
+	type relation struct {
+		kind     string
+		name     string
+		inSchema bool
+	}
+	relations := make(map[uint32]relation)
+	var oid uint32
+	var rel relation
+	_, err = pgx.ForEachRow(rows, []any{&oid, &rel.kind, &rel.name, &rel.inSchema}, func() error {
+		relations[oid] = rel
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list materialized views: %w", err)
+	}
+
+	// Relations each view or materialized view reads from
+	rows, err = conn.Query(ctx, `
+		SELECT DISTINCT r.ev_class, d.refobjid
+		FROM pg_rewrite r
+		JOIN pg_depend d ON d.classid = 'pg_rewrite'::regclass AND d.objid = r.oid
+		WHERE d.refclassid = 'pg_class'::regclass AND d.refobjid <> r.ev_class`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read view dependencies: %w", err)
+	}
+	deps := make(map[uint32][]uint32)
+	var from, to uint32
+	_, err = pgx.ForEachRow(rows, []any{&from, &to}, func() error {
+		if _, ok := relations[to]; ok {
+			deps[from] = append(deps[from], to)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read view dependencies: %w", err)
+	}
+
+	// Depth-first order, visiting materialized views in name order so that
+	// the result is stable
+	var oids []uint32
+	for oid, rel := range relations {
+		if rel.kind == "m" && rel.inSchema {
+			oids = append(oids, oid)
+		}
+	}
+	sort.Slice(oids, func(i, j int) bool { return relations[oids[i]].name < relations[oids[j]].name })
+
+	var order []string
+	visited := make(map[uint32]bool)
+	var visit func(uint32)
+	visit = func(oid uint32) {
+		if visited[oid] {
+			return
+		}
+		visited[oid] = true
+		for _, dep := range deps[oid] {
+			visit(dep)
+		}
+		if rel := relations[oid]; rel.kind == "m" && rel.inSchema {
+			order = append(order, rel.name)
+		}
+	}
+	for _, oid := range oids {
+		visit(oid)
+	}
+
+	for i, name := range order {
+		if _, err := conn.Exec(ctx, "REFRESH MATERIALIZED VIEW "+name); err != nil {
+			return order[:i], fmt.Errorf("failed to refresh %s: %w", name, err)
+		}
+	}
+
+	return order, nil
+}
+
+// ResetSequences moves the sequences owned by columns of a schema past the
+// values already in those columns. Sequences already ahead are left alone.
+func ResetSequences(ctx context.Context, cfg config.TargetConfig, schema string) ([]SequenceReset, error) {
+	connStr := GetTargetConnectionString(cfg)
+	conn, err := pgx.Connect(ctx, connStr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	type ownedSequence struct {
+		sequence string
+		table    string
+		column   string
+		last     *int64
+		forward  bool
+	}
+
+	// Serial and identity columns own their sequence
+	rows, err := conn.Query(ctx, `
+		SELECT
+			quote_ident(sn.nspname) || '.' || quote_ident(s.relname),
+			quote_ident(tn.nspname) || '.' || quote_ident(t.relname),
+			quote_ident(a.attname),
+			ps.last_value,
+			ps.increment_by > 0
+		FROM pg_class s
+		JOIN pg_namespace sn ON sn.oid = s.relnamespace
+		JOIN pg_depend d ON d.classid = 'pg_class'::regclass AND d.objid = s.oid
+			AND d.refclassid = 'pg_class'::regclass AND d.deptype IN ('a', 'i')
+		JOIN pg_class t ON t.oid = d.refobjid
+		JOIN pg_namespace tn ON tn.oid = t.relnamespace
+		JOIN pg_attribute a ON a.attrelid = t.oid AND a.attnum = d.refobjsubid
+		JOIN pg_sequences ps ON ps.schemaname = sn.nspname AND ps.sequencename = s.relname
+		WHERE s.relkind = 'S' AND tn.nspname = $1
+		ORDER BY 1`, schema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sequences: %w", err)
+	}
+	var sequences []ownedSequence
+	var seq ownedSequence
+	_, err = pgx.ForEachRow(rows, []any{&seq.sequence, &seq.table, &seq.column, &seq.last, &seq.forward}, func() error {
+		sequences = append(sequences, seq)
+		// Let the next row scan into a fresh value
+		seq.last = nil
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sequences: %w", err)
+	}
+
+	var resets []SequenceReset
+	for _, s := range sequences {
+		aggregate := "max"
+		if !s.forward {
+			aggregate = "min"
+		}
+
+		var bound *int64
+		query := fmt.Sprintf("SELECT %s(%s)::bigint FROM %s", aggregate, s.column, s.table)
+		if err := conn.QueryRow(ctx, query).Scan(&bound); err != nil {
+			return resets, fmt.Errorf("failed to read %s of %s.%s: %w", aggregate, s.table, s.column, err)
+		}
+		if bound == nil {
+			continue
+		}
+		if s.last != nil && ((s.forward && *s.last >= *bound) || (!s.forward && *s.last <= *bound)) {
+			continue
+		}
+
+		if _, err := conn.Exec(ctx, "SELECT setval($1::regclass, $2, true)", s.sequence, *bound); err != nil {
+			return resets, fmt.Errorf("failed to reset %s: %w", s.sequence, err)
+		}
+
+		reset := SequenceReset{Sequence: s.sequence, Column: s.table + "." + s.column, To: *bound}
+		if s.last != nil {
+			reset.From = *s.last
+		}
+		resets = append(resets, reset)
+	}
+
+	return resets, nil
+}
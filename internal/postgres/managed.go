@@ -0,0 +1,223 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// allowListParameters are the settings through which managed services
+// restrict the extensions their admin user may create
+var allowListParameters = []string{
+	"rds.allowed_extensions",
+	"azure.extensions",
+	"extwlist.extensions",
+}
+
+// AdminAccess describes what the admin user may do on the target. On
+// managed services it is not a superuser, so it can only give objects to a
+// role, or act on objects of a role, while it is a member of that role.
+type AdminAccess struct {
+	User          string
+	Superuser     bool
+	CreateRole    bool
+	CreateDB      bool
+	ServerVersion int
+	DatabaseOwner string
+	// Roles holds the access of the admin user to each role
+	Roles map[string]RoleAccess
+	// OwnsOutside counts objects owned by the admin user that ownership
+	// doesn't cover: other databases, tablespaces, extensions, and objects
+	// of other schemas. REASSIGN OWNED can only be used when there are none.
+	OwnsOutside int
+}
+
+// RoleAccess is the access of the admin user to a role
+type RoleAccess struct {
+	Superuser bool
+	// Member is set when the admin user may act as the role
+	Member bool
+	// Admin is set when the admin user may grant membership in the role
+	Admin bool
+}
+
+// otherSchema matches the schemas n other than the given ones and those of
+// the system
+const otherSchema = `n.nspname <> ALL($1) AND n.nspname NOT LIKE 'pg\_%' AND n.nspname <> 'information_schema'`
+
+// readAdminAccess reads the access of the connected user to the roles of
+// the server, counting the objects it owns outside the given schemas
+func readAdminAccess(ctx context.Context, conn *pgx.Conn, schemas []string) (AdminAccess, error) {
+	var a AdminAccess
+	err := conn.QueryRow(ctx, `
+		SELECT r.rolname, r.rolsuper, r.rolcreaterole, r.rolcreatedb,
+			current_setting('server_version_num')::int,
+			(SELECT pg_get_userbyid(datdba) FROM pg_database WHERE datname = current_database())
+		FROM pg_roles r
+		WHERE r.rolname = current_user`).Scan(&a.User, &a.Superuser, &a.CreateRole, &a.CreateDB, &a.ServerVersion, &a.DatabaseOwner)
+	if err != nil {
+		return a, fmt.Errorf("failed to read privileges of admin user: %w", err)
+	}
+	if a.Superuser {
+		return a, nil
+	}
+
+	// Acting as a role takes the SET option of the membership from
+	// PostgreSQL 16 on, and plain membership before
+	privilege := "MEMBER"
+	if a.ServerVersion >= 160000 {
+		privilege = "SET"
+	}
+	rows, err := conn.Query(ctx, `
+		SELECT rolname, rolsuper, pg_has_role(current_user, oid, $1),
+			pg_has_role(current_user, oid, 'MEMBER WITH ADMIN OPTION')
+		FROM pg_roles`, privilege)
+	if err != nil {
+		return a, fmt.Errorf("failed to read role memberships: %w", err)
+	}
+	a.Roles = make(map[string]RoleAccess)
+	var name string
+	var r RoleAccess
+	_, err = pgx.ForEachRow(rows, []any{&name, &r.Superuser, &r.Member, &r.Admin}, func() error {
+		a.Roles[name] = r
+		return nil
+	})
+	if err != nil {
+		return a, fmt.Errorf("failed to read role memberships: %w", err)
+	}
+
+	err = conn.QueryRow(ctx, `
+		SELECT (SELECT count(*) FROM pg_database WHERE datdba = r.oid AND datname <> current_database())
+			+ (SELECT count(*) FROM pg_tablespace WHERE spcowner = r.oid)
+			+ (SELECT count(*) FROM pg_extension WHERE extowner = r.oid)
+			+ (SELECT count(*) FROM pg_namespace n
+				WHERE n.nspowner = r.oid AND `+otherSchema+`)
+			+ (SELECT count(*) FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+				WHERE c.relowner = r.oid AND `+otherSchema+`)
+			+ (SELECT count(*) FROM pg_proc p JOIN pg_namespace n ON n.oid = p.pronamespace
+				WHERE p.proowner = r.oid AND `+otherSchema+`)
+			+ (SELECT count(*) FROM pg_type t JOIN pg_namespace n ON n.oid = t.typnamespace
+				WHERE t.typowner = r.oid AND `+otherSchema+`)
+		FROM pg_roles r
+		WHERE r.rolname = current_user`, schemas).Scan(&a.OwnsOutside)
+	if err != nil {
+		return a, fmt.Errorf("failed to count objects of admin user: %w", err)
+	}
+
+	return a, nil
+}
+
+// canGrant reports whether the admin user may grant itself membership in
+// a role. created marks roles the plan creates, which their creator may
+// grant.
+func (a AdminAccess) canGrant(role string, created bool) bool {
+	if created {
+		return a.CreateRole
+	}
+	r, ok := a.Roles[role]
+	if !ok || r.Superuser {
+		return false
+	}
+	// Before PostgreSQL 16, CREATEROLE covers every role but superusers
+	return r.Admin || (a.ServerVersion < 160000 && a.CreateRole)
+}
+
+// memberships tracks the roles a non-superuser admin acts as while a plan
+// runs, granting itself membership where it lacks it
+type memberships struct {
+	admin   *AdminAccess
+	plan    *OwnershipPlan
+	add     func(string)
+	granted map[string]bool
+	denied  map[string]bool
+}
+
+// actAs makes sure the admin user may act as a role, adding the statement
+// granting it membership if needed. It reports false when the membership
+// cannot be granted.
+func (m *memberships) actAs(role string) bool {
+	if m.admin == nil || m.admin.Superuser || role == m.admin.User {
+		return true
+	}
+	if r, ok := m.admin.Roles[role]; ok && r.Member {
+		return true
+	}
+	if m.granted[role] {
+		return true
+	}
+	if m.denied[role] || !m.admin.canGrant(role, containsString(m.plan.CreatedRoles, role)) {
+		m.denied[role] = true
+		return false
+	}
+	m.granted[role] = true
+	m.plan.TemporaryMemberships = append(m.plan.TemporaryMemberships, role)
+	m.add(fmt.Sprintf("GRANT %s TO %s", quoteIdent(role), quoteIdent(m.admin.User)))
+	return true
+}
+
+// actAsAll makes sure the admin user may act as every given role
+func (m *memberships) actAsAll(roles ...string) bool {
+	for _, role := range roles {
+		if !m.actAs(role) {
+			return false
+		}
+	}
+	return true
+}
+
+// blocked returns the first of the given roles the admin user may not act
+// as
+func (m *memberships) blocked(roles ...string) string {
+	for _, role := range roles {
+		if !m.actAs(role) {
+			return role
+		}
+	}
+	return ""
+}
+
+// revokeAll adds the statements ending the memberships granted by actAs
+// to the cleanup of the plan
+func (m *memberships) revokeAll() {
+	for _, role := range m.plan.TemporaryMemberships {
+		m.plan.Cleanup = append(m.plan.Cleanup, fmt.Sprintf("REVOKE %s FROM %s", quoteIdent(role), quoteIdent(m.admin.User)))
+	}
+}
+
+// dropDefaultPrivileges removes the ALTER DEFAULT PRIVILEGES statements
+// for a role from a list of statements
+func dropDefaultPrivileges(stmts []string, role string) []string {
+	prefix := "ALTER DEFAULT PRIVILEGES FOR ROLE " + quoteIdent(role) + " "
+	var kept []string
+	for _, stmt := range stmts {
+		if !strings.HasPrefix(stmt, prefix) {
+			kept = append(kept, stmt)
+		}
+	}
+	return kept
+}
+
+// extensionAllowList returns the extension allow-list parameter that is
+// set on the server and the extensions it allows, or an empty name when
+// none is set
+func extensionAllowList(ctx context.Context, conn *pgx.Conn) (string, []string, error) {
+	for _, param := range allowListParameters {
+		var value *string
+		if err := conn.QueryRow(ctx, "SELECT current_setting($1, true)", param).Scan(&value); err != nil {
+			return "", nil, fmt.Errorf("failed to read %s: %w", param, err)
+		}
+		if value == nil || strings.TrimSpace(*value) == "" {
+			continue
+		}
+		var allowed []string
+		for _, name := range strings.Split(*value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				allowed = append(allowed, name)
+			}
+		}
+		return param, allowed, nil
+	}
+	return "", nil, nil
+}
\ No newline at end of file
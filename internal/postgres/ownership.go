@@ -3,274 +3,610 @@ package postgres
 import (
 	"context"
 	"fmt"
+	"strings"
 
 	"github.com/1CL0UD/cloudm-cli/internal/config"
 	"github.com/jackc/pgx/v5"
 )
 
-// CreateAppUserIfNotExists creates the app user if it doesn't exist
-func CreateAppUserIfNotExists(ctx context.Context, cfg config.TargetConfig, appUser string) error {
-	connStr := GetTargetConnectionString(cfg)
-	conn, err := pgx.Connect(ctx, connStr)
-	if err != nil {
-		return fmt.Errorf("failed to connect to database: %w", err)
-	}
-	defer conn.Close(ctx)
+// OwnedObject is an object of a database whose owner can be changed
+type OwnedObject struct {
+	ObjectKey
+	Identity string
+	Owner    string
+	ACL      []ACLEntry
+}
 
-	// Check if user exists
-	var exists bool
-	err = conn.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM pg_roles WHERE rolname = $1)", appUser).Scan(&exists)
-	if err != nil {
-		return fmt.Errorf("failed to check if user exists: %w", err)
-	}
+// ownedObjectQueries list the objects of the given schemas with their kind,
+// schema, name as shown by pg_restore -l, quoted name for ALTER statements,
+// owner and privileges. Objects belonging to an extension, sequences owned by a column
+// and types created along with a table or another type follow their parent
+// and are left out. Publications, event triggers, foreign servers and
+// procedural languages belong to the database and are always listed. Large
+// objects are counted by owner instead, by listLargeObjectOwners.
+var ownedObjectQueries = []string{
+	`SELECT 'SCHEMA', '', n.nspname, quote_ident(n.nspname), pg_get_userbyid(n.nspowner),
+		` + objectACL("n.nspacl", "'n'", "n.nspowner") + `
+	FROM pg_namespace n
+	WHERE n.nspname = ANY($1)
+	  AND ` + notExtensionMember("pg_namespace", "n.oid"),
+
+	`SELECT CASE t.typtype WHEN 'd' THEN 'DOMAIN' ELSE 'TYPE' END,
+		n.nspname, t.typname, quote_ident(n.nspname) || '.' || quote_ident(t.typname),
+		pg_get_userbyid(t.typowner), ` + objectACL("t.typacl", "'T'", "t.typowner") + `
+	FROM pg_type t
+	JOIN pg_namespace n ON n.oid = t.typnamespace
+	WHERE n.nspname = ANY($1)
+	  AND t.typtype IN ('b', 'c', 'd', 'e', 'r')
+	  AND (t.typrelid = 0 OR (SELECT c.relkind FROM pg_class c WHERE c.oid = t.typrelid) = 'c')
+	  AND NOT EXISTS (SELECT 1 FROM pg_type e WHERE e.typarray = t.oid)
+	  AND ` + notExtensionMember("pg_type", "t.oid"),
+
+	`SELECT CASE c.relkind
+			WHEN 'v' THEN 'VIEW'
+			WHEN 'm' THEN 'MATERIALIZED VIEW'
+			WHEN 'S' THEN 'SEQUENCE'
+			WHEN 'f' THEN 'FOREIGN TABLE'
+			ELSE 'TABLE'
+		END,
+		n.nspname, c.relname, quote_ident(n.nspname) || '.' || quote_ident(c.relname),
+		pg_get_userbyid(c.relowner),
+		` + objectACL("c.relacl", `(CASE c.relkind WHEN 'S' THEN 's' ELSE 'r' END)::"char"`, "c.relowner") + `
+	FROM pg_class c
+	JOIN pg_namespace n ON n.oid = c.relnamespace
+	WHERE n.nspname = ANY($1)
+	  AND c.relkind IN ('r', 'p', 'v', 'm', 'S', 'f')
+	  AND NOT EXISTS (
+		SELECT 1 FROM pg_depend d
+		WHERE d.classid = 'pg_class'::regclass AND d.objid = c.oid
+		  AND d.refclassid = 'pg_class'::regclass AND d.deptype IN ('a', 'i'))
+	  AND ` + notExtensionMember("pg_class", "c.oid"),
+
+	`SELECT CASE p.prokind WHEN 'p' THEN 'PROCEDURE' WHEN 'a' THEN 'AGGREGATE' ELSE 'FUNCTION' END,
+		n.nspname, p.proname || '(' || oidvectortypes(p.proargtypes) || ')',
+		quote_ident(n.nspname) || '.' || quote_ident(p.proname) || '(' || pg_get_function_identity_arguments(p.oid) || ')',
+		pg_get_userbyid(p.proowner), ` + objectACL("p.proacl", "'f'", "p.proowner") + `
+	FROM pg_proc p
+	JOIN pg_namespace n ON n.oid = p.pronamespace
+	WHERE n.nspname = ANY($1)
+	  AND ` + notExtensionMember("pg_proc", "p.oid"),
+
+	`SELECT 'OPERATOR', n.nspname, o.oprname,
+		quote_ident(n.nspname) || '.' || o.oprname || ' (' ||
+			coalesce(format_type(nullif(o.oprleft, 0), NULL), 'NONE') || ', ' ||
+			coalesce(format_type(nullif(o.oprright, 0), NULL), 'NONE') || ')',
+		pg_get_userbyid(o.oprowner), '{}'::text[]
+	FROM pg_operator o
+	JOIN pg_namespace n ON n.oid = o.oprnamespace
+	WHERE n.nspname = ANY($1)
+	  AND ` + notExtensionMember("pg_operator", "o.oid"),
+
+	`SELECT 'OPERATOR FAMILY', n.nspname, f.opfname,
+		quote_ident(n.nspname) || '.' || quote_ident(f.opfname) || ' USING ' || quote_ident(a.amname),
+		pg_get_userbyid(f.opfowner), '{}'::text[]
+	FROM pg_opfamily f
+	JOIN pg_namespace n ON n.oid = f.opfnamespace
+	JOIN pg_am a ON a.oid = f.opfmethod
+	WHERE n.nspname = ANY($1)
+	  AND ` + notExtensionMember("pg_opfamily", "f.oid"),
+
+	`SELECT 'OPERATOR CLASS', n.nspname, c.opcname,
+		quote_ident(n.nspname) || '.' || quote_ident(c.opcname) || ' USING ' || quote_ident(a.amname),
+		pg_get_userbyid(c.opcowner), '{}'::text[]
+	FROM pg_opclass c
+	JOIN pg_namespace n ON n.oid = c.opcnamespace
+	JOIN pg_am a ON a.oid = c.opcmethod
+	WHERE n.nspname = ANY($1)
+	  AND ` + notExtensionMember("pg_opclass", "c.oid"),
+
+	`SELECT 'COLLATION', n.nspname, c.collname, quote_ident(n.nspname) || '.' || quote_ident(c.collname),
+		pg_get_userbyid(c.collowner), '{}'::text[]
+	FROM pg_collation c
+	JOIN pg_namespace n ON n.oid = c.collnamespace
+	WHERE n.nspname = ANY($1)
+	  AND ` + notExtensionMember("pg_collation", "c.oid"),
+
+	`SELECT 'CONVERSION', n.nspname, c.conname, quote_ident(n.nspname) || '.' || quote_ident(c.conname),
+		pg_get_userbyid(c.conowner), '{}'::text[]
+	FROM pg_conversion c
+	JOIN pg_namespace n ON n.oid = c.connamespace
+	WHERE n.nspname = ANY($1)
+	  AND ` + notExtensionMember("pg_conversion", "c.oid"),
+
+	`SELECT 'TEXT SEARCH CONFIGURATION', n.nspname, c.cfgname, quote_ident(n.nspname) || '.' || quote_ident(c.cfgname),
+		pg_get_userbyid(c.cfgowner), '{}'::text[]
+	FROM pg_ts_config c
+	JOIN pg_namespace n ON n.oid = c.cfgnamespace
+	WHERE n.nspname = ANY($1)
+	  AND ` + notExtensionMember("pg_ts_config", "c.oid"),
+
+	`SELECT 'TEXT SEARCH DICTIONARY', n.nspname, d.dictname, quote_ident(n.nspname) || '.' || quote_ident(d.dictname),
+		pg_get_userbyid(d.dictowner), '{}'::text[]
+	FROM pg_ts_dict d
+	JOIN pg_namespace n ON n.oid = d.dictnamespace
+	WHERE n.nspname = ANY($1)
+	  AND ` + notExtensionMember("pg_ts_dict", "d.oid"),
+
+	`SELECT 'STATISTICS', n.nspname, s.stxname, quote_ident(n.nspname) || '.' || quote_ident(s.stxname),
+		pg_get_userbyid(s.stxowner), '{}'::text[]
+	FROM pg_statistic_ext s
+	JOIN pg_namespace n ON n.oid = s.stxnamespace
+	WHERE n.nspname = ANY($1)`,
+
+	`SELECT 'PUBLICATION', '', p.pubname, quote_ident(p.pubname), pg_get_userbyid(p.pubowner), '{}'::text[]
+	FROM pg_publication p
+	WHERE $1::text[] IS NOT NULL`,
+
+	`SELECT 'EVENT TRIGGER', '', e.evtname, quote_ident(e.evtname), pg_get_userbyid(e.evtowner), '{}'::text[]
+	FROM pg_event_trigger e
+	WHERE $1::text[] IS NOT NULL
+	  AND ` + notExtensionMember("pg_event_trigger", "e.oid"),
+
+	`SELECT 'FOREIGN SERVER', '', s.srvname, quote_ident(s.srvname), pg_get_userbyid(s.srvowner),
+		` + objectACL("s.srvacl", "'S'", "s.srvowner") + `
+	FROM pg_foreign_server s
+	WHERE $1::text[] IS NOT NULL
+	  AND ` + notExtensionMember("pg_foreign_server", "s.oid"),
+
+	`SELECT 'PROCEDURAL LANGUAGE', '', l.lanname, quote_ident(l.lanname), pg_get_userbyid(l.lanowner),
+		` + objectACL("l.lanacl", "'l'", "l.lanowner") + `
+	FROM pg_language l
+	WHERE $1::text[] IS NOT NULL AND l.lanispl
+	  AND ` + notExtensionMember("pg_language", "l.oid"),
+}
+
+// alterKinds are the keywords of ALTER statements for kinds named
+// differently by pg_restore -l
+var alterKinds = map[string]string{
+	"FOREIGN SERVER":      "SERVER",
+	"PROCEDURAL LANGUAGE": "LANGUAGE",
+}
+
+// superuserOwnedKinds are the kinds of objects only a superuser may own
+var superuserOwnedKinds = map[string]bool{
+	"EVENT TRIGGER": true,
+}
+
+// notExtensionMember builds the condition leaving out objects of a catalog
+// that belong to an extension
+func notExtensionMember(catalog, oid string) string {
+	return fmt.Sprintf(`NOT EXISTS (
+		SELECT 1 FROM pg_depend x
+		WHERE x.classid = '%s'::regclass AND x.objid = %s AND x.deptype = 'e')`, catalog, oid)
+}
 
-	if !exists {
-		// Create user with a temporary password
-		password := "CHANGE_ME_AFTER_MIGRATION"
-		if cfg.AppUserPassword != "" {
-			password = cfg.AppUserPassword
+// listOwnedObjects returns the objects of the given schemas whose owner can
+// be changed, in the order they should be changed
+func listOwnedObjects(ctx context.Context, conn *pgx.Conn, schemas []string) ([]OwnedObject, error) {
+	var objects []OwnedObject
+	for _, query := range ownedObjectQueries {
+		rows, err := conn.Query(ctx, query, schemas)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects: %w", err)
 		}
-		_, err = conn.Exec(ctx, fmt.Sprintf("CREATE USER %s WITH PASSWORD '%s'", appUser, password))
+		found, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (OwnedObject, error) {
+			var o OwnedObject
+			var acl []string
+			err := row.Scan(&o.Kind, &o.Schema, &o.Name, &o.Identity, &o.Owner, &acl)
+			o.ACL = parseObjectACL(acl)
+			return o, err
+		})
 		if err != nil {
-			return fmt.Errorf("failed to create user %s: %w", appUser, err)
+			return nil, fmt.Errorf("failed to list objects: %w", err)
 		}
+		objects = append(objects, found...)
 	}
+	return objects, nil
+}
 
-	return nil
+// OwnershipPlan is the ordered list of statements giving objects their
+// owners and roles their privileges
+type OwnershipPlan struct {
+	Statements []string
+	// CreatedRoles are the roles the plan creates
+	CreatedRoles []string
+	// Skipped are the objects left with their owner because the new owner
+	// is not allowed to own them
+	Skipped []string
+	// Managed is set when the admin user is not a superuser
+	Managed bool
+	// TemporaryMemberships are the roles the admin user is made a member of
+	// while the plan runs
+	TemporaryMemberships []string
+	// Impossible are the operations left out because the admin user lacks
+	// the privileges they need
+	Impossible []string
+	// LargeObjects are the changes of large object owners
+	LargeObjects []LargeObjectChange
+	// BatchLargeObjects makes the large object changes after the statements
+	// are committed, in batches committing one by one, instead of in the
+	// same transaction
+	BatchLargeObjects bool
+	// Cleanup ends the temporary memberships once everything else ran
+	Cleanup []string
 }
 
-// TransferOwnership transfers ownership of all database objects to app user
-func TransferOwnership(ctx context.Context, cfg config.TargetConfig, appUser string) error {
-	connStr := GetTargetConnectionString(cfg)
-	conn, err := pgx.Connect(ctx, connStr)
-	if err != nil {
-		return fmt.Errorf("failed to connect to database: %w", err)
+// phases returns the statements running in a single transaction, the
+// large object changes made in batches after it and the statements running
+// after those. Unless large objects are batched, everything is part of the
+// transaction.
+func (p OwnershipPlan) phases() (tx []string, batches []LargeObjectChange, after []string) {
+	tx = p.Statements[:len(p.Statements):len(p.Statements)]
+	if p.BatchLargeObjects && len(p.LargeObjects) > 0 {
+		return tx, p.LargeObjects, p.Cleanup
 	}
-	defer conn.Close(ctx)
-
-	// 1. Alter database owner
-	if err := AlterDatabaseOwner(ctx, conn, cfg.Database, appUser); err != nil {
-		return err
+	for _, c := range p.LargeObjects {
+		tx = append(tx, c.SQL())
 	}
+	return append(tx, p.Cleanup...), nil, nil
+}
 
-	// 2. Alter schema owner
-	if err := AlterSchemaOwner(ctx, conn, "public", appUser); err != nil {
-		return err
+// SQL returns the plan as a script running its statements in a single
+// transaction, then any batched large object changes
+func (p OwnershipPlan) SQL() string {
+	tx, batches, after := p.phases()
+	var b strings.Builder
+	b.WriteString("BEGIN;\n")
+	for _, stmt := range tx {
+		b.WriteString(stmt)
+		b.WriteString(";\n")
 	}
-
-	// 3. Alter all tables
-	if err := AlterTableOwners(ctx, conn, "public", appUser); err != nil {
-		return err
+	b.WriteString("COMMIT;\n")
+	for _, c := range batches {
+		fmt.Fprintf(&b, "-- %s, committed every %d\n%s;\n", c, largeObjectBatch, c.BatchedSQL())
 	}
-
-	// 4. Alter all sequences
-	if err := AlterSequenceOwners(ctx, conn, "public", appUser); err != nil {
-		return err
+	for _, stmt := range after {
+		b.WriteString(stmt)
+		b.WriteString(";\n")
 	}
+	return b.String()
+}
 
-	// 5. Alter all views
-	if err := AlterViewOwners(ctx, conn, "public", appUser); err != nil {
-		return err
+// PlanOwnership builds the plan giving the objects on the target of the
+// mapping's schemas, the schemas themselves, the database and its large
+// objects to the owners chosen by the role mapping, creating the roles that
+// don't exist yet, and granting privileges by the grants rules, or all
+// privileges to the default role when there are none
+func PlanOwnership(ctx context.Context, cfg config.TargetConfig, mapping RoleMapping, grants []GrantRule) (OwnershipPlan, error) {
+	connStr := GetTargetConnectionString(cfg)
+	conn, err := pgx.Connect(ctx, connStr)
+	if err != nil {
+		return OwnershipPlan{}, fmt.Errorf("failed to connect to database: %w", err)
 	}
+	defer conn.Close(ctx)
 
-	// 6. Alter all materialized views
-	if err := AlterMaterializedViewOwners(ctx, conn, "public", appUser); err != nil {
-		return err
+	objects, err := listOwnedObjects(ctx, conn, mapping.Schemas)
+	if err != nil {
+		return OwnershipPlan{}, err
 	}
-
-	// 7. Alter all functions
-	if err := AlterFunctionOwners(ctx, conn, "public", appUser); err != nil {
-		return err
+	largeObjects, err := listLargeObjectOwners(ctx, conn)
+	if err != nil {
+		return OwnershipPlan{}, err
 	}
-
-	// 8. Grant privileges
-	if err := GrantPrivileges(ctx, conn, cfg.Database, "public", appUser); err != nil {
-		return err
+	roles, err := listRoles(ctx, conn)
+	if err != nil {
+		return OwnershipPlan{}, err
 	}
-
-	// 9. Set default privileges
-	if err := SetDefaultPrivileges(ctx, conn, "public", appUser); err != nil {
-		return err
+	admin, err := readAdminAccess(ctx, conn, mapping.Schemas)
+	if err != nil {
+		return OwnershipPlan{}, err
 	}
 
-	return nil
+	return buildOwnershipPlan(cfg.Database, objects, largeObjects, roles, mapping, grants, &admin), nil
 }
 
-// AlterDatabaseOwner changes database owner
-func AlterDatabaseOwner(ctx context.Context, conn *pgx.Conn, db, owner string) error {
-	_, err := conn.Exec(ctx, fmt.Sprintf("ALTER DATABASE %s OWNER TO %s", db, owner))
+// PlanOwnershipFromSource builds the plan PlanOwnership would build once
+// the source is restored into the target, for a dry run. Restored objects
+// are owned by the admin user, so every object is planned to change owner.
+func PlanOwnershipFromSource(ctx context.Context, source config.DatabaseConfig, target config.TargetConfig, mapping RoleMapping, grants []GrantRule) (OwnershipPlan, error) {
+	sourceConn, err := pgx.Connect(ctx, GetConnectionString(source))
 	if err != nil {
-		return fmt.Errorf("failed to alter database owner: %w", err)
+		return OwnershipPlan{}, fmt.Errorf("failed to connect to database: %w", err)
 	}
-	return nil
-}
+	defer sourceConn.Close(ctx)
 
-// AlterSchemaOwner changes schema owner
-func AlterSchemaOwner(ctx context.Context, conn *pgx.Conn, schema, owner string) error {
-	_, err := conn.Exec(ctx, fmt.Sprintf("ALTER SCHEMA %s OWNER TO %s", schema, owner))
+	objects, err := listOwnedObjects(ctx, sourceConn, mapping.Schemas)
 	if err != nil {
-		return fmt.Errorf("failed to alter schema owner: %w", err)
+		return OwnershipPlan{}, err
 	}
-	return nil
-}
-
-// AlterTableOwners changes all table owners in a schema
-func AlterTableOwners(ctx context.Context, conn *pgx.Conn, schema, owner string) error {
-	rows, err := conn.Query(ctx,
-		"SELECT tablename FROM pg_tables WHERE schemaname = $1", schema)
+	for i := range objects {
+		objects[i].Owner = target.AdminUser
+	}
+	sourceLargeObjects, err := listLargeObjectOwners(ctx, sourceConn)
 	if err != nil {
-		return fmt.Errorf("failed to query tables: %w", err)
+		return OwnershipPlan{}, err
+	}
+	var count int64
+	for _, lo := range sourceLargeObjects {
+		count += lo.Count
+	}
+	var largeObjects []LargeObjectOwner
+	if count > 0 {
+		largeObjects = []LargeObjectOwner{{Owner: target.AdminUser, Count: count}}
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var tableName string
-		if err := rows.Scan(&tableName); err != nil {
-			return fmt.Errorf("failed to scan table name: %w", err)
-		}
+	targetConn, err := pgx.Connect(ctx, GetTargetConnectionString(target))
+	if err != nil {
+		return OwnershipPlan{}, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer targetConn.Close(ctx)
 
-		_, err = conn.Exec(ctx, fmt.Sprintf("ALTER TABLE %s.%s OWNER TO %s", schema, tableName, owner))
-		if err != nil {
-			return fmt.Errorf("failed to alter table %s owner: %w", tableName, err)
+	roles, err := listRoles(ctx, targetConn)
+	if err != nil {
+		return OwnershipPlan{}, err
+	}
+	admin, err := readAdminAccess(ctx, targetConn, mapping.Schemas)
+	if err != nil {
+		return OwnershipPlan{}, err
+	}
+	// The app user is created by the admin user before the plan runs
+	if _, ok := roles[target.AppUser]; !ok {
+		roles[target.AppUser] = false
+		if admin.Roles != nil {
+			admin.Roles[target.AppUser] = RoleAccess{Admin: admin.CreateRole}
 		}
 	}
 
-	return rows.Err()
+	return buildOwnershipPlan(target.Database, objects, largeObjects, roles, mapping, grants, &admin), nil
 }
 
-// AlterSequenceOwners changes all sequence owners in a schema
-func AlterSequenceOwners(ctx context.Context, conn *pgx.Conn, schema, owner string) error {
-	rows, err := conn.Query(ctx,
-		"SELECT sequence_name FROM information_schema.sequences WHERE sequence_schema = $1", schema)
+// ApplyOwnershipPlan executes the statements of a plan in a single
+// transaction, so that either all of them or none take effect. When large
+// objects are batched, they then change owner in batches of their own, and
+// the temporary memberships end last, also when a batch fails.
+func ApplyOwnershipPlan(ctx context.Context, cfg config.TargetConfig, plan OwnershipPlan) error {
+	connStr := GetTargetConnectionString(cfg)
+	conn, err := pgx.Connect(ctx, connStr)
 	if err != nil {
-		return fmt.Errorf("failed to query sequences: %w", err)
+		return fmt.Errorf("failed to connect to database: %w", err)
 	}
-	defer rows.Close()
+	defer conn.Close(ctx)
 
-	for rows.Next() {
-		var seqName string
-		if err := rows.Scan(&seqName); err != nil {
-			return fmt.Errorf("failed to scan sequence name: %w", err)
+	stmts, batches, after := plan.phases()
+	err = pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		for _, stmt := range stmts {
+			if _, err := tx.Exec(ctx, stmt); err != nil {
+				return fmt.Errorf("failed to execute %q: %w", stmt, err)
+			}
 		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
 
-		_, err = conn.Exec(ctx, fmt.Sprintf("ALTER SEQUENCE %s.%s OWNER TO %s", schema, seqName, owner))
-		if err != nil {
-			return fmt.Errorf("failed to alter sequence %s owner: %w", seqName, err)
+	// The blocks commit their batches themselves, which the simple protocol
+	// outside a transaction block allows
+	for _, c := range batches {
+		if _, err = conn.Exec(ctx, c.BatchedSQL(), pgx.QueryExecModeSimpleProtocol); err != nil {
+			err = fmt.Errorf("statements committed, but failed to change owner of %s: %w", c, err)
+			break
 		}
 	}
-
-	return rows.Err()
+	for _, stmt := range after {
+		if _, cleanupErr := conn.Exec(ctx, stmt); cleanupErr != nil && err == nil {
+			err = fmt.Errorf("failed to execute %q: %w", stmt, cleanupErr)
+		}
+	}
+	return err
 }
 
-// AlterViewOwners changes all view owners in a schema
-func AlterViewOwners(ctx context.Context, conn *pgx.Conn, schema, owner string) error {
-	rows, err := conn.Query(ctx,
-		"SELECT table_name FROM information_schema.views WHERE table_schema = $1", schema)
-	if err != nil {
-		return fmt.Errorf("failed to query views: %w", err)
+// buildOwnershipPlan builds the plan for the given objects and existing
+// roles, which map to whether they are superusers. When the admin user is
+// not a superuser, it is granted membership in the roles it acts as for
+// the length of the plan, and what it cannot do is left out and reported.
+func buildOwnershipPlan(db string, objects []OwnedObject, largeObjects []LargeObjectOwner, existing map[string]bool, mapping RoleMapping, grants []GrantRule, admin *AdminAccess) OwnershipPlan {
+	var plan OwnershipPlan
+	seen := make(map[string]bool)
+	add := func(stmt string) {
+		if !seen[stmt] {
+			seen[stmt] = true
+			plan.Statements = append(plan.Statements, stmt)
+		}
+	}
+	managed := admin != nil && !admin.Superuser
+	plan.Managed = managed
+	members := &memberships{admin: admin, plan: &plan, add: add, granted: make(map[string]bool), denied: make(map[string]bool)}
+	impossible := func(format string, args ...any) {
+		plan.Impossible = append(plan.Impossible, fmt.Sprintf(format, args...))
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var viewName string
-		if err := rows.Scan(&viewName); err != nil {
-			return fmt.Errorf("failed to scan view name: %w", err)
+	// 1. Create the roles objects are mapped to and privileges granted to
+	roles := make(map[string]bool, len(existing))
+	for role, superuser := range existing {
+		roles[role] = superuser
+	}
+	uncreated := make(map[string]bool)
+	createRole := func(role string) {
+		if _, ok := roles[role]; ok || role == "" {
+			return
 		}
-
-		_, err = conn.Exec(ctx, fmt.Sprintf("ALTER VIEW %s.%s OWNER TO %s", schema, viewName, owner))
-		if err != nil {
-			return fmt.Errorf("failed to alter view %s owner: %w", viewName, err)
+		roles[role] = false
+		if managed && !admin.CreateRole {
+			impossible("create role %s (%s lacks CREATEROLE)", role, admin.User)
+			uncreated[role] = true
+			members.denied[role] = true
+			return
 		}
+		plan.CreatedRoles = append(plan.CreatedRoles, role)
+		add("CREATE ROLE " + quoteIdent(role) + " NOLOGIN")
+	}
+	createRole(mapping.Default)
+	for _, o := range objects {
+		createRole(mapping.OwnerFor(o.ObjectKey))
+	}
+	largeObjectOwner, mapped := mapping.LargeObjectOwner()
+	if mapped && len(largeObjects) > 0 {
+		createRole(largeObjectOwner)
+	}
+	for _, r := range grants {
+		createRole(r.Role)
 	}
 
-	return rows.Err()
-}
+	// With every object going to the default role, REASSIGN OWNED gives it
+	// all objects of the admin user at once, provided the admin user owns
+	// nothing else
+	reassign := managed && len(mapping.Roles) == 0 && !mapping.KeepSourceOwners && admin.OwnsOutside == 0 &&
+		(admin.CreateDB || admin.DatabaseOwner != admin.User) && members.actAs(mapping.Default)
+	if reassign {
+		add(fmt.Sprintf("REASSIGN OWNED BY %s TO %s", quoteIdent(admin.User), quoteIdent(mapping.Default)))
+	}
 
-// AlterMaterializedViewOwners changes all materialized view owners in a schema
-func AlterMaterializedViewOwners(ctx context.Context, conn *pgx.Conn, schema, owner string) error {
-	rows, err := conn.Query(ctx,
-		"SELECT matviewname FROM pg_matviews WHERE schemaname = $1", schema)
-	if err != nil {
-		return fmt.Errorf("failed to query materialized views: %w", err)
+	// 2. Alter database owner
+	switch {
+	case !managed:
+		add(alterOwnerSQL("DATABASE", quoteIdent(db), mapping.Default))
+	case admin.DatabaseOwner == mapping.Default || (reassign && admin.DatabaseOwner == admin.User):
+	case !admin.CreateDB:
+		impossible("change owner of database %s to %s (%s lacks CREATEDB)", db, mapping.Default, admin.User)
+	case !members.actAsAll(admin.DatabaseOwner, mapping.Default):
+		impossible("change owner of database %s to %s (%s cannot be granted membership in %s)",
+			db, mapping.Default, admin.User, members.blocked(admin.DatabaseOwner, mapping.Default))
+	default:
+		add(alterOwnerSQL("DATABASE", quoteIdent(db), mapping.Default))
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var matviewName string
-		if err := rows.Scan(&matviewName); err != nil {
-			return fmt.Errorf("failed to scan materialized view name: %w", err)
+	// 3. Alter schema, object and large object owners
+	for _, o := range objects {
+		owner := mapping.OwnerFor(o.ObjectKey)
+		if owner == o.Owner || (reassign && o.Owner == admin.User) {
+			continue
+		}
+		if superuserOwnedKinds[o.Kind] && !roles[owner] {
+			plan.Skipped = append(plan.Skipped, fmt.Sprintf("%s %s (owner %s must be a superuser)", strings.ToLower(o.Kind), o.Identity, owner))
+			continue
+		}
+		if !members.actAsAll(o.Owner, owner) {
+			impossible("change owner of %s %s from %s to %s (%s cannot be granted membership in %s)",
+				strings.ToLower(o.Kind), o.Identity, o.Owner, owner, admin.User, members.blocked(o.Owner, owner))
+			continue
+		}
+		add(alterOwnerSQL(o.Kind, o.Identity, owner))
+	}
+	for _, lo := range largeObjects {
+		if !mapped {
+			plan.Skipped = append(plan.Skipped, fmt.Sprintf("%d large objects of %s (their source owners map to several roles)", lo.Count, lo.Owner))
+			continue
+		}
+		if lo.Owner == largeObjectOwner || (reassign && lo.Owner == admin.User) {
+			continue
 		}
+		if !members.actAsAll(lo.Owner, largeObjectOwner) {
+			impossible("change owner of %d large objects from %s to %s (%s cannot be granted membership in %s)",
+				lo.Count, lo.Owner, largeObjectOwner, admin.User, members.blocked(lo.Owner, largeObjectOwner))
+			continue
+		}
+		plan.LargeObjects = append(plan.LargeObjects, LargeObjectChange{From: lo.Owner, To: largeObjectOwner, Count: lo.Count})
+	}
 
-		_, err = conn.Exec(ctx, fmt.Sprintf("ALTER MATERIALIZED VIEW %s.%s OWNER TO %s", schema, matviewName, owner))
-		if err != nil {
-			return fmt.Errorf("failed to alter materialized view %s owner: %w", matviewName, err)
+	// 4. Grant privileges unless only owners change, leaving out roles
+	// that couldn't be created.
+	// Default privileges of grants rules are set for the roles that create
+	// objects, which the admin user must be able to act as; without rules
+	// they are set for the admin user itself.
+	var privileges []string
+	var creators []string
+	switch {
+	case mapping.OwnersOnly:
+	case len(grants) > 0:
+		var usable []GrantRule
+		for _, r := range grants {
+			if uncreated[r.Role] {
+				impossible("grant privileges to %s (the role was not created)", r.Role)
+				continue
+			}
+			usable = append(usable, r)
+		}
+		privileges = grantRuleStatements(db, usable, mapping.Default)
+		for _, r := range usable {
+			for _, schema := range r.Schemas {
+				for _, creator := range defaultCreators(usable, schema, mapping.Default) {
+					creators = appendUnique(creators, creator)
+				}
+			}
 		}
+	case uncreated[mapping.Default]:
+		impossible("grant privileges to %s (the role was not created)", mapping.Default)
+	default:
+		for _, schema := range mapping.Schemas {
+			privileges = append(privileges, grantStatements(db, schema, mapping.Default)...)
+			privileges = append(privileges, defaultPrivilegeStatements(schema, mapping.Default)...)
+		}
+	}
+	for _, creator := range creators {
+		if !members.actAs(creator) {
+			impossible("set default privileges for objects created by %s (%s cannot be granted membership in %s)",
+				creator, admin.User, creator)
+			privileges = dropDefaultPrivileges(privileges, creator)
+		}
+	}
+	for _, stmt := range privileges {
+		add(stmt)
 	}
 
-	return rows.Err()
+	// 5. End the memberships granted to the admin user
+	if managed {
+		members.revokeAll()
+	}
+
+	return plan
 }
 
-// AlterFunctionOwners changes all function owners in a schema
-func AlterFunctionOwners(ctx context.Context, conn *pgx.Conn, schema, owner string) error {
-	rows, err := conn.Query(ctx, `
-		SELECT p.proname, pg_get_function_identity_arguments(p.oid) 
-		FROM pg_proc p 
-		JOIN pg_namespace n ON n.oid = p.pronamespace 
-		WHERE n.nspname = $1`, schema)
+// listRoles returns the roles of the server and whether they are superusers
+func listRoles(ctx context.Context, conn *pgx.Conn) (map[string]bool, error) {
+	rows, err := conn.Query(ctx, "SELECT rolname, rolsuper FROM pg_roles")
 	if err != nil {
-		return fmt.Errorf("failed to query functions: %w", err)
+		return nil, fmt.Errorf("failed to list roles: %w", err)
 	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var funcName, args string
-		if err := rows.Scan(&funcName, &args); err != nil {
-			return fmt.Errorf("failed to scan function info: %w", err)
-		}
 
-		_, err = conn.Exec(ctx, fmt.Sprintf("ALTER FUNCTION %s.%s(%s) OWNER TO %s", schema, funcName, args, owner))
-		if err != nil {
-			return fmt.Errorf("failed to alter function %s owner: %w", funcName, err)
-		}
+	roles := make(map[string]bool)
+	var name string
+	var superuser bool
+	_, err = pgx.ForEachRow(rows, []any{&name, &superuser}, func() error {
+		roles[name] = superuser
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list roles: %w", err)
 	}
 
-	return rows.Err()
+	return roles, nil
 }
 
-// GrantPrivileges grants all privileges to app user
-func GrantPrivileges(ctx context.Context, conn *pgx.Conn, db, schema, user string) error {
-	grants := []string{
+// createUserSQL builds the statement creating a login role with a password
+func createUserSQL(user, password string) string {
+	return fmt.Sprintf("CREATE USER %s WITH PASSWORD %s", quoteIdent(user), quoteLiteral(password))
+}
+
+// alterOwnerSQL builds the statement changing the owner of an object whose
+// name is already quoted
+func alterOwnerSQL(kind, name, owner string) string {
+	if keyword, ok := alterKinds[kind]; ok {
+		kind = keyword
+	}
+	return fmt.Sprintf("ALTER %s %s OWNER TO %s", kind, name, quoteIdent(owner))
+}
+
+// grantStatements builds the statements granting all privileges on a
+// database and the objects of a schema
+func grantStatements(db, schema, user string) []string {
+	db, schema, user = quoteIdent(db), quoteIdent(schema), quoteIdent(user)
+	return []string{
 		fmt.Sprintf("GRANT ALL PRIVILEGES ON DATABASE %s TO %s", db, user),
 		fmt.Sprintf("GRANT ALL ON SCHEMA %s TO %s", schema, user),
 		fmt.Sprintf("GRANT ALL PRIVILEGES ON ALL TABLES IN SCHEMA %s TO %s", schema, user),
 		fmt.Sprintf("GRANT ALL PRIVILEGES ON ALL SEQUENCES IN SCHEMA %s TO %s", schema, user),
 		fmt.Sprintf("GRANT ALL PRIVILEGES ON ALL FUNCTIONS IN SCHEMA %s TO %s", schema, user),
 	}
-
-	for _, grant := range grants {
-		_, err := conn.Exec(ctx, grant)
-		if err != nil {
-			return fmt.Errorf("failed to execute grant: %w", err)
-		}
-	}
-
-	return nil
 }
 
-// SetDefaultPrivileges sets default privileges for future objects
-func SetDefaultPrivileges(ctx context.Context, conn *pgx.Conn, schema, user string) error {
-	defaults := []string{
+// defaultPrivilegeStatements builds the statements granting all privileges
+// on objects created later in a schema
+func defaultPrivilegeStatements(schema, user string) []string {
+	schema, user = quoteIdent(schema), quoteIdent(user)
+	return []string{
 		fmt.Sprintf("ALTER DEFAULT PRIVILEGES IN SCHEMA %s GRANT ALL ON TABLES TO %s", schema, user),
 		fmt.Sprintf("ALTER DEFAULT PRIVILEGES IN SCHEMA %s GRANT ALL ON SEQUENCES TO %s", schema, user),
 		fmt.Sprintf("ALTER DEFAULT PRIVILEGES IN SCHEMA %s GRANT ALL ON FUNCTIONS TO %s", schema, user),
 	}
-
-	for _, def := range defaults {
-		_, err := conn.Exec(ctx, def)
-		if err != nil {
-			return fmt.Errorf("failed to set default privileges: %w", err)
-		}
-	}
-
-	return nil
 }
\ No newline at end of file
@@ -0,0 +1,345 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/1CL0UD/cloudm-cli/internal/config"
+	"github.com/1CL0UD/cloudm-cli/internal/encryption"
+	"github.com/jackc/pgx/v5"
+)
+
+// Ways of handling the privileges recorded in a dump, as set by
+// options.privileges
+const (
+	// PrivilegesReset drops them; the target gets only the grants of the
+	// configuration
+	PrivilegesReset = "reset"
+	// PrivilegesPreserve restores them as they are
+	PrivilegesPreserve = "preserve"
+	// PrivilegesMap restores them with their roles renamed by role_map
+	PrivilegesMap = "map"
+)
+
+// aclTypes are the TOC entry types holding privileges
+var aclTypes = map[string]bool{
+	"ACL":         true,
+	"DEFAULT ACL": true,
+}
+
+// DumpACLs reads the GRANT, REVOKE and ALTER DEFAULT PRIVILEGES statements
+// recorded in a dump. When entries is not nil, only the privileges among
+// those entries are read.
+func DumpACLs(dumpFile string, dec *encryption.Decryptor, entries []TOCEntry) ([]string, error) {
+	if entries == nil {
+		var err error
+		if entries, err = ReadTOC(dumpFile, dec); err != nil {
+			return nil, err
+		}
+	}
+
+	var acls []TOCEntry
+	for _, e := range entries {
+		if aclTypes[e.Type] {
+			acls = append(acls, e)
+		}
+	}
+	if len(acls) == 0 {
+		return nil, nil
+	}
+
+	f, err := os.CreateTemp("", "cloudm-acl-*.list")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create restore list: %w", err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	if err := WriteTOCList(acls, f.Name()); err != nil {
+		return nil, err
+	}
+
+	stdin, closeInput, err := openArchive(dumpFile, dec)
+	if err != nil {
+		return nil, err
+	}
+	defer closeInput()
+
+	cmd := exec.Command("pg_restore", "-f", "-", "-L", f.Name())
+	if stdin != nil {
+		cmd.Stdin = stdin
+	} else {
+		cmd.Args = append(cmd.Args, dumpFile)
+	}
+
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read privileges of dump: %w\nstderr: %s", err, stderr.String())
+	}
+
+	return parseACLScript(string(output)), nil
+}
+
+// parseACLScript returns the privilege statements of a script written by
+// pg_restore, without their trailing semicolon. Settings and the session
+// authorization switches made for grants by other roles are left out: the
+// statements run as the admin user.
+func parseACLScript(script string) []string {
+	var stmts []string
+	var current strings.Builder
+	for _, line := range strings.Split(script, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if current.Len() == 0 && (strings.TrimSpace(line) == "" || strings.HasPrefix(line, "--")) {
+			continue
+		}
+
+		if current.Len() > 0 {
+			current.WriteString("\n")
+		}
+		current.WriteString(line)
+		if !strings.HasSuffix(strings.TrimSpace(line), ";") {
+			continue
+		}
+
+		stmt := strings.TrimSuffix(strings.TrimSpace(current.String()), ";")
+		current.Reset()
+		if isACLStatement(stmt) {
+			stmts = append(stmts, stmt)
+		}
+	}
+	return stmts
+}
+
+// isACLStatement reports whether a statement changes privileges
+func isACLStatement(stmt string) bool {
+	upper := strings.ToUpper(stmt)
+	return strings.HasPrefix(upper, "GRANT ") || strings.HasPrefix(upper, "REVOKE ") ||
+		strings.HasPrefix(upper, "ALTER DEFAULT PRIVILEGES ")
+}
+
+// aclToken is a word, quoted identifier or punctuation of a statement
+type aclToken struct {
+	text       string
+	quoted     bool
+	start, end int
+}
+
+// is reports whether the token is the given keyword
+func (t aclToken) is(keyword string) bool {
+	return !t.quoted && strings.EqualFold(t.text, keyword)
+}
+
+// roleRef is a role named by a privilege statement and its position
+type roleRef struct {
+	name       string
+	start, end int
+}
+
+// tokenizeACL splits a privilege statement into tokens
+func tokenizeACL(stmt string) []aclToken {
+	var tokens []aclToken
+	for i := 0; i < len(stmt); {
+		c := stmt[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '"':
+			var sb strings.Builder
+			j := i + 1
+			for j < len(stmt) {
+				if stmt[j] == '"' {
+					if j+1 < len(stmt) && stmt[j+1] == '"' {
+						sb.WriteByte('"')
+						j += 2
+						continue
+					}
+					break
+				}
+				sb.WriteByte(stmt[j])
+				j++
+			}
+			tokens = append(tokens, aclToken{text: sb.String(), quoted: true, start: i, end: j + 1})
+			i = j + 1
+		case isIdentChar(c):
+			j := i
+			for j < len(stmt) && isIdentChar(stmt[j]) {
+				j++
+			}
+			tokens = append(tokens, aclToken{text: stmt[i:j], start: i, end: j})
+			i = j
+		default:
+			tokens = append(tokens, aclToken{text: stmt[i : i+1], start: i, end: i + 1})
+			i++
+		}
+	}
+	return tokens
+}
+
+// isIdentChar reports whether c may appear in an unquoted identifier
+func isIdentChar(c byte) bool {
+	return c == '_' || c == '$' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+// aclRoleRefs returns the roles named by a privilege statement: those of
+// FOR ROLE, the grantees and the grantor of GRANTED BY. PUBLIC and the
+// CURRENT_USER, CURRENT_ROLE and SESSION_USER keywords are not role names
+// and are left out.
+func aclRoleRefs(stmt string) []roleRef {
+	tokens := tokenizeACL(stmt)
+	var refs []roleRef
+
+	// readRoles reads a comma-separated list of roles starting at i
+	readRoles := func(i int) int {
+		for i < len(tokens) {
+			if tokens[i].is("GROUP") {
+				i++
+				continue
+			}
+			t := tokens[i]
+			if !t.quoted && !isIdentChar(t.text[0]) {
+				break
+			}
+			if !t.is("PUBLIC") && !t.is("CURRENT_USER") && !t.is("CURRENT_ROLE") && !t.is("SESSION_USER") {
+				name := t.text
+				if !t.quoted {
+					name = strings.ToLower(name)
+				}
+				refs = append(refs, roleRef{name: name, start: t.start, end: t.end})
+			}
+			i++
+			if i >= len(tokens) || tokens[i].text != "," {
+				break
+			}
+			i++
+		}
+		return i
+	}
+
+	// ALTER DEFAULT PRIVILEGES FOR ROLE a, b IN SCHEMA s GRANT ...
+	start := 0
+	if len(tokens) > 3 && tokens[0].is("ALTER") && tokens[1].is("DEFAULT") {
+		for start < len(tokens) && !tokens[start].is("GRANT") && !tokens[start].is("REVOKE") {
+			if tokens[start].is("FOR") && start+1 < len(tokens) && (tokens[start+1].is("ROLE") || tokens[start+1].is("USER")) {
+				start = readRoles(start + 2)
+				continue
+			}
+			start++
+		}
+	}
+	if start >= len(tokens) {
+		return refs
+	}
+
+	// The grantees follow the last TO or FROM outside parentheses, which
+	// can't be part of an object name as pg_dump quotes reserved words
+	keyword := "TO"
+	if tokens[start].is("REVOKE") {
+		keyword = "FROM"
+	}
+	depth, at := 0, -1
+	for i := start; i < len(tokens); i++ {
+		switch {
+		case tokens[i].text == "(" && !tokens[i].quoted:
+			depth++
+		case tokens[i].text == ")" && !tokens[i].quoted:
+			depth--
+		case depth == 0 && tokens[i].is(keyword):
+			at = i
+		}
+	}
+	if at < 0 {
+		return refs
+	}
+
+	i := readRoles(at + 1)
+	for ; i+2 < len(tokens); i++ {
+		if tokens[i].is("GRANTED") && tokens[i+1].is("BY") {
+			readRoles(i + 2)
+			break
+		}
+	}
+	return refs
+}
+
+// ACLRoles returns the roles named by privilege statements, sorted
+func ACLRoles(stmts []string) []string {
+	seen := make(map[string]bool)
+	var roles []string
+	for _, stmt := range stmts {
+		for _, ref := range aclRoleRefs(stmt) {
+			if !seen[ref.name] {
+				seen[ref.name] = true
+				roles = append(roles, ref.name)
+			}
+		}
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+// MapACLRoles renames the roles of privilege statements through a mapping
+// from source roles to target roles. Roles that aren't mapped are kept.
+func MapACLRoles(stmts []string, roles map[string]string) []string {
+	mapped := make([]string, 0, len(stmts))
+	for _, stmt := range stmts {
+		refs := aclRoleRefs(stmt)
+		for i := len(refs) - 1; i >= 0; i-- {
+			if role, ok := roles[refs[i].name]; ok {
+				stmt = stmt[:refs[i].start] + quoteIdent(role) + stmt[refs[i].end:]
+			}
+		}
+		mapped = append(mapped, stmt)
+	}
+	return mapped
+}
+
+// WithoutRoles returns the privilege statements that name none of the
+// given roles
+func WithoutRoles(stmts []string, roles []string) []string {
+	var kept []string
+	for _, stmt := range stmts {
+		named := false
+		for _, ref := range aclRoleRefs(stmt) {
+			if containsString(roles, ref.name) {
+				named = true
+				break
+			}
+		}
+		if !named {
+			kept = append(kept, stmt)
+		}
+	}
+	return kept
+}
+
+// MissingRoles returns the roles that don't exist on the target server.
+// Roles are shared by its databases, so this works before the target
+// database is created.
+func MissingRoles(ctx context.Context, cfg config.TargetConfig, roles []string) ([]string, error) {
+	connStr := GetTargetPostgresConnectionString(cfg)
+	conn, err := pgx.Connect(ctx, connStr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to postgres database: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	existing, err := listRoles(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []string
+	for _, role := range roles {
+		if _, ok := existing[role]; !ok {
+			missing = append(missing, role)
+		}
+	}
+	return missing, nil
+}
\ No newline at end of file
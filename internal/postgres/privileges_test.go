@@ -0,0 +1,115 @@
+package postgres
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTokenizeACL(t *testing.T) {
+	tests := []struct {
+		stmt string
+		want []string
+	}{
+		{`GRANT SELECT ON TABLE public.orders TO app`,
+			[]string{"GRANT", "SELECT", "ON", "TABLE", "public", ".", "orders", "TO", "app"}},
+		{`GRANT USAGE ON SCHEMA "My Schema" TO "Mixed""Case"`,
+			[]string{"GRANT", "USAGE", "ON", "SCHEMA", "My Schema", "TO", `Mixed"Case`}},
+		{"GRANT ALL ON FUNCTION f(integer, text)\nTO app_$1",
+			[]string{"GRANT", "ALL", "ON", "FUNCTION", "f", "(", "integer", ",", "text", ")", "TO", "app_$1"}},
+	}
+
+	for _, tt := range tests {
+		var got []string
+		for _, tok := range tokenizeACL(tt.stmt) {
+			got = append(got, tok.text)
+			if !tok.quoted && tt.stmt[tok.start:tok.end] != tok.text {
+				t.Errorf("tokenizeACL(%q): token %q spans %q", tt.stmt, tok.text, tt.stmt[tok.start:tok.end])
+			}
+		}
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("tokenizeACL(%q) = %q, want %q", tt.stmt, got, tt.want)
+		}
+	}
+}
+
+func TestACLRoleRefs(t *testing.T) {
+	tests := []struct {
+		stmt string
+		want []string
+	}{
+		{`GRANT SELECT ON TABLE public.orders TO app`, []string{"app"}},
+		{`GRANT SELECT ON TABLE public.orders TO "App"`, []string{"App"}},
+		{`GRANT SELECT ON TABLE public.orders TO App, "Other Role"`, []string{"app", "Other Role"}},
+		{`GRANT SELECT ON TABLE public.orders TO GROUP staff`, []string{"staff"}},
+		{`GRANT USAGE ON SCHEMA public TO PUBLIC`, nil},
+		{`GRANT USAGE ON SCHEMA public TO "public"`, []string{"public"}},
+		{`REVOKE ALL ON SCHEMA public FROM PUBLIC`, nil},
+		{`ALTER DEFAULT PRIVILEGES FOR ROLE a, "B" IN SCHEMA public GRANT SELECT ON TABLES TO c`,
+			[]string{"a", "B", "c"}},
+		{`ALTER DEFAULT PRIVILEGES FOR USER owner REVOKE ALL ON FUNCTIONS FROM PUBLIC`, []string{"owner"}},
+		{`ALTER DEFAULT PRIVILEGES IN SCHEMA public GRANT USAGE ON SEQUENCES TO app`, []string{"app"}},
+		{`GRANT ALL ON FUNCTION public.convert(text, "to" integer) TO app`, []string{"app"}},
+		{`REVOKE ALL ON FUNCTION public.f(a integer, b text) FROM app`, []string{"app"}},
+		{`GRANT SELECT ON TABLE public.orders TO app GRANTED BY "Owner"`, []string{"app", "Owner"}},
+		{`GRANT SELECT ON TABLE public.orders TO app WITH GRANT OPTION`, []string{"app"}},
+		{`GRANT SELECT ON TABLE public.orders TO app WITH GRANT OPTION GRANTED BY owner`, []string{"app", "owner"}},
+		{`REVOKE GRANT OPTION FOR SELECT ON TABLE public.orders FROM app CASCADE`, []string{"app"}},
+		{`GRANT SELECT (id, "From") ON TABLE public.orders TO app`, []string{"app"}},
+		{`GRANT reader TO app`, []string{"app"}},
+		{`GRANT SELECT ON TABLE public.orders TO app GRANTED BY CURRENT_USER`, []string{"app"}},
+		{`GRANT SELECT ON TABLE public.orders TO "current_user"`, []string{"current_user"}},
+	}
+
+	for _, tt := range tests {
+		var got []string
+		for _, ref := range aclRoleRefs(tt.stmt) {
+			got = append(got, ref.name)
+		}
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("aclRoleRefs(%q) = %q, want %q", tt.stmt, got, tt.want)
+		}
+	}
+}
+
+func TestMapACLRoles(t *testing.T) {
+	roles := map[string]string{
+		"app":      "app_rw",
+		"Owner":    "Target Owner",
+		"staff":    "team",
+		"a":        "owner_a",
+		"reporter": "reader",
+	}
+
+	tests := []struct {
+		stmt string
+		want string
+	}{
+		{`GRANT SELECT ON TABLE public.orders TO app`,
+			`GRANT SELECT ON TABLE public.orders TO "app_rw"`},
+		{`GRANT SELECT ON TABLE public.orders TO APP, other`,
+			`GRANT SELECT ON TABLE public.orders TO "app_rw", other`},
+		{`GRANT SELECT ON TABLE public.orders TO "APP"`,
+			`GRANT SELECT ON TABLE public.orders TO "APP"`},
+		{`GRANT SELECT ON TABLE public.orders TO GROUP staff`,
+			`GRANT SELECT ON TABLE public.orders TO GROUP "team"`},
+		{`GRANT USAGE ON SCHEMA public TO PUBLIC`,
+			`GRANT USAGE ON SCHEMA public TO PUBLIC`},
+		{`ALTER DEFAULT PRIVILEGES FOR ROLE a, "Owner" IN SCHEMA public GRANT SELECT ON TABLES TO app`,
+			`ALTER DEFAULT PRIVILEGES FOR ROLE "owner_a", "Target Owner" IN SCHEMA public GRANT SELECT ON TABLES TO "app_rw"`},
+		{`GRANT ALL ON FUNCTION public.app(app integer) TO app`,
+			`GRANT ALL ON FUNCTION public.app(app integer) TO "app_rw"`},
+		{`GRANT SELECT ON TABLE public.orders TO app GRANTED BY "Owner"`,
+			`GRANT SELECT ON TABLE public.orders TO "app_rw" GRANTED BY "Target Owner"`},
+		{`REVOKE GRANT OPTION FOR SELECT ON TABLE public.app FROM app CASCADE`,
+			`REVOKE GRANT OPTION FOR SELECT ON TABLE public.app FROM "app_rw" CASCADE`},
+		{`GRANT SELECT ON TABLE public.orders TO reporter WITH GRANT OPTION`,
+			`GRANT SELECT ON TABLE public.orders TO "reader" WITH GRANT OPTION`},
+	}
+
+	for _, tt := range tests {
+		got := MapACLRoles([]string{tt.stmt}, roles)
+		if len(got) != 1 || got[0] != tt.want {
+			t.Errorf("MapACLRoles(%q) = %q, want %q", tt.stmt, got, tt.want)
+		}
+	}
+}
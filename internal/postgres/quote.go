@@ -0,0 +1,36 @@
+package postgres
+
+import (
+	"strings"
+)
+
+// quoteIdent quotes a name as an SQL identifier, so that mixed case,
+// reserved words, spaces and quotes are kept as they are
+func quoteIdent(name string) string {
+	name = strings.ReplaceAll(name, "\x00", "")
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// quoteQualified quotes each part of a qualified name, e.g. schema.table.
+// Empty parts are left out.
+func quoteQualified(parts ...string) string {
+	quoted := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part != "" {
+			quoted = append(quoted, quoteIdent(part))
+		}
+	}
+	return strings.Join(quoted, ".")
+}
+
+// quoteLiteral quotes a string as an SQL literal. Like quote_literal, a
+// string containing backslashes uses the E'' form so that it is read the
+// same whatever standard_conforming_strings is set to.
+func quoteLiteral(s string) string {
+	s = strings.ReplaceAll(s, "\x00", "")
+	quoted := "'" + strings.ReplaceAll(s, "'", "''") + "'"
+	if strings.Contains(s, `\`) {
+		return "E" + strings.ReplaceAll(quoted, `\`, `\\`)
+	}
+	return quoted
+}
\ No newline at end of file
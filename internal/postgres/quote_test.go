@@ -0,0 +1,118 @@
+package postgres
+
+import (
+	"testing"
+)
+
+func TestQuoteIdent(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"orders", `"orders"`},
+		{"Order", `"Order"`},
+		{"user", `"user"`},
+		{"order items", `"order items"`},
+		{`say "hi"`, `"say ""hi"""`},
+		{`x"; DROP TABLE users; --`, `"x""; DROP TABLE users; --"`},
+		{"nul\x00byte", `"nulbyte"`},
+		{"", `""`},
+	}
+
+	for _, tt := range tests {
+		if got := quoteIdent(tt.name); got != tt.want {
+			t.Errorf("quoteIdent(%q) = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestQuoteQualified(t *testing.T) {
+	tests := []struct {
+		parts []string
+		want  string
+	}{
+		{[]string{"public", "Order"}, `"public"."Order"`},
+		{[]string{"My Schema", "user"}, `"My Schema"."user"`},
+		{[]string{"", "orders"}, `"orders"`},
+	}
+
+	for _, tt := range tests {
+		if got := quoteQualified(tt.parts...); got != tt.want {
+			t.Errorf("quoteQualified(%q) = %s, want %s", tt.parts, got, tt.want)
+		}
+	}
+}
+
+func TestQuoteLiteral(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"secret", `'secret'`},
+		{"it's", `'it''s'`},
+		{`'; ALTER USER app SUPERUSER; --`, `'''; ALTER USER app SUPERUSER; --'`},
+		{`back\slash`, `E'back\\slash'`},
+		{`a\'b`, `E'a\\''b'`},
+		{"", `''`},
+	}
+
+	for _, tt := range tests {
+		if got := quoteLiteral(tt.value); got != tt.want {
+			t.Errorf("quoteLiteral(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestCreateUserSQL(t *testing.T) {
+	tests := []struct {
+		user     string
+		password string
+		want     string
+	}{
+		{"app_user", "secret", `CREATE USER "app_user" WITH PASSWORD 'secret'`},
+		{"user", "pa'ss", `CREATE USER "user" WITH PASSWORD 'pa''ss'`},
+		{"App User", `x'; DROP ROLE postgres; --`, `CREATE USER "App User" WITH PASSWORD 'x''; DROP ROLE postgres; --'`},
+	}
+
+	for _, tt := range tests {
+		if got := createUserSQL(tt.user, tt.password); got != tt.want {
+			t.Errorf("createUserSQL(%q, %q) = %s, want %s", tt.user, tt.password, got, tt.want)
+		}
+	}
+}
+
+func TestAlterOwnerSQL(t *testing.T) {
+	got := alterOwnerSQL("TABLE", quoteQualified("public", "Order"), "user")
+	want := `ALTER TABLE "public"."Order" OWNER TO "user"`
+	if got != want {
+		t.Errorf("alterOwnerSQL() = %s, want %s", got, want)
+	}
+}
+
+func TestGrantStatements(t *testing.T) {
+	got := grantStatements("My DB", "public", "user")
+	want := []string{
+		`GRANT ALL PRIVILEGES ON DATABASE "My DB" TO "user"`,
+		`GRANT ALL ON SCHEMA "public" TO "user"`,
+		`GRANT ALL PRIVILEGES ON ALL TABLES IN SCHEMA "public" TO "user"`,
+		`GRANT ALL PRIVILEGES ON ALL SEQUENCES IN SCHEMA "public" TO "user"`,
+		`GRANT ALL PRIVILEGES ON ALL FUNCTIONS IN SCHEMA "public" TO "user"`,
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("grantStatements() returned %d statements, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("grantStatements()[%d] = %s, want %s", i, got[i], want[i])
+		}
+	}
+}
+
+func TestDefaultPrivilegeStatements(t *testing.T) {
+	got := defaultPrivilegeStatements("Sales", `app"user`)
+	want := `ALTER DEFAULT PRIVILEGES IN SCHEMA "Sales" GRANT ALL ON TABLES TO "app""user"`
+	if len(got) == 0 || got[0] != want {
+		t.Errorf("defaultPrivilegeStatements()[0] = %v, want %s", got, want)
+	}
+}
\ No newline at end of file
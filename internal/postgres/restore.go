@@ -2,9 +2,12 @@ package postgres
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"strings"
+
+	"github.com/1CL0UD/cloudm-cli/internal/encryption"
 )
 
 type RestoreOptions struct {
@@ -18,24 +21,96 @@ type RestoreOptions struct {
 	ParallelJobs  int
 	StructureOnly bool
 	DataOnly      bool
+	LargeObjects  bool
+	ListFile      string
+	Clean         bool
+	Decryptor     *encryption.Decryptor
+	Diagnostics   *DiagnosticLog
+	Section       string
+
+	SingleTransaction bool
 }
 
 // RestoreStructure restores database structure (schema only)
 func RestoreStructure(opts RestoreOptions) error {
-	args := buildRestoreArgs(opts, true, false)
-	return runPgRestore(args, opts.Password)
+	return restoreArchive(opts, true, false)
 }
 
 // RestoreData restores database data only
 func RestoreData(opts RestoreOptions) error {
-	args := buildRestoreArgs(opts, false, true)
-	return runPgRestore(args, opts.Password)
+	return restoreArchive(opts, false, true)
+}
+
+// RestoreSection restores one section of a dump: pre-data (schema objects
+// needed before loading data), data, or post-data (indexes, constraints,
+// triggers). Running post-data after data avoids maintaining indexes and
+// checking constraints while rows are loaded.
+func RestoreSection(opts RestoreOptions, section string) error {
+	opts.Section = section
+	return restoreArchive(opts, false, false)
 }
 
 // RestoreFull restores both structure and data
 func RestoreFull(opts RestoreOptions) error {
-	args := buildRestoreArgs(opts, false, false)
-	return runPgRestore(args, opts.Password)
+	return restoreArchive(opts, false, false)
+}
+
+// restoreArchive runs pg_restore on the input file, streaming it through
+// stdin when it has to be decrypted on the fly
+func restoreArchive(opts RestoreOptions, structureOnly, dataOnly bool) error {
+	stdin, closeInput, err := openArchive(opts.InputFile, opts.Decryptor)
+	if err != nil {
+		return err
+	}
+	defer closeInput()
+
+	if stdin != nil {
+		// pg_restore cannot run parallel jobs from stdin
+		opts.ParallelJobs = 0
+		opts.InputFile = ""
+	}
+
+	args := buildRestoreArgs(opts, structureOnly, dataOnly)
+
+	diagnostics := opts.Diagnostics
+	if diagnostics == nil {
+		rules, err := CompileIgnoreRules(nil)
+		if err != nil {
+			return err
+		}
+		diagnostics = NewDiagnosticLog(rules)
+	}
+
+	return runPgRestore(args, opts.Password, stdin, diagnostics)
+}
+
+// openArchive returns a plaintext stream for an encrypted dump file, or a
+// nil reader when pg_restore can read the file directly
+func openArchive(path string, dec *encryption.Decryptor) (io.Reader, func(), error) {
+	noop := func() {}
+	if dec == nil {
+		return nil, noop, nil
+	}
+
+	encrypted, err := encryption.IsEncrypted(path)
+	if err != nil {
+		return nil, noop, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if !encrypted {
+		return nil, noop, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, noop, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	r, err := dec.Decrypt(f)
+	if err != nil {
+		f.Close()
+		return nil, noop, fmt.Errorf("failed to decrypt %s: %w", path, err)
+	}
+
+	return r, func() { f.Close() }, nil
 }
 
 // buildRestoreArgs builds pg_restore command arguments
@@ -47,8 +122,10 @@ func buildRestoreArgs(opts RestoreOptions, structureOnly, dataOnly bool) []strin
 		"-d", opts.Database,
 	}
 
-	// Add schema restriction if specified
-	if opts.Schema != "" {
+	// Add schema restriction if specified. Large object entries have no
+	// schema and would be filtered out by -n, so the restriction is left to
+	// the dump itself when they must be restored.
+	if opts.Schema != "" && !opts.LargeObjects {
 		args = append(args, "-n", opts.Schema)
 	}
 
@@ -62,58 +139,109 @@ func buildRestoreArgs(opts RestoreOptions, structureOnly, dataOnly bool) []strin
 		args = append(args, "-a")
 	}
 
+	// Restrict to one section of the dump
+	if opts.Section != "" {
+		args = append(args, "--section="+opts.Section)
+	}
+
 	// Parallel jobs
 	if opts.ParallelJobs > 0 {
 		args = append(args, "-j", fmt.Sprintf("%d", opts.ParallelJobs))
 	}
 
+	// Restrict to the entries of a TOC list file
+	if opts.ListFile != "" {
+		args = append(args, "-L", opts.ListFile)
+	}
+
+	// Drop restored objects before recreating them
+	if opts.Clean {
+		args = append(args, "--clean", "--if-exists")
+	}
+
+	// Restore everything or nothing
+	if opts.SingleTransaction {
+		args = append(args, "--single-transaction")
+	}
+
 	// Don't restore ownership or privileges (we handle this separately)
 	args = append(args, "--no-owner", "--no-privileges")
 
-	// Input file
-	args = append(args, opts.InputFile)
+	// Input file; without one pg_restore reads the archive from stdin
+	if opts.InputFile != "" {
+		args = append(args, opts.InputFile)
+	}
 
 	return args
 }
 
-// runPgRestore executes pg_restore with the given arguments
-func runPgRestore(args []string, password string) error {
+// runPgRestore executes pg_restore with the given arguments, feeding it
+// the archive on stdin when one is given. Its stderr is classified into
+// diagnostics; the run fails on errors no ignore rule accepts, or when
+// pg_restore fails without reporting any.
+func runPgRestore(args []string, password string, stdin io.Reader, diagnostics *DiagnosticLog) error {
 	cmd := exec.Command("pg_restore", args...)
 	cmd.Env = append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", password))
+	cmd.Stdin = stdin
 
 	var stderr strings.Builder
 	cmd.Stderr = &stderr
 
-	if err := cmd.Run(); err != nil {
-		// pg_restore often returns warnings that aren't fatal
-		stderrStr := stderr.String()
-		if strings.Contains(stderrStr, "error") || strings.Contains(stderrStr, "FATAL") {
-			return fmt.Errorf("pg_restore failed: %w\nstderr: %s", err, stderrStr)
+	runErr := cmd.Run()
+
+	parsed := ParseRestoreOutput(stderr.String())
+	failures := diagnostics.Add(parsed)
+
+	if len(failures) > 0 {
+		var sb strings.Builder
+		limit := len(failures)
+		if limit > 5 {
+			limit = 5
+		}
+		for _, d := range failures[:limit] {
+			sb.WriteString("\n  " + d.String())
+		}
+		if len(failures) > limit {
+			fmt.Fprintf(&sb, "\n  ... and %d more", len(failures)-limit)
 		}
+		return fmt.Errorf("pg_restore reported %d errors:%s", len(failures), sb.String())
+	}
+
+	// pg_restore exits non-zero when errors occurred, even ignored ones;
+	// only a failure without any reported error is unexplained
+	if runErr != nil && !hasErrors(parsed) {
+		return fmt.Errorf("pg_restore failed: %w\nstderr: %s", runErr, stderr.String())
 	}
 
 	return nil
 }
 
-// BackupDatabase creates a full backup of a database
-func BackupDatabase(host string, port int, user, password, database, outputFile string) error {
+// hasErrors reports whether any diagnostic is more severe than a warning
+func hasErrors(diagnostics []Diagnostic) bool {
+	for _, d := range diagnostics {
+		if d.Severity != SeverityWarning {
+			return true
+		}
+	}
+	return false
+}
+
+// BackupDatabase creates a full backup of a database, encrypted when an
+// encryptor is given
+func BackupDatabase(host string, port int, user, password, database, outputFile string, enc *encryption.Encryptor) error {
 	args := []string{
 		"-h", host,
 		"-p", fmt.Sprintf("%d", port),
 		"-U", user,
 		"-d", database,
 		"-Fc",
-		"-f", outputFile,
+	}
+	if enc == nil {
+		args = append(args, "-f", outputFile)
 	}
 
-	cmd := exec.Command("pg_dump", args...)
-	cmd.Env = append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", password))
-
-	var stderr strings.Builder
-	cmd.Stderr = &stderr
-
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("backup failed: %w\nstderr: %s", err, stderr.String())
+	if err := runDumpCommand(args, password, outputFile, enc); err != nil {
+		return fmt.Errorf("backup failed: %w", err)
 	}
 
 	return nil
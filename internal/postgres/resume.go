@@ -0,0 +1,354 @@
+package postgres
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/1CL0UD/cloudm-cli/internal/config"
+	"github.com/jackc/pgx/v5"
+)
+
+const restoreStateVersion = 1
+
+// RestoreState records the progress of a restore in a state file so that an
+// interrupted restore can be resumed where it stopped
+type RestoreState struct {
+	Version     int       `json:"version"`
+	Target      string    `json:"target"`
+	Fingerprint string    `json:"fingerprint"`
+	DataOnly    bool      `json:"data_only"`
+	PreDataDone bool      `json:"pre_data_done"`
+	Completed   []int     `json:"completed"`
+	StartedAt   time.Time `json:"started_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+
+	path      string
+	completed map[int]bool
+}
+
+// NewRestoreState creates the state of a new restore, stored at path
+func NewRestoreState(path, target, fingerprint string) *RestoreState {
+	return &RestoreState{
+		Version:     restoreStateVersion,
+		Target:      target,
+		Fingerprint: fingerprint,
+		StartedAt:   time.Now(),
+		path:        path,
+		completed:   make(map[int]bool),
+	}
+}
+
+// LoadRestoreState reads a state file written by a previous restore
+func LoadRestoreState(path string) (*RestoreState, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read restore state: %w", err)
+	}
+
+	var state RestoreState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse restore state %s: %w", path, err)
+	}
+	if state.Version != restoreStateVersion {
+		return nil, fmt.Errorf("unsupported restore state version %d in %s", state.Version, path)
+	}
+
+	state.path = path
+	state.completed = make(map[int]bool, len(state.Completed))
+	for _, id := range state.Completed {
+		state.completed[id] = true
+	}
+
+	return &state, nil
+}
+
+// Save writes the state file, replacing the previous one atomically
+func (s *RestoreState) Save() error {
+	s.UpdatedAt = time.Now()
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode restore state: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write restore state: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write restore state: %w", err)
+	}
+
+	return nil
+}
+
+// Remove deletes the state file once the restore has completed
+func (s *RestoreState) Remove() error {
+	if err := os.Remove(s.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove restore state: %w", err)
+	}
+	return nil
+}
+
+// Path returns the location of the state file
+func (s *RestoreState) Path() string {
+	return s.path
+}
+
+// IsCompleted reports whether an entry has been restored
+func (s *RestoreState) IsCompleted(dumpID int) bool {
+	return s.completed[dumpID]
+}
+
+// MarkCompleted records an entry as restored and saves the state
+func (s *RestoreState) MarkCompleted(dumpID int) error {
+	if !s.completed[dumpID] {
+		s.completed[dumpID] = true
+		s.Completed = append(s.Completed, dumpID)
+	}
+	return s.Save()
+}
+
+// unmark records an entry as pending again
+func (s *RestoreState) unmark(dumpID int) {
+	delete(s.completed, dumpID)
+	for i, id := range s.Completed {
+		if id == dumpID {
+			s.Completed = append(s.Completed[:i], s.Completed[i+1:]...)
+			break
+		}
+	}
+}
+
+// RestoreTarget identifies a target database in a restore state
+func RestoreTarget(cfg config.TargetConfig) string {
+	return fmt.Sprintf("%s:%d/%s", cfg.Host, cfg.Port, cfg.Database)
+}
+
+// TOCFingerprint identifies the contents of a dump by its entries, so that
+// a restore is not resumed from a different dump
+func TOCFingerprint(entries []TOCEntry) string {
+	h := sha256.New()
+	for _, e := range entries {
+		fmt.Fprintf(h, "%d %d %d %s %s %s\n", e.DumpID, e.CatalogOID, e.ObjectOID, e.Type, e.Schema, e.Name)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// DataEntries returns the data section entries of a dump, limited to a
+// schema when one is given. Large objects belong to no schema and are kept.
+func DataEntries(entries []TOCEntry, schema string) []TOCEntry {
+	var result []TOCEntry
+	for _, e := range entries {
+		if e.Section != SectionData {
+			continue
+		}
+		if schema != "" && e.Schema != schema && !largeObjectTypes[e.Type] {
+			continue
+		}
+		result = append(result, e)
+	}
+	return result
+}
+
+// PendingEntries returns the entries not yet restored
+func PendingEntries(entries []TOCEntry, state *RestoreState) []TOCEntry {
+	var pending []TOCEntry
+	for _, e := range entries {
+		if !state.IsCompleted(e.DumpID) {
+			pending = append(pending, e)
+		}
+	}
+	return pending
+}
+
+// VerifyResume checks a restore state against the target before resuming.
+// Tables recorded as loaded but missing on the target are marked pending
+// again. Pending tables that already hold rows, e.g. because the previous
+// run stopped right after a load committed, are truncated so they can be
+// loaded again without duplicates.
+func VerifyResume(ctx context.Context, cfg config.TargetConfig, entries []TOCEntry, state *RestoreState) (reloaded []TOCEntry, truncated []string, err error) {
+	connStr := GetTargetConnectionString(cfg)
+	conn, err := pgx.Connect(ctx, connStr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	for _, e := range entries {
+		if e.Type != "TABLE DATA" {
+			continue
+		}
+		table := quoteQualified(e.Schema, e.Name)
+
+		var exists bool
+		if err := conn.QueryRow(ctx, "SELECT to_regclass($1) IS NOT NULL", table).Scan(&exists); err != nil {
+			return nil, nil, fmt.Errorf("failed to check table %s: %w", table, err)
+		}
+		if !exists {
+			if state.IsCompleted(e.DumpID) {
+				state.unmark(e.DumpID)
+				reloaded = append(reloaded, e)
+			}
+			continue
+		}
+
+		if state.IsCompleted(e.DumpID) {
+			continue
+		}
+
+		var hasRows bool
+		if err := conn.QueryRow(ctx, fmt.Sprintf("SELECT EXISTS (SELECT 1 FROM %s)", table)).Scan(&hasRows); err != nil {
+			return nil, nil, fmt.Errorf("failed to check table %s: %w", table, err)
+		}
+		if hasRows {
+			truncated = append(truncated, table)
+		}
+	}
+
+	if len(reloaded) > 0 {
+		if err := state.Save(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Without CASCADE, a table referenced by already loaded data makes this
+	// fail rather than silently emptying tables recorded as complete
+	if len(truncated) > 0 {
+		if _, err := conn.Exec(ctx, "TRUNCATE "+strings.Join(truncated, ", ")); err != nil {
+			return nil, nil, fmt.Errorf("failed to truncate partially loaded tables: %w", err)
+		}
+	}
+
+	return reloaded, truncated, nil
+}
+
+// RestoreDataEntries restores data entries one by one, each in its own
+// transaction, recording every completed entry in the restore state. Up to
+// opts.ParallelJobs entries run at once; an entry starts only after the
+// entries it depends on, which keeps foreign key order. After a failure no
+// new entries are started and the first error is returned.
+func RestoreDataEntries(ctx context.Context, opts RestoreOptions, entries []TOCEntry, state *RestoreState, progress func(TOCEntry)) error {
+	workers := opts.ParallelJobs
+	if workers < 1 {
+		workers = 1
+	}
+
+	// The entries are already limited to the wanted schema, and -n would
+	// drop large objects
+	opts.Schema = ""
+	opts.ParallelJobs = 0
+	opts.SingleTransaction = true
+
+	inSet := make(map[int]bool, len(entries))
+	for _, e := range entries {
+		inSet[e.DumpID] = true
+	}
+
+	ready := func(e TOCEntry) bool {
+		for _, dep := range e.Dependencies {
+			if inSet[dep] && dep != e.DumpID && !state.IsCompleted(dep) {
+				return false
+			}
+		}
+		return true
+	}
+
+	type result struct {
+		entry TOCEntry
+		err   error
+	}
+	results := make(chan result)
+
+	pending := append([]TOCEntry(nil), entries...)
+	running := 0
+	var firstErr error
+
+	for {
+		// Start every ready entry while workers are free
+		for i := 0; firstErr == nil && running < workers && i < len(pending); {
+			e := pending[i]
+			if !ready(e) {
+				i++
+				continue
+			}
+			pending = append(pending[:i], pending[i+1:]...)
+			running++
+			go func(e TOCEntry) {
+				results <- result{entry: e, err: restoreEntry(ctx, opts, e)}
+			}(e)
+		}
+
+		if running == 0 {
+			break
+		}
+
+		r := <-results
+		running--
+		if r.err != nil {
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			continue
+		}
+		if err := state.MarkCompleted(r.entry.DumpID); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if progress != nil {
+			progress(r.entry)
+		}
+	}
+
+	if firstErr != nil {
+		return firstErr
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%d data entries could not be scheduled because of unresolved dependencies", len(pending))
+	}
+	return nil
+}
+
+// restoreEntry restores a single TOC entry through a one-line list file
+func restoreEntry(ctx context.Context, opts RestoreOptions, e TOCEntry) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp("", "cloudm-entry-*.list")
+	if err != nil {
+		return fmt.Errorf("failed to create restore list: %w", err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	if err := WriteTOCList([]TOCEntry{e}, f.Name()); err != nil {
+		return err
+	}
+	opts.ListFile = f.Name()
+
+	if err := restoreArchive(opts, false, true); err != nil {
+		return fmt.Errorf("failed to restore %s %s: %w", e.Type, qualifiedEntryName(e), err)
+	}
+	return nil
+}
+
+// qualifiedEntryName returns schema.name of an entry, or its name alone
+func qualifiedEntryName(e TOCEntry) string {
+	if e.Schema == "" {
+		return e.Name
+	}
+	return e.Schema + "." + e.Name
+}
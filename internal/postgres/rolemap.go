@@ -0,0 +1,184 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/1CL0UD/cloudm-cli/internal/config"
+	"github.com/jackc/pgx/v5"
+)
+
+// ObjectKey identifies an object on both source and target by its kind as
+// used in ALTER statements, its schema, and its name as shown by
+// pg_restore -l, which for routines includes the argument types
+type ObjectKey struct {
+	Kind   string
+	Schema string
+	Name   string
+}
+
+// RoleMapping chooses the target owner of each object from the owner of
+// the same object on the source
+type RoleMapping struct {
+	// Default owns objects whose source owner is unknown or not mapped
+	Default string
+	// Roles maps source owners to target roles
+	Roles map[string]string
+	// SourceOwners holds the owner of each source object
+	SourceOwners map[ObjectKey]string
+	// LargeObjectOwners are the roles owning large objects on the source
+	LargeObjectOwners []string
+	// KeepSourceOwners gives unmapped objects their source owner instead
+	// of the default role
+	KeepSourceOwners bool
+	// OwnersOnly leaves privileges alone, for restores that give back
+	// those recorded in the dump
+	OwnersOnly bool
+	// Schemas are the schemas whose objects change owner
+	Schemas []string
+}
+
+// NewRoleMapping builds the role mapping of the configuration. The default
+// role is the app user unless role_map names another one.
+func NewRoleMapping(cfg config.RoleMapConfig, appUser string) RoleMapping {
+	mapping := RoleMapping{Default: appUser, Roles: cfg.Roles, Schemas: cfg.Schemas}
+	if cfg.Default != "" {
+		mapping.Default = cfg.Default
+	}
+	if len(mapping.Schemas) == 0 {
+		mapping.Schemas = []string{"public"}
+	}
+	return mapping
+}
+
+// OwnerFor returns the target owner of an object
+func (m RoleMapping) OwnerFor(key ObjectKey) string {
+	source, ok := m.SourceOwners[key]
+	if !ok {
+		return m.Default
+	}
+	if role, ok := m.Roles[source]; ok {
+		return role
+	}
+	if m.KeepSourceOwners {
+		return source
+	}
+	return m.Default
+}
+
+// LargeObjectOwner returns the target owner of the large objects. They
+// change owner together, so ok is false when their source owners map to
+// several roles.
+func (m RoleMapping) LargeObjectOwner() (owner string, ok bool) {
+	owner = m.Default
+	for i, source := range m.LargeObjectOwners {
+		role, mapped := m.Roles[source]
+		switch {
+		case mapped:
+		case m.KeepSourceOwners:
+			role = source
+		default:
+			role = m.Default
+		}
+		if i > 0 && role != owner {
+			return "", false
+		}
+		owner = role
+	}
+	return owner, true
+}
+
+// OwnershipSchemas returns the schemas whose objects change owner or are
+// granted privileges
+func OwnershipSchemas(mapping RoleMapping, grants []GrantRule) []string {
+	schemas := append([]string(nil), mapping.Schemas...)
+	for _, r := range grants {
+		for _, schema := range r.Schemas {
+			schemas = appendUnique(schemas, schema)
+		}
+	}
+	return schemas
+}
+
+// OwnersFromTOC returns the owners recorded in the entries of a dump
+func OwnersFromTOC(entries []TOCEntry) map[ObjectKey]string {
+	owners := make(map[ObjectKey]string)
+	for _, e := range entries {
+		if e.Owner == "" {
+			continue
+		}
+		owners[ObjectKey{Kind: e.Type, Schema: e.Schema, Name: e.Name}] = e.Owner
+	}
+	return owners
+}
+
+// LargeObjectOwnersFromTOC returns the owners of the large objects of a
+// dump
+func LargeObjectOwnersFromTOC(entries []TOCEntry) []string {
+	var owners []string
+	for _, e := range entries {
+		if largeObjectTypes[e.Type] && e.Owner != "" {
+			owners = appendUnique(owners, e.Owner)
+		}
+	}
+	return owners
+}
+
+// TOCSchemas returns the given schemas followed by the other schemas the
+// entries of a dump belong to
+func TOCSchemas(entries []TOCEntry, schemas []string) []string {
+	schemas = append([]string(nil), schemas...)
+	for _, e := range entries {
+		switch {
+		case e.Type == "SCHEMA":
+			schemas = appendUnique(schemas, e.Name)
+		case e.Schema != "":
+			schemas = appendUnique(schemas, e.Schema)
+		}
+	}
+	return schemas
+}
+
+// SourceOwners reads the owners of the objects of the given schemas from
+// the source catalog
+func SourceOwners(ctx context.Context, cfg config.DatabaseConfig, schemas []string) (map[ObjectKey]string, error) {
+	connStr := GetConnectionString(cfg)
+	conn, err := pgx.Connect(ctx, connStr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	objects, err := listOwnedObjects(ctx, conn, schemas)
+	if err != nil {
+		return nil, err
+	}
+
+	owners := make(map[ObjectKey]string, len(objects))
+	for _, o := range objects {
+		owners[o.ObjectKey] = o.Owner
+	}
+	return owners, nil
+}
+
+// SourceLargeObjectOwners reads the roles owning large objects from the
+// source catalog
+func SourceLargeObjectOwners(ctx context.Context, cfg config.DatabaseConfig) ([]string, error) {
+	connStr := GetConnectionString(cfg)
+	conn, err := pgx.Connect(ctx, connStr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	largeObjects, err := listLargeObjectOwners(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	owners := make([]string, len(largeObjects))
+	for i, lo := range largeObjects {
+		owners[i] = lo.Owner
+	}
+	return owners, nil
+}
\ No newline at end of file
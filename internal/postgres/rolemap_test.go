@@ -0,0 +1,30 @@
+package postgres
+
+import "testing"
+
+func TestLargeObjectOwner(t *testing.T) {
+	roles := map[string]string{"etl_owner": "etl", "loader": "etl", "app_owner": "app"}
+
+	tests := []struct {
+		sources []string
+		keep    bool
+		want    string
+		ok      bool
+	}{
+		{nil, false, "app_user", true},
+		{[]string{"etl_owner"}, false, "etl", true},
+		{[]string{"etl_owner", "loader"}, false, "etl", true},
+		{[]string{"postgres"}, false, "app_user", true},
+		{[]string{"postgres"}, true, "postgres", true},
+		{[]string{"etl_owner", "app_owner"}, false, "", false},
+		{[]string{"etl_owner", "postgres"}, false, "", false},
+	}
+
+	for _, tt := range tests {
+		mapping := RoleMapping{Default: "app_user", Roles: roles, LargeObjectOwners: tt.sources, KeepSourceOwners: tt.keep}
+		got, ok := mapping.LargeObjectOwner()
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("LargeObjectOwner() with %q (keep %t) = %q, %t, want %q, %t", tt.sources, tt.keep, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/1CL0UD/cloudm-cli/internal/config"
+	"github.com/jackc/pgx/v5"
+)
+
+type RestoreSelection struct {
+	Tables        []string
+	ExcludeTables []string
+	ObjectTypes   []string
+}
+
+// relationTypes are TOC entry types selected by table name
+var relationTypes = map[string]bool{
+	"TABLE":                  true,
+	"TABLE DATA":             true,
+	"VIEW":                   true,
+	"MATERIALIZED VIEW":      true,
+	"MATERIALIZED VIEW DATA": true,
+	"FOREIGN TABLE":          true,
+	"SEQUENCE":               true,
+	"SEQUENCE SET":           true,
+}
+
+// attachedTypes are TOC entry types restored together with the relation
+// they depend on
+var attachedTypes = map[string]bool{
+	"TABLE DATA":             true,
+	"MATERIALIZED VIEW DATA": true,
+	"SEQUENCE OWNED BY":      true,
+	"DEFAULT":                true,
+	"INDEX":                  true,
+	"INDEX ATTACH":           true,
+	"TABLE ATTACH":           true,
+	"CONSTRAINT":             true,
+	"CHECK CONSTRAINT":       true,
+	"FK CONSTRAINT":          true,
+	"TRIGGER":                true,
+	"RULE":                   true,
+	"POLICY":                 true,
+	"ROW SECURITY":           true,
+	"STATISTICS":             true,
+	"ACL":                    true,
+	"COMMENT":                true,
+	"SECURITY LABEL":         true,
+}
+
+// infrastructureTypes are never pulled in as dependencies because a
+// selective restore runs against a target that already has them
+var infrastructureTypes = map[string]bool{
+	"ENCODING":            true,
+	"STDSTRINGS":          true,
+	"SEARCHPATH":          true,
+	"DATABASE":            true,
+	"DATABASE PROPERTIES": true,
+	"SCHEMA":              true,
+	"EXTENSION":           true,
+}
+
+// IsEmpty reports whether the selection restores the whole archive
+func (s RestoreSelection) IsEmpty() bool {
+	return len(s.Tables) == 0 && len(s.ExcludeTables) == 0 && len(s.ObjectTypes) == 0
+}
+
+// SelectTOCEntries returns the entries of an archive matched by the
+// selection, together with the objects attached to selected relations and
+// the definitions they depend on. Dependencies for which exists reports
+// true are assumed to be on the target already and are left out; a nil
+// exists includes them all. Entries keep their archive order.
+func SelectTOCEntries(entries []TOCEntry, sel RestoreSelection, exists func(TOCEntry) (bool, error)) ([]TOCEntry, error) {
+	byID := make(map[int]TOCEntry, len(entries))
+	for _, e := range entries {
+		byID[e.DumpID] = e
+	}
+
+	// Relations excluded by name, along with everything attached to them
+	excluded := make(map[int]bool)
+	for _, e := range entries {
+		if relationTypes[e.Type] && matchesAnyTable(sel.ExcludeTables, e) {
+			excluded[e.DumpID] = true
+		}
+	}
+	markAttached(entries, excluded)
+
+	selected := make(map[int]bool)
+	for _, e := range entries {
+		if excluded[e.DumpID] {
+			continue
+		}
+		if len(sel.Tables) == 0 || (relationTypes[e.Type] && matchesAnyTable(sel.Tables, e)) {
+			selected[e.DumpID] = true
+		}
+	}
+	if len(sel.Tables) > 0 {
+		markAttached(entries, selected)
+
+		// Sequences owned by selected tables come along with them
+		for _, e := range entries {
+			if e.Type == "SEQUENCE OWNED BY" && selected[e.DumpID] {
+				for _, dep := range e.Dependencies {
+					if byID[dep].Type == "SEQUENCE" {
+						selected[dep] = true
+					}
+				}
+			}
+		}
+	}
+	for id := range excluded {
+		delete(selected, id)
+	}
+
+	// Restrict to the requested object types
+	if len(sel.ObjectTypes) > 0 {
+		filter := TOCFilter{Types: sel.ObjectTypes}
+		for id := range selected {
+			if !filter.Matches(byID[id]) {
+				delete(selected, id)
+			}
+		}
+	}
+
+	// Pull in the definitions selected entries depend on
+	queue := make([]int, 0, len(selected))
+	for id := range selected {
+		queue = append(queue, id)
+	}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, dep := range byID[id].Dependencies {
+			d, ok := byID[dep]
+			if !ok || selected[dep] || excluded[dep] || infrastructureTypes[d.Type] {
+				continue
+			}
+			if d.Section != SectionPreData {
+				continue
+			}
+			if exists != nil {
+				found, err := exists(d)
+				if err != nil {
+					return nil, err
+				}
+				if found {
+					continue
+				}
+			}
+			selected[dep] = true
+			queue = append(queue, dep)
+		}
+	}
+
+	// Comments, ACLs and security labels follow their objects
+	for _, e := range entries {
+		if inheritedSectionTypes[e.Type] && !selected[e.DumpID] && dependsOnAny(e, selected) {
+			selected[e.DumpID] = true
+		}
+	}
+
+	var result []TOCEntry
+	for _, e := range entries {
+		if selected[e.DumpID] {
+			result = append(result, e)
+		}
+	}
+	return result, nil
+}
+
+// TargetObjectChecker returns a function reporting whether the object of a
+// TOC entry already exists on the target. Entry types it cannot resolve are
+// reported as missing; failed lookups are returned as errors, since
+// treating them as missing would recreate, and empty, existing objects.
+// The returned close function releases the connection.
+func TargetObjectChecker(ctx context.Context, cfg config.TargetConfig) (func(TOCEntry) (bool, error), func(), error) {
+	connStr := GetTargetConnectionString(cfg)
+	conn, err := pgx.Connect(ctx, connStr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	exists := func(e TOCEntry) (bool, error) {
+		var query string
+		switch e.Type {
+		case "TABLE", "VIEW", "MATERIALIZED VIEW", "FOREIGN TABLE", "SEQUENCE", "INDEX":
+			query = "SELECT to_regclass($1) IS NOT NULL"
+		case "TYPE", "DOMAIN", "SHELL TYPE":
+			query = "SELECT to_regtype($1) IS NOT NULL"
+		case "FUNCTION", "PROCEDURE", "AGGREGATE":
+			query = "SELECT to_regprocedure($1) IS NOT NULL"
+		default:
+			return false, nil
+		}
+
+		var found bool
+		if err := conn.QueryRow(ctx, query, regName(e)).Scan(&found); err != nil {
+			return false, fmt.Errorf("failed to look up %s %s on target: %w", strings.ToLower(e.Type), e.Name, err)
+		}
+		return found, nil
+	}
+
+	return exists, func() { conn.Close(ctx) }, nil
+}
+
+// routineTypes are TOC entry types named by their signature
+var routineTypes = map[string]bool{
+	"FUNCTION":  true,
+	"PROCEDURE": true,
+	"AGGREGATE": true,
+}
+
+// regName returns the name of the object of a TOC entry as read by
+// to_regclass and the like. The TOC holds names unquoted, except for the
+// argument types of routine signatures, which pg_dump already quotes.
+func regName(e TOCEntry) string {
+	name, args := e.Name, ""
+	if routineTypes[e.Type] {
+		if i := strings.Index(e.Name, "("); i >= 0 {
+			name, args = e.Name[:i], e.Name[i:]
+		}
+	}
+	return quoteQualified(e.Schema, name) + args
+}
+
+// RelationNames returns the qualified names of relations in a set of entries
+func RelationNames(entries []TOCEntry) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, e := range entries {
+		if !relationTypes[e.Type] {
+			continue
+		}
+		name := e.Name
+		if e.Schema != "" {
+			name = e.Schema + "." + e.Name
+		}
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// WriteTOCList writes entries as a pg_restore -L list file
+func WriteTOCList(entries []TOCEntry, path string) error {
+	var sb strings.Builder
+	sb.WriteString(";\n; Selective restore list generated by cloudm-cli\n;\n")
+	for _, e := range entries {
+		schema := e.Schema
+		if schema == "" {
+			schema = "-"
+		}
+		sb.WriteString(fmt.Sprintf("%d; %d %d %s %s %s %s\n",
+			e.DumpID, e.CatalogOID, e.ObjectOID, e.Type, schema, e.Name, e.Owner))
+	}
+
+	if err := os.WriteFile(path, []byte(sb.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write restore list: %w", err)
+	}
+	return nil
+}
+
+// markAttached adds entries attached to any marked entry, repeatedly, so
+// that e.g. the comment on an index of a marked table is marked as well
+func markAttached(entries []TOCEntry, marked map[int]bool) {
+	for changed := true; changed; {
+		changed = false
+		for _, e := range entries {
+			if !marked[e.DumpID] && attachedTypes[e.Type] && dependsOnAny(e, marked) {
+				marked[e.DumpID] = true
+				changed = true
+			}
+		}
+	}
+}
+
+// dependsOnAny reports whether an entry depends on any marked entry
+func dependsOnAny(e TOCEntry, marked map[int]bool) bool {
+	for _, dep := range e.Dependencies {
+		if marked[dep] {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesAnyTable matches an entry against "table" or "schema.table"
+// patterns
+func matchesAnyTable(patterns []string, e TOCEntry) bool {
+	for _, p := range patterns {
+		if schema, name, ok := strings.Cut(p, "."); ok {
+			if matchPattern(schema, e.Schema) && matchPattern(name, e.Name) {
+				return true
+			}
+		} else if matchPattern(p, e.Name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,246 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/1CL0UD/cloudm-cli/internal/config"
+	"github.com/jackc/pgx/v5"
+)
+
+// drainInterval is how often remaining sessions are checked while draining
+const drainInterval = 5 * time.Second
+
+// Session is a client session connected to a database
+type Session struct {
+	PID             int32
+	User            string
+	ApplicationName string
+	State           string
+	QueryAge        time.Duration
+	Database        string
+	ClientAddr      string
+	Started         time.Time
+}
+
+// ListSessions returns the sessions connected to a database, other than
+// the caller's own
+func ListSessions(ctx context.Context, cfg config.TargetConfig, database string) ([]Session, error) {
+	connStr := GetTargetPostgresConnectionString(cfg)
+	conn, err := pgx.Connect(ctx, connStr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to postgres database: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	return listSessions(ctx, conn, database)
+}
+
+// listSessions returns the sessions connected to a database using an
+// existing connection
+func listSessions(ctx context.Context, conn *pgx.Conn, database string) ([]Session, error) {
+	rows, err := conn.Query(ctx, sessionColumns+`
+		WHERE datname = $1 AND pid <> pg_backend_pid()
+		ORDER BY pid`, database)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	sessions, err := pgx.CollectRows(rows, scanSession)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	return sessions, nil
+}
+
+// sessionColumns selects the columns read by scanSession
+const sessionColumns = `
+		SELECT pid, coalesce(usename, ''), application_name, coalesce(state, ''),
+			coalesce(extract(epoch FROM now() - query_start), 0)::float8,
+			coalesce(datname, ''), coalesce(host(client_addr), 'local'), backend_start
+		FROM pg_stat_activity`
+
+// scanSession reads a row selected by sessionColumns
+func scanSession(row pgx.CollectableRow) (Session, error) {
+	var s Session
+	var age float64
+	err := row.Scan(&s.PID, &s.User, &s.ApplicationName, &s.State, &age, &s.Database, &s.ClientAddr, &s.Started)
+	s.QueryAge = time.Duration(age * float64(time.Second))
+	return s, err
+}
+
+// ConnectGrant is a role holding CONNECT on a database; an empty role
+// stands for PUBLIC
+type ConnectGrant struct {
+	Role      string
+	Grantable bool
+}
+
+// connectGrantees selects the roles other than the current user holding
+// CONNECT on a database directly
+const connectGrantees = `
+		SELECT CASE WHEN a.grantee = 0 THEN '' ELSE pg_get_userbyid(a.grantee) END, bool_or(a.is_grantable)
+		FROM pg_database d
+		CROSS JOIN LATERAL aclexplode(coalesce(d.datacl, acldefault('d', d.datdba))) a
+		WHERE d.datname = $1 AND a.privilege_type = 'CONNECT'
+		  AND a.grantee NOT IN (SELECT oid FROM pg_roles WHERE rolname = current_user)
+		GROUP BY a.grantee
+		ORDER BY 1`
+
+// BlockConnections revokes CONNECT on a database from PUBLIC and from every
+// role holding it directly other than the admin user, so that only
+// superusers and the admin user can open new sessions. It returns the
+// grants revoked, which must be given back with AllowConnections, and the
+// roles still holding CONNECT because the admin user could not revoke
+// grants made by others.
+func BlockConnections(ctx context.Context, cfg config.TargetConfig, database string) ([]ConnectGrant, []string, error) {
+	connStr := GetTargetPostgresConnectionString(cfg)
+	conn, err := pgx.Connect(ctx, connStr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to postgres database: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	grants, err := listConnectGrants(ctx, conn, database)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var revoked []ConnectGrant
+	for _, g := range grants {
+		stmt := fmt.Sprintf("REVOKE CONNECT ON DATABASE %s FROM %s CASCADE", quoteIdent(database), connectGrantee(g.Role))
+		if _, err := conn.Exec(ctx, stmt); err != nil {
+			AllowConnections(ctx, cfg, database, revoked)
+			return nil, nil, fmt.Errorf("failed to revoke CONNECT on %s: %w", database, err)
+		}
+		revoked = append(revoked, g)
+	}
+
+	left, err := listConnectGrants(ctx, conn, database)
+	if err != nil {
+		return revoked, nil, err
+	}
+	var kept []string
+	for _, g := range left {
+		kept = append(kept, connectGrantee(g.Role))
+	}
+
+	return revoked, kept, nil
+}
+
+// AllowConnections gives back the CONNECT grants revoked by
+// BlockConnections
+func AllowConnections(ctx context.Context, cfg config.TargetConfig, database string, grants []ConnectGrant) error {
+	if len(grants) == 0 {
+		return nil
+	}
+
+	connStr := GetTargetPostgresConnectionString(cfg)
+	conn, err := pgx.Connect(ctx, connStr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to postgres database: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	for _, g := range grants {
+		stmt := fmt.Sprintf("GRANT CONNECT ON DATABASE %s TO %s", quoteIdent(database), connectGrantee(g.Role))
+		if g.Grantable {
+			stmt += " WITH GRANT OPTION"
+		}
+		if _, err := conn.Exec(ctx, stmt); err != nil {
+			return fmt.Errorf("failed to grant CONNECT on %s: %w", database, err)
+		}
+	}
+
+	return nil
+}
+
+// WithConnectGrants returns the snapshot with CONNECT on the database held
+// again by the roles BlockConnections revoked it from, so that reverting to
+// it doesn't leave them locked out
+func (s OwnershipSnapshot) WithConnectGrants(grants []ConnectGrant) OwnershipSnapshot {
+	if len(grants) == 0 {
+		return s
+	}
+	objects := make([]ObjectState, len(s.Objects))
+	for i, o := range s.Objects {
+		if o.Kind == "DATABASE" {
+			o.ACL = append([]ACLEntry(nil), o.ACL...)
+			for _, g := range grants {
+				grantee := g.Role
+				if grantee == "" {
+					grantee = "PUBLIC"
+				}
+				o.ACL = append(o.ACL, ACLEntry{Grantee: grantee, Privilege: "CONNECT", Grantable: g.Grantable})
+			}
+			sortACL(o.ACL)
+		}
+		objects[i] = o
+	}
+	s.Objects = objects
+	return s
+}
+
+// listConnectGrants returns the roles other than the current user holding
+// CONNECT on a database directly
+func listConnectGrants(ctx context.Context, conn *pgx.Conn, database string) ([]ConnectGrant, error) {
+	rows, err := conn.Query(ctx, connectGrantees, database)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read privileges of database %s: %w", database, err)
+	}
+	grants, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (ConnectGrant, error) {
+		var g ConnectGrant
+		err := row.Scan(&g.Role, &g.Grantable)
+		return g, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read privileges of database %s: %w", database, err)
+	}
+	return grants, nil
+}
+
+// connectGrantee returns the role of a grant as written in GRANT and REVOKE
+func connectGrantee(role string) string {
+	if role == "" {
+		return "PUBLIC"
+	}
+	return quoteIdent(role)
+}
+
+// DrainSessions waits up to timeout for the sessions connected to a
+// database to end, calling report with the remaining sessions after every
+// check. It returns the sessions still connected when the timeout expires.
+func DrainSessions(ctx context.Context, cfg config.TargetConfig, database string, timeout time.Duration, report func([]Session)) ([]Session, error) {
+	connStr := GetTargetPostgresConnectionString(cfg)
+	conn, err := pgx.Connect(ctx, connStr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to postgres database: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	deadline := time.Now().Add(timeout)
+	for {
+		sessions, err := listSessions(ctx, conn, database)
+		if err != nil {
+			return nil, err
+		}
+		if len(sessions) == 0 || !time.Now().Before(deadline) {
+			return sessions, nil
+		}
+		if report != nil {
+			report(sessions)
+		}
+
+		wait := drainInterval
+		if remaining := time.Until(deadline); remaining < wait {
+			wait = remaining
+		}
+		select {
+		case <-ctx.Done():
+			return sessions, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
\ No newline at end of file
@@ -0,0 +1,338 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/1CL0UD/cloudm-cli/internal/config"
+	"github.com/jackc/pgx/v5"
+)
+
+// Restore strategies
+const (
+	StrategyInPlace = "in-place"
+	StrategyShadow  = "shadow"
+)
+
+// swapAttempts is how often a swap is retried when clients reconnect to a
+// database between terminating their connections and renaming it
+const swapAttempts = 5
+
+// ShadowDatabaseName returns the name of the database a shadow restore
+// writes to
+func ShadowDatabaseName(database string) string {
+	return database + "_new"
+}
+
+// RetiredDatabaseName returns the name the live database is kept under
+// after a swap
+func RetiredDatabaseName(database string, at time.Time) string {
+	return fmt.Sprintf("%s_old_%s", database, at.Format("20060102_150405"))
+}
+
+// DatabaseExists reports whether a database exists on the target server
+func DatabaseExists(ctx context.Context, cfg config.TargetConfig, database string) (bool, error) {
+	connStr := GetTargetPostgresConnectionString(cfg)
+	conn, err := pgx.Connect(ctx, connStr)
+	if err != nil {
+		return false, fmt.Errorf("failed to connect to postgres database: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	var exists bool
+	err = conn.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM pg_database WHERE datname = $1)", database).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to check database %s: %w", database, err)
+	}
+	return exists, nil
+}
+
+// CreateShadowDatabase creates an empty database with the encoding, locale,
+// privileges and database-level settings, including those of single roles,
+// of the live database. A leftover shadow database from an earlier attempt
+// is dropped first.
+func CreateShadowDatabase(ctx context.Context, cfg config.TargetConfig, shadow string) error {
+	connStr := GetTargetPostgresConnectionString(cfg)
+	conn, err := pgx.Connect(ctx, connStr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to postgres database: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	var encoding, collate, ctype string
+	err = conn.QueryRow(ctx, `
+		SELECT pg_encoding_to_char(encoding), datcollate, datctype
+		FROM pg_database
+		WHERE datname = $1`, cfg.Database).Scan(&encoding, &collate, &ctype)
+	if err != nil {
+		return fmt.Errorf("failed to read settings of database %s: %w", cfg.Database, err)
+	}
+
+	shadowIdent := quoteIdent(shadow)
+
+	if _, err := conn.Exec(ctx, `
+		SELECT pg_terminate_backend(pid)
+		FROM pg_stat_activity
+		WHERE datname = $1 AND pid <> pg_backend_pid()`, shadow); err != nil {
+		return fmt.Errorf("failed to terminate connections: %w", err)
+	}
+	if _, err := conn.Exec(ctx, "DROP DATABASE IF EXISTS "+shadowIdent); err != nil {
+		return fmt.Errorf("failed to drop database %s: %w", shadow, err)
+	}
+
+	create := fmt.Sprintf("CREATE DATABASE %s TEMPLATE template0 ENCODING %s LC_COLLATE %s LC_CTYPE %s",
+		shadowIdent, quoteLiteral(encoding), quoteLiteral(collate), quoteLiteral(ctype))
+	if _, err := conn.Exec(ctx, create); err != nil {
+		return fmt.Errorf("failed to create database %s: %w", shadow, err)
+	}
+
+	// Carry over ALTER DATABASE ... SET and ALTER ROLE ... IN DATABASE ...
+	// SET parameters, which are not part of the dump
+	rows, err := conn.Query(ctx, `
+		SELECT coalesce(r.rolname, ''), setting
+		FROM pg_db_role_setting s
+		JOIN pg_database d ON d.oid = s.setdatabase
+		LEFT JOIN pg_roles r ON r.oid = s.setrole
+		CROSS JOIN LATERAL unnest(s.setconfig) AS setting
+		WHERE d.datname = $1 AND (s.setrole = 0 OR r.oid IS NOT NULL)
+		ORDER BY s.setrole`, cfg.Database)
+	if err != nil {
+		return fmt.Errorf("failed to read settings of database %s: %w", cfg.Database, err)
+	}
+	var statements []string
+	var role, setting string
+	_, err = pgx.ForEachRow(rows, []any{&role, &setting}, func() error {
+		stmt := "ALTER DATABASE " + shadowIdent
+		if role != "" {
+			stmt = "ALTER ROLE " + quoteIdent(role) + " IN DATABASE " + shadowIdent
+		}
+		name, value, _ := strings.Cut(setting, "=")
+		statements = append(statements, stmt+" SET "+quoteIdent(name)+" = "+settingValue(name, value))
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read settings of database %s: %w", cfg.Database, err)
+	}
+	for _, stmt := range statements {
+		if _, err := conn.Exec(ctx, stmt); err != nil {
+			return fmt.Errorf("failed to copy database setting: %w", err)
+		}
+	}
+
+	return copyDatabaseACL(ctx, conn, cfg.Database, shadow)
+}
+
+// listSettings are the settings whose value is a list of quoted elements
+var listSettings = map[string]bool{
+	"local_preload_libraries":   true,
+	"search_path":               true,
+	"session_preload_libraries": true,
+	"shared_preload_libraries":  true,
+	"temp_tablespaces":          true,
+	"unix_socket_directories":   true,
+}
+
+// settingValue returns the SQL for the value of a setting as stored in
+// pg_db_role_setting. The value of a list setting is split into its
+// elements, each quoted, as pg_dumpall does, since quoting it whole would
+// make it a single element.
+func settingValue(name, value string) string {
+	if !listSettings[strings.ToLower(name)] {
+		return quoteLiteral(value)
+	}
+	elements := splitSettingList(value)
+	if len(elements) == 0 {
+		return "''"
+	}
+	quoted := make([]string, len(elements))
+	for i, e := range elements {
+		quoted[i] = quoteLiteral(e)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// splitSettingList splits the value of a list setting on commas, removing
+// the double quotes around elements and the whitespace between them
+func splitSettingList(value string) []string {
+	var elements []string
+	for i := 0; i < len(value); {
+		for i < len(value) && (value[i] == ' ' || value[i] == '\t' || value[i] == '\n') {
+			i++
+		}
+		var element strings.Builder
+		if i < len(value) && value[i] == '"' {
+			for i++; i < len(value); i++ {
+				if value[i] == '"' {
+					if i+1 < len(value) && value[i+1] == '"' {
+						i++
+					} else {
+						i++
+						break
+					}
+				}
+				element.WriteByte(value[i])
+			}
+			for i < len(value) && value[i] != ',' {
+				i++
+			}
+		} else {
+			start := i
+			for i < len(value) && value[i] != ',' {
+				i++
+			}
+			element.WriteString(strings.TrimRight(value[start:i], " \t\n"))
+		}
+		elements = append(elements, element.String())
+		if i < len(value) {
+			i++ // the comma
+		}
+	}
+	return elements
+}
+
+// copyDatabaseACL gives a database the privileges granted on another one,
+// which are not part of the dump. A database without an ACL has the default
+// privileges, like a new one; otherwise PUBLIC keeps only what it was
+// explicitly granted.
+func copyDatabaseACL(ctx context.Context, conn *pgx.Conn, from, to string) error {
+	var custom bool
+	err := conn.QueryRow(ctx, "SELECT datacl IS NOT NULL FROM pg_database WHERE datname = $1", from).Scan(&custom)
+	if err != nil {
+		return fmt.Errorf("failed to read privileges of database %s: %w", from, err)
+	}
+	if !custom {
+		return nil
+	}
+
+	rows, err := conn.Query(ctx, `
+		SELECT format('GRANT %s ON DATABASE %I TO %s%s', a.privilege_type, $2::text,
+			CASE WHEN a.grantee = 0 THEN 'PUBLIC' ELSE quote_ident(pg_get_userbyid(a.grantee)) END,
+			CASE WHEN a.is_grantable THEN ' WITH GRANT OPTION' ELSE '' END)
+		FROM pg_database d
+		CROSS JOIN LATERAL aclexplode(d.datacl) AS a
+		WHERE d.datname = $1`, from, to)
+	if err != nil {
+		return fmt.Errorf("failed to read privileges of database %s: %w", from, err)
+	}
+	grants, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return fmt.Errorf("failed to read privileges of database %s: %w", from, err)
+	}
+
+	statements := append([]string{"REVOKE ALL ON DATABASE " + quoteIdent(to) + " FROM PUBLIC"}, grants...)
+	for _, stmt := range statements {
+		if _, err := conn.Exec(ctx, stmt); err != nil {
+			return fmt.Errorf("failed to copy database privileges: %w", err)
+		}
+	}
+	return nil
+}
+
+// MissingTables returns the tables of a dump that don't exist in the target
+// database
+func MissingTables(ctx context.Context, cfg config.TargetConfig, entries []TOCEntry) ([]string, error) {
+	connStr := GetTargetConnectionString(cfg)
+	conn, err := pgx.Connect(ctx, connStr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	var missing []string
+	for _, e := range entries {
+		if e.Type != "TABLE" {
+			continue
+		}
+		var found bool
+		if err := conn.QueryRow(ctx, "SELECT to_regclass($1) IS NOT NULL", regName(e)).Scan(&found); err != nil {
+			return nil, fmt.Errorf("failed to look up table %s: %w", e.Name, err)
+		}
+		if !found {
+			missing = append(missing, quoteQualified(e.Schema, e.Name))
+		}
+	}
+	return missing, nil
+}
+
+// SwapDatabases puts the shadow database in place of the live one, which
+// is kept under the retired name. New connections to the live database are
+// refused, its sessions get up to drain to end and those left are
+// terminated, then both renames happen in one transaction. Without
+// terminate, the swap fails instead when sessions are left. It returns how
+// long the live database was unavailable.
+func SwapDatabases(ctx context.Context, cfg config.TargetConfig, shadow, retired string, drain time.Duration, terminate bool) (time.Duration, error) {
+	connStr := GetTargetPostgresConnectionString(cfg)
+	conn, err := pgx.Connect(ctx, connStr)
+	if err != nil {
+		return 0, fmt.Errorf("failed to connect to postgres database: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	live := quoteIdent(cfg.Database)
+	shadowIdent := quoteIdent(shadow)
+	retiredIdent := quoteIdent(retired)
+
+	start := time.Now()
+	if _, err := conn.Exec(ctx, fmt.Sprintf("ALTER DATABASE %s WITH ALLOW_CONNECTIONS false", live)); err != nil {
+		return 0, fmt.Errorf("failed to block connections to %s: %w", cfg.Database, err)
+	}
+
+	if drain > 0 {
+		if _, err := DrainSessions(ctx, cfg, cfg.Database, drain, nil); err != nil {
+			conn.Exec(ctx, fmt.Sprintf("ALTER DATABASE %s WITH ALLOW_CONNECTIONS true", live))
+			return time.Since(start), fmt.Errorf("failed to swap databases: %w", err)
+		}
+	}
+
+	var swapErr error
+	for attempt := 1; attempt <= swapAttempts; attempt++ {
+		if !terminate {
+			var left int
+			if err := conn.QueryRow(ctx, `
+				SELECT count(*)
+				FROM pg_stat_activity
+				WHERE datname = ANY($1) AND pid <> pg_backend_pid()`, []string{cfg.Database, shadow}).Scan(&left); err != nil {
+				swapErr = fmt.Errorf("failed to list sessions: %w", err)
+				break
+			}
+			if left > 0 {
+				swapErr = fmt.Errorf("%d sessions still connected to %s and terminate_connections is disabled", left, cfg.Database)
+				break
+			}
+		} else if _, err := conn.Exec(ctx, `
+			SELECT pg_terminate_backend(pid)
+			FROM pg_stat_activity
+			WHERE datname = ANY($1) AND pid <> pg_backend_pid()`, []string{cfg.Database, shadow}); err != nil {
+			swapErr = fmt.Errorf("failed to terminate connections: %w", err)
+			break
+		}
+
+		swapErr = pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+			if _, err := tx.Exec(ctx, fmt.Sprintf("ALTER DATABASE %s RENAME TO %s", live, retiredIdent)); err != nil {
+				return err
+			}
+			_, err := tx.Exec(ctx, fmt.Sprintf("ALTER DATABASE %s RENAME TO %s", shadowIdent, live))
+			return err
+		})
+		if swapErr == nil {
+			break
+		}
+		time.Sleep(time.Duration(attempt) * 200 * time.Millisecond)
+	}
+
+	if swapErr != nil {
+		// Reopen the live database untouched
+		conn.Exec(ctx, fmt.Sprintf("ALTER DATABASE %s WITH ALLOW_CONNECTIONS true", live))
+		return time.Since(start), fmt.Errorf("failed to swap databases: %w", swapErr)
+	}
+	downtime := time.Since(start)
+
+	// The retired database stays reachable for inspection and rollback
+	if _, err := conn.Exec(ctx, fmt.Sprintf("ALTER DATABASE %s WITH ALLOW_CONNECTIONS true", retiredIdent)); err != nil {
+		return downtime, fmt.Errorf("failed to allow connections to %s: %w", retired, err)
+	}
+
+	return downtime, nil
+}
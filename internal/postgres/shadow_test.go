@@ -0,0 +1,26 @@
+package postgres
+
+import "testing"
+
+func TestSettingValue(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{"search_path", "app, public", `'app', 'public'`},
+		{"search_path", `"$user", public`, `'$user', 'public'`},
+		{"search_path", `"My Schema","a""b"`, `'My Schema', 'a"b'`},
+		{"search_path", "", "''"},
+		{"temp_tablespaces", "fast", "'fast'"},
+		{"work_mem", "64MB", "'64MB'"},
+		{"application_name", "a, b", "'a, b'"},
+		{"statement_timeout", "it's", "'it''s'"},
+	}
+
+	for _, tt := range tests {
+		if got := settingValue(tt.name, tt.value); got != tt.want {
+			t.Errorf("settingValue(%q, %q) = %s, want %s", tt.name, tt.value, got, tt.want)
+		}
+	}
+}
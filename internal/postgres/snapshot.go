@@ -0,0 +1,547 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/1CL0UD/cloudm-cli/internal/config"
+	"github.com/jackc/pgx/v5"
+)
+
+// ACLEntry is a privilege granted on an object. The grantee is PUBLIC for
+// privileges granted to everyone.
+type ACLEntry struct {
+	Grantee   string `json:"grantee"`
+	Privilege string `json:"privilege"`
+	Grantable bool   `json:"grantable,omitempty"`
+}
+
+// ObjectState is the owner and privileges of an object
+type ObjectState struct {
+	Kind     string     `json:"kind"`
+	Identity string     `json:"identity"`
+	Owner    string     `json:"owner"`
+	ACL      []ACLEntry `json:"acl"`
+}
+
+// DefaultACLState is the default privileges of objects a role creates in a
+// schema, other than the role's own
+type DefaultACLState struct {
+	Role   string     `json:"role"`
+	Schema string     `json:"schema"`
+	Kind   string     `json:"kind"`
+	ACL    []ACLEntry `json:"acl"`
+}
+
+// OwnershipSnapshot records the owners and privileges of objects of a
+// database, so that changes to them can be reverted
+type OwnershipSnapshot struct {
+	Target            string            `json:"target"`
+	Schemas           []string          `json:"schemas"`
+	CreatedAt         time.Time         `json:"created_at"`
+	Objects           []ObjectState     `json:"objects"`
+	DefaultPrivileges []DefaultACLState `json:"default_privileges"`
+	// LargeObjects are counted by owner rather than recorded one by one
+	LargeObjects []LargeObjectOwner `json:"large_objects,omitempty"`
+	CreatedRoles []string           `json:"created_roles,omitempty"`
+}
+
+// grantKinds are the GRANT keywords of the kinds of objects that have
+// privileges
+var grantKinds = map[string]string{
+	"DATABASE":            "DATABASE",
+	"SCHEMA":              "SCHEMA",
+	"TABLE":               "TABLE",
+	"VIEW":                "TABLE",
+	"MATERIALIZED VIEW":   "TABLE",
+	"FOREIGN TABLE":       "TABLE",
+	"SEQUENCE":            "SEQUENCE",
+	"FUNCTION":            "FUNCTION",
+	"PROCEDURE":           "PROCEDURE",
+	"TYPE":                "TYPE",
+	"DOMAIN":              "DOMAIN",
+	"LARGE OBJECT":        "LARGE OBJECT",
+	"FOREIGN SERVER":      "FOREIGN SERVER",
+	"PROCEDURAL LANGUAGE": "LANGUAGE",
+}
+
+// defaultACLKeywords are the keywords of ALTER DEFAULT PRIVILEGES for the
+// object types of pg_default_acl
+var defaultACLKeywords = map[string]string{
+	"r": "TABLES",
+	"S": "SEQUENCES",
+	"f": "FUNCTIONS",
+	"T": "TYPES",
+}
+
+// objectACL builds the expression listing the privileges of an object as
+// privilege:grantable:grantee, including those an object has by default
+func objectACL(column, kind, owner string) string {
+	return fmt.Sprintf(`coalesce((
+		SELECT array_agg(a.privilege_type || ':' || a.is_grantable || ':' ||
+			CASE a.grantee WHEN 0 THEN 'PUBLIC' ELSE pg_get_userbyid(a.grantee) END)
+		FROM aclexplode(coalesce(%s, acldefault(%s, %s))) a), '{}')`, column, kind, owner)
+}
+
+// parseObjectACL parses the entries listed by objectACL
+func parseObjectACL(entries []string) []ACLEntry {
+	acl := make([]ACLEntry, 0, len(entries))
+	for _, e := range entries {
+		parts := strings.SplitN(e, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		acl = append(acl, ACLEntry{Privilege: parts[0], Grantable: parts[1] == "true", Grantee: parts[2]})
+	}
+	sortACL(acl)
+	return acl
+}
+
+// snapshotQueries list the objects whose privileges a grant on all objects
+// of a schema changes although their owner is left alone, such as
+// sequences owned by a column and objects belonging to an extension, along
+// with the database itself
+var snapshotQueries = []string{
+	`SELECT 'DATABASE', '', d.datname, quote_ident(d.datname), pg_get_userbyid(d.datdba),
+		` + objectACL("d.datacl", "'d'", "d.datdba") + `
+	FROM pg_database d
+	WHERE d.datname = current_database() AND $1::text[] IS NOT NULL`,
+
+	`SELECT CASE c.relkind
+			WHEN 'v' THEN 'VIEW'
+			WHEN 'm' THEN 'MATERIALIZED VIEW'
+			WHEN 'S' THEN 'SEQUENCE'
+			WHEN 'f' THEN 'FOREIGN TABLE'
+			ELSE 'TABLE'
+		END,
+		n.nspname, c.relname, quote_ident(n.nspname) || '.' || quote_ident(c.relname),
+		pg_get_userbyid(c.relowner),
+		` + objectACL("c.relacl", `(CASE c.relkind WHEN 'S' THEN 's' ELSE 'r' END)::"char"`, "c.relowner") + `
+	FROM pg_class c
+	JOIN pg_namespace n ON n.oid = c.relnamespace
+	WHERE n.nspname = ANY($1) AND c.relkind IN ('r', 'p', 'v', 'm', 'S', 'f')`,
+
+	`SELECT CASE p.prokind WHEN 'p' THEN 'PROCEDURE' ELSE 'FUNCTION' END,
+		n.nspname, p.proname || '(' || oidvectortypes(p.proargtypes) || ')',
+		quote_ident(n.nspname) || '.' || quote_ident(p.proname) || '(' || pg_get_function_identity_arguments(p.oid) || ')',
+		pg_get_userbyid(p.proowner), ` + objectACL("p.proacl", "'f'", "p.proowner") + `
+	FROM pg_proc p
+	JOIN pg_namespace n ON n.oid = p.pronamespace
+	WHERE n.nspname = ANY($1) AND p.prokind IN ('f', 'w', 'p')`,
+}
+
+// TakeOwnershipSnapshot records the owners and privileges of the database,
+// the given schemas and the objects in them, and the default privileges
+// set in the schemas
+func TakeOwnershipSnapshot(ctx context.Context, cfg config.TargetConfig, schemas []string) (OwnershipSnapshot, error) {
+	snapshot := OwnershipSnapshot{Target: RestoreTarget(cfg), Schemas: schemas, CreatedAt: time.Now()}
+
+	connStr := GetTargetConnectionString(cfg)
+	conn, err := pgx.Connect(ctx, connStr)
+	if err != nil {
+		return snapshot, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	objects, err := listOwnedObjects(ctx, conn, schemas)
+	if err != nil {
+		return snapshot, err
+	}
+	if snapshot.LargeObjects, err = listLargeObjectOwners(ctx, conn); err != nil {
+		return snapshot, err
+	}
+
+	seen := make(map[string]bool, len(objects))
+	for _, o := range objects {
+		seen[o.Kind+" "+o.Identity] = true
+		snapshot.Objects = append(snapshot.Objects, ObjectState{Kind: o.Kind, Identity: o.Identity, Owner: o.Owner, ACL: o.ACL})
+	}
+
+	for _, query := range snapshotQueries {
+		rows, err := conn.Query(ctx, query, schemas)
+		if err != nil {
+			return snapshot, fmt.Errorf("failed to list objects: %w", err)
+		}
+		found, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (ObjectState, error) {
+			var o ObjectState
+			var schema, name string
+			var acl []string
+			err := row.Scan(&o.Kind, &schema, &name, &o.Identity, &o.Owner, &acl)
+			o.ACL = parseObjectACL(acl)
+			return o, err
+		})
+		if err != nil {
+			return snapshot, fmt.Errorf("failed to list objects: %w", err)
+		}
+		for _, o := range found {
+			if !seen[o.Kind+" "+o.Identity] {
+				seen[o.Kind+" "+o.Identity] = true
+				snapshot.Objects = append(snapshot.Objects, o)
+			}
+		}
+	}
+
+	rows, err := conn.Query(ctx, `
+		SELECT pg_get_userbyid(d.defaclrole), n.nspname, d.defaclobjtype::text,
+			coalesce((
+				SELECT array_agg(a.privilege_type || ':' || a.is_grantable || ':' ||
+					CASE a.grantee WHEN 0 THEN 'PUBLIC' ELSE pg_get_userbyid(a.grantee) END)
+				FROM aclexplode(d.defaclacl) a
+				WHERE a.grantee <> d.defaclrole), '{}')
+		FROM pg_default_acl d
+		JOIN pg_namespace n ON n.oid = d.defaclnamespace
+		WHERE n.nspname = ANY($1)`, schemas)
+	if err != nil {
+		return snapshot, fmt.Errorf("failed to list default privileges: %w", err)
+	}
+	var state DefaultACLState
+	var code string
+	var acl []string
+	_, err = pgx.ForEachRow(rows, []any{&state.Role, &state.Schema, &code, &acl}, func() error {
+		if keyword, ok := defaultACLKeywords[code]; ok {
+			state.Kind = keyword
+			state.ACL = parseObjectACL(acl)
+			snapshot.DefaultPrivileges = append(snapshot.DefaultPrivileges, state)
+		}
+		return nil
+	})
+	if err != nil {
+		return snapshot, fmt.Errorf("failed to list default privileges: %w", err)
+	}
+
+	return snapshot, nil
+}
+
+// Changed returns the part of the snapshot whose owners or privileges
+// differ in a later snapshot
+func (s OwnershipSnapshot) Changed(later OwnershipSnapshot) OwnershipSnapshot {
+	changed := OwnershipSnapshot{Target: s.Target, Schemas: s.Schemas, CreatedAt: s.CreatedAt}
+
+	after := make(map[string]ObjectState, len(later.Objects))
+	for _, o := range later.Objects {
+		after[o.Kind+" "+o.Identity] = o
+	}
+	for _, o := range s.Objects {
+		a, ok := after[o.Kind+" "+o.Identity]
+		if ok && (a.Owner != o.Owner || !sameACL(a.ACL, o.ACL)) {
+			changed.Objects = append(changed.Objects, o)
+		}
+	}
+
+	before := make(map[string]DefaultACLState, len(s.DefaultPrivileges))
+	for _, d := range s.DefaultPrivileges {
+		before[d.Role+"\x00"+d.Schema+"\x00"+d.Kind] = d
+	}
+	for _, d := range later.DefaultPrivileges {
+		b, ok := before[d.Role+"\x00"+d.Schema+"\x00"+d.Kind]
+		if !ok {
+			b = DefaultACLState{Role: d.Role, Schema: d.Schema, Kind: d.Kind, ACL: builtinDefaultACL(d.Kind)}
+		}
+		if !sameACL(b.ACL, d.ACL) {
+			changed.DefaultPrivileges = append(changed.DefaultPrivileges, b)
+		}
+	}
+
+	if !sameLargeObjectOwners(s.LargeObjects, later.LargeObjects) {
+		changed.LargeObjects = s.LargeObjects
+	}
+
+	return changed
+}
+
+// IsEmpty reports whether the snapshot records nothing
+func (s OwnershipSnapshot) IsEmpty() bool {
+	return len(s.Objects) == 0 && len(s.DefaultPrivileges) == 0 && len(s.LargeObjects) == 0
+}
+
+// Save writes the snapshot as JSON
+func (s OwnershipSnapshot) Save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode ownership snapshot: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write ownership snapshot: %w", err)
+	}
+	return nil
+}
+
+// LoadOwnershipSnapshot reads a snapshot written by Save
+func LoadOwnershipSnapshot(path string) (OwnershipSnapshot, error) {
+	var s OwnershipSnapshot
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return s, fmt.Errorf("failed to read ownership snapshot: %w", err)
+	}
+	if err := json.Unmarshal(data, &s); err != nil {
+		return s, fmt.Errorf("failed to parse ownership snapshot %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// PlanRevert builds the plan giving the objects of a saved snapshot back
+// their owners and privileges, reading the current state of the database.
+// As in the ownership plan, a non-superuser admin acts as the roles
+// involved through temporary memberships, and what it cannot do is left
+// out and reported.
+func PlanRevert(ctx context.Context, cfg config.TargetConfig, saved OwnershipSnapshot) (OwnershipPlan, error) {
+	current, err := TakeOwnershipSnapshot(ctx, cfg, saved.Schemas)
+	if err != nil {
+		return OwnershipPlan{}, err
+	}
+
+	connStr := GetTargetConnectionString(cfg)
+	conn, err := pgx.Connect(ctx, connStr)
+	if err != nil {
+		return OwnershipPlan{}, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	admin, err := readAdminAccess(ctx, conn, saved.Schemas)
+	if err != nil {
+		return OwnershipPlan{}, err
+	}
+
+	return buildRevertPlan(saved, current, &admin), nil
+}
+
+// buildRevertPlan builds the plan giving the objects of a saved snapshot
+// back their owners and privileges, given the current state of the
+// database. Objects that no longer exist are returned as skipped.
+func buildRevertPlan(saved, current OwnershipSnapshot, admin *AdminAccess) OwnershipPlan {
+	var plan OwnershipPlan
+	add := func(stmt string) {
+		plan.Statements = append(plan.Statements, stmt)
+	}
+	managed := admin != nil && !admin.Superuser
+	plan.Managed = managed
+	members := &memberships{admin: admin, plan: &plan, add: add, granted: make(map[string]bool), denied: make(map[string]bool)}
+	impossible := func(format string, args ...any) {
+		plan.Impossible = append(plan.Impossible, fmt.Sprintf(format, args...))
+	}
+
+	objects := make(map[string]ObjectState, len(current.Objects))
+	for _, o := range current.Objects {
+		objects[o.Kind+" "+o.Identity] = o
+	}
+
+	// Owners first, since changing the owner rewrites the privileges held
+	// by the previous one
+	var aclChanges []ObjectState
+	for _, o := range saved.Objects {
+		cur, ok := objects[o.Kind+" "+o.Identity]
+		if !ok {
+			plan.Skipped = append(plan.Skipped, strings.ToLower(o.Kind)+" "+o.Identity+" (no longer exists)")
+			continue
+		}
+		name := strings.ToLower(o.Kind) + " " + o.Identity
+		if cur.Owner != o.Owner {
+			switch {
+			case o.Kind == "DATABASE" && managed && !admin.CreateDB:
+				impossible("change owner of %s back to %s (%s lacks CREATEDB)", name, o.Owner, admin.User)
+				continue
+			case !members.actAsAll(cur.Owner, o.Owner):
+				impossible("change owner of %s back to %s (%s cannot be granted membership in %s)",
+					name, o.Owner, admin.User, members.blocked(cur.Owner, o.Owner))
+				continue
+			}
+			add(alterOwnerSQL(o.Kind, o.Identity, o.Owner))
+		}
+		if _, ok := grantKinds[o.Kind]; ok && !sameACL(withOwner(cur.ACL, cur.Owner, o.Owner), o.ACL) {
+			if !members.actAs(o.Owner) {
+				impossible("restore privileges on %s (%s cannot be granted membership in %s)", name, admin.User, o.Owner)
+				continue
+			}
+			aclChanges = append(aclChanges, cur)
+		}
+	}
+
+	savedObjects := make(map[string]ObjectState, len(saved.Objects))
+	for _, o := range saved.Objects {
+		savedObjects[o.Kind+" "+o.Identity] = o
+	}
+	for _, cur := range aclChanges {
+		o := savedObjects[cur.Kind+" "+cur.Identity]
+		target := grantKinds[o.Kind] + " " + o.Identity
+		grantees := aclGrantees(cur.ACL)
+		for _, g := range aclGrantees(withOwner(cur.ACL, cur.Owner, o.Owner)) {
+			grantees = appendUnique(grantees, g)
+		}
+		for _, g := range grantees {
+			add("REVOKE ALL ON " + target + " FROM " + quoteGrantee(g) + " CASCADE")
+		}
+		for _, grant := range grantsFor(o.ACL) {
+			add("GRANT " + grant.privileges + " ON " + target + " TO " + grant.grantee)
+		}
+	}
+
+	defaults := make(map[string]DefaultACLState, len(current.DefaultPrivileges))
+	for _, d := range current.DefaultPrivileges {
+		defaults[d.Role+"\x00"+d.Schema+"\x00"+d.Kind] = d
+	}
+	for _, d := range saved.DefaultPrivileges {
+		cur, ok := defaults[d.Role+"\x00"+d.Schema+"\x00"+d.Kind]
+		if !ok {
+			cur = DefaultACLState{ACL: builtinDefaultACL(d.Kind)}
+		}
+		if sameACL(cur.ACL, d.ACL) {
+			continue
+		}
+		if !members.actAs(d.Role) {
+			impossible("restore default privileges of %s on %s in schema %s (%s cannot be granted membership in %s)",
+				d.Role, strings.ToLower(d.Kind), d.Schema, admin.User, d.Role)
+			continue
+		}
+		prefix := fmt.Sprintf("ALTER DEFAULT PRIVILEGES FOR ROLE %s IN SCHEMA %s ", quoteIdent(d.Role), quoteIdent(d.Schema))
+		for _, g := range aclGrantees(cur.ACL) {
+			add(prefix + "REVOKE ALL ON " + d.Kind + " FROM " + quoteGrantee(g))
+		}
+		for _, grant := range grantsFor(d.ACL) {
+			add(prefix + "GRANT " + grant.privileges + " ON " + d.Kind + " TO " + grant.grantee)
+		}
+	}
+
+	// Large objects can only be given back when one role owned them all
+	switch {
+	case len(saved.LargeObjects) > 1:
+		plan.Skipped = append(plan.Skipped, "large objects (owned by several roles)")
+	case len(saved.LargeObjects) == 1:
+		owner := saved.LargeObjects[0].Owner
+		for _, lo := range current.LargeObjects {
+			if lo.Owner == owner {
+				continue
+			}
+			if !members.actAsAll(lo.Owner, owner) {
+				impossible("change owner of %d large objects back to %s (%s cannot be granted membership in %s)",
+					lo.Count, owner, admin.User, members.blocked(lo.Owner, owner))
+				continue
+			}
+			plan.LargeObjects = append(plan.LargeObjects, LargeObjectChange{From: lo.Owner, To: owner, Count: lo.Count})
+		}
+	}
+
+	if managed {
+		members.revokeAll()
+	}
+
+	return plan
+}
+
+// builtinDefaultACL returns the privileges others have on new objects of a
+// kind when no default privileges are set
+func builtinDefaultACL(kind string) []ACLEntry {
+	switch kind {
+	case "FUNCTIONS":
+		return []ACLEntry{{Grantee: "PUBLIC", Privilege: "EXECUTE"}}
+	case "TYPES":
+		return []ACLEntry{{Grantee: "PUBLIC", Privilege: "USAGE"}}
+	}
+	return nil
+}
+
+// withOwner returns the privileges an object has after its owner changes,
+// which moves those held by the old owner to the new one
+func withOwner(acl []ACLEntry, from, to string) []ACLEntry {
+	if from == to {
+		return acl
+	}
+	moved := make([]ACLEntry, 0, len(acl))
+	seen := make(map[ACLEntry]bool)
+	for _, e := range acl {
+		if e.Grantee == from {
+			e.Grantee = to
+		}
+		if !seen[e] {
+			seen[e] = true
+			moved = append(moved, e)
+		}
+	}
+	sortACL(moved)
+	return moved
+}
+
+// aclGrant is a GRANT of privileges to a quoted grantee
+type aclGrant struct {
+	grantee    string
+	privileges string
+}
+
+// grantsFor groups the entries of an ACL into GRANT statements per grantee
+// and grant option
+func grantsFor(acl []ACLEntry) []aclGrant {
+	type key struct {
+		grantee   string
+		grantable bool
+	}
+	var keys []key
+	privileges := make(map[key][]string)
+	for _, e := range acl {
+		k := key{e.Grantee, e.Grantable}
+		if _, ok := privileges[k]; !ok {
+			keys = append(keys, k)
+		}
+		privileges[k] = append(privileges[k], e.Privilege)
+	}
+
+	grants := make([]aclGrant, 0, len(keys))
+	for _, k := range keys {
+		grantee := quoteGrantee(k.grantee)
+		if k.grantable {
+			grantee += " WITH GRANT OPTION"
+		}
+		grants = append(grants, aclGrant{grantee: grantee, privileges: privilegeList(privileges[k])})
+	}
+	return grants
+}
+
+// aclGrantees returns the grantees of an ACL
+func aclGrantees(acl []ACLEntry) []string {
+	var grantees []string
+	for _, e := range acl {
+		grantees = appendUnique(grantees, e.Grantee)
+	}
+	return grantees
+}
+
+// quoteGrantee quotes a grantee, leaving PUBLIC as the keyword
+func quoteGrantee(grantee string) string {
+	if grantee == "PUBLIC" {
+		return grantee
+	}
+	return quoteIdent(grantee)
+}
+
+// sameACL reports whether two sorted ACLs hold the same entries
+func sameACL(a, b []ACLEntry) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// sortACL sorts ACL entries by grantee and privilege
+func sortACL(acl []ACLEntry) {
+	sort.Slice(acl, func(i, j int) bool {
+		if acl[i].Grantee != acl[j].Grantee {
+			return acl[i].Grantee < acl[j].Grantee
+		}
+		if acl[i].Privilege != acl[j].Privilege {
+			return acl[i].Privilege < acl[j].Privilege
+		}
+		return !acl[i].Grantable && acl[j].Grantable
+	})
+}
\ No newline at end of file
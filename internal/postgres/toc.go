@@ -0,0 +1,337 @@
+package postgres
+
+import (
+	"fmt"
+	"os/exec"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/1CL0UD/cloudm-cli/internal/encryption"
+)
+
+// Dump sections as understood by pg_restore --section
+const (
+	SectionPreData  = "pre-data"
+	SectionData     = "data"
+	SectionPostData = "post-data"
+)
+
+type TOCEntry struct {
+	DumpID     int    `json:"dump_id"`
+	CatalogOID uint32 `json:"catalog_oid"`
+	ObjectOID  uint32 `json:"object_oid"`
+	Type       string `json:"type"`
+	Schema     string `json:"schema"`
+	Name       string `json:"name"`
+	Owner      string `json:"owner"`
+	Section    string `json:"section"`
+
+	Dependencies []int `json:"dependencies,omitempty"`
+}
+
+type TOCFilter struct {
+	Types   []string
+	Schema  string
+	Name    string
+	Owner   string
+	Section string
+}
+
+// tocTypes lists multi-word TOC entry types, longest first so that prefixes
+// such as "MATERIALIZED VIEW" don't shadow "MATERIALIZED VIEW DATA"
+var tocTypes = []string{
+	"PUBLICATION TABLES IN SCHEMA",
+	"TEXT SEARCH CONFIGURATION",
+	"TEXT SEARCH DICTIONARY",
+	"MATERIALIZED VIEW DATA",
+	"FOREIGN DATA WRAPPER",
+	"TEXT SEARCH TEMPLATE",
+	"DATABASE PROPERTIES",
+	"PROCEDURAL LANGUAGE",
+	"TEXT SEARCH PARSER",
+	"SEQUENCE OWNED BY",
+	"PUBLICATION TABLE",
+	"MATERIALIZED VIEW",
+	"CHECK CONSTRAINT",
+	"OPERATOR FAMILY",
+	"STATISTICS DATA",
+	"OPERATOR CLASS",
+	"FOREIGN SERVER",
+	"SECURITY LABEL",
+	"ACCESS METHOD",
+	"BLOB METADATA",
+	"EVENT TRIGGER",
+	"FK CONSTRAINT",
+	"FOREIGN TABLE",
+	"LARGE OBJECTS",
+	"INDEX ATTACH",
+	"SEQUENCE SET",
+	"TABLE ATTACH",
+	"USER MAPPING",
+	"ROW SECURITY",
+	"DEFAULT ACL",
+	"SHELL TYPE",
+	"TABLE DATA",
+	"BLOB DATA",
+}
+
+// dataTypes are TOC entry types restored in the data section
+var dataTypes = map[string]bool{
+	"TABLE DATA":    true,
+	"SEQUENCE SET":  true,
+	"BLOBS":         true,
+	"BLOB DATA":     true,
+	"LARGE OBJECTS": true,
+}
+
+// postDataTypes are TOC entry types restored in the post-data section
+var postDataTypes = map[string]bool{
+	"INDEX":                        true,
+	"INDEX ATTACH":                 true,
+	"CONSTRAINT":                   true,
+	"CHECK CONSTRAINT":             true,
+	"FK CONSTRAINT":                true,
+	"TRIGGER":                      true,
+	"EVENT TRIGGER":                true,
+	"RULE":                         true,
+	"POLICY":                       true,
+	"ROW SECURITY":                 true,
+	"MATERIALIZED VIEW DATA":       true,
+	"PUBLICATION":                  true,
+	"PUBLICATION TABLE":            true,
+	"PUBLICATION TABLES IN SCHEMA": true,
+	"SUBSCRIPTION":                 true,
+	"DEFAULT ACL":                  true,
+	"STATISTICS":                   true,
+	"STATISTICS DATA":              true,
+}
+
+// inheritedSectionTypes belong to the section of the entry preceding them
+var inheritedSectionTypes = map[string]bool{
+	"ACL":            true,
+	"COMMENT":        true,
+	"SECURITY LABEL": true,
+}
+
+// largeObjectTypes are TOC entry types describing large objects
+var largeObjectTypes = map[string]bool{
+	"BLOB":          true,
+	"BLOBS":         true,
+	"BLOB METADATA": true,
+	"BLOB DATA":     true,
+	"LARGE OBJECTS": true,
+}
+
+// ReadTOC reads and parses the table of contents of a dump file,
+// including the dependencies between entries. Encrypted dumps are decrypted
+// on the fly when a decryptor is given.
+func ReadTOC(dumpFile string, dec *encryption.Decryptor) ([]TOCEntry, error) {
+	stdin, closeInput, err := openArchive(dumpFile, dec)
+	if err != nil {
+		return nil, err
+	}
+	defer closeInput()
+
+	cmd := exec.Command("pg_restore", "-l", "-v")
+	if stdin != nil {
+		cmd.Stdin = stdin
+	} else {
+		cmd.Args = append(cmd.Args, dumpFile)
+	}
+
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get dump info: %w\nstderr: %s", err, stderr.String())
+	}
+
+	return ParseTOC(string(output))
+}
+
+// ParseTOC parses pg_restore -l output into structured entries
+func ParseTOC(listing string) ([]TOCEntry, error) {
+	var entries []TOCEntry
+	section := SectionPreData
+
+	for i, line := range strings.Split(listing, "\n") {
+		line = strings.TrimRight(line, "\r")
+
+		// Verbose listings follow each entry with its dependencies
+		comment, isComment := strings.CutPrefix(line, ";")
+		if deps, ok := strings.CutPrefix(strings.TrimSpace(comment), "depends on:"); isComment && ok && len(entries) > 0 {
+			last := &entries[len(entries)-1]
+			for _, field := range strings.Fields(deps) {
+				id, err := strconv.Atoi(field)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: invalid dependency %q", i+1, field)
+				}
+				last.Dependencies = append(last.Dependencies, id)
+			}
+			continue
+		}
+
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		entry, err := parseTOCLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+
+		if inheritedSectionTypes[entry.Type] {
+			entry.Section = section
+		} else {
+			entry.Section = sectionForType(entry.Type)
+			section = entry.Section
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// parseTOCLine parses one "id; catalog oid type schema name owner" line
+func parseTOCLine(line string) (TOCEntry, error) {
+	var entry TOCEntry
+
+	idPart, rest, ok := strings.Cut(line, ";")
+	if !ok {
+		return entry, fmt.Errorf("malformed TOC entry: %q", line)
+	}
+	id, err := strconv.Atoi(strings.TrimSpace(idPart))
+	if err != nil {
+		return entry, fmt.Errorf("invalid dump id in TOC entry: %q", line)
+	}
+	entry.DumpID = id
+
+	fields := strings.SplitN(strings.TrimLeft(rest, " "), " ", 3)
+	if len(fields) < 3 {
+		return entry, fmt.Errorf("malformed TOC entry: %q", line)
+	}
+	catalogOID, err := strconv.ParseUint(fields[0], 10, 32)
+	if err != nil {
+		return entry, fmt.Errorf("invalid catalog oid in TOC entry: %q", line)
+	}
+	objectOID, err := strconv.ParseUint(fields[1], 10, 32)
+	if err != nil {
+		return entry, fmt.Errorf("invalid object oid in TOC entry: %q", line)
+	}
+	entry.CatalogOID = uint32(catalogOID)
+	entry.ObjectOID = uint32(objectOID)
+
+	rest = fields[2]
+	for _, t := range tocTypes {
+		if strings.HasPrefix(rest, t+" ") {
+			entry.Type = t
+			break
+		}
+	}
+	if entry.Type == "" {
+		t, _, _ := strings.Cut(rest, " ")
+		entry.Type = t
+	}
+	rest = strings.TrimPrefix(rest, entry.Type+" ")
+
+	// Schema is "-" for objects that don't belong to one
+	schema, rest, ok := strings.Cut(rest, " ")
+	if !ok {
+		return entry, fmt.Errorf("malformed TOC entry: %q", line)
+	}
+	if schema != "-" {
+		entry.Schema = schema
+	}
+
+	// The name may contain spaces; the owner is the last word and is empty
+	// (leaving a trailing space) for objects without one
+	if idx := strings.LastIndex(rest, " "); idx >= 0 {
+		entry.Name = strings.TrimRight(rest[:idx], " ")
+		entry.Owner = rest[idx+1:]
+	} else {
+		entry.Name = rest
+	}
+
+	return entry, nil
+}
+
+// sectionForType returns the restore section of a TOC entry type
+func sectionForType(t string) string {
+	if dataTypes[t] {
+		return SectionData
+	}
+	if postDataTypes[t] {
+		return SectionPostData
+	}
+	return SectionPreData
+}
+
+// FilterTOC returns the entries matching all criteria of the filter.
+// Name and owner accept shell-style patterns.
+func FilterTOC(entries []TOCEntry, filter TOCFilter) []TOCEntry {
+	var result []TOCEntry
+	for _, e := range entries {
+		if filter.Matches(e) {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+// Matches reports whether an entry satisfies the filter
+func (f TOCFilter) Matches(e TOCEntry) bool {
+	if len(f.Types) > 0 {
+		found := false
+		for _, t := range f.Types {
+			if strings.EqualFold(t, e.Type) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.Schema != "" && f.Schema != e.Schema {
+		return false
+	}
+	if f.Section != "" && f.Section != e.Section {
+		return false
+	}
+	if f.Name != "" && !matchPattern(f.Name, e.Name) {
+		return false
+	}
+	if f.Owner != "" && !matchPattern(f.Owner, e.Owner) {
+		return false
+	}
+	return true
+}
+
+// CountTOCByType counts entries per type, returned in type order
+func CountTOCByType(entries []TOCEntry) ([]string, map[string]int) {
+	counts := make(map[string]int)
+	for _, e := range entries {
+		counts[e.Type]++
+	}
+
+	types := make([]string, 0, len(counts))
+	for t := range counts {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+
+	return types, counts
+}
+
+// matchPattern matches a value against a shell-style pattern
+func matchPattern(pattern, value string) bool {
+	ok, err := path.Match(pattern, value)
+	if err != nil {
+		return pattern == value
+	}
+	return ok
+}
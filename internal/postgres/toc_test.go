@@ -0,0 +1,130 @@
+package postgres
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParseTOCLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want TOCEntry
+	}{
+		{"215; 1259 16386 TABLE public orders app_owner",
+			TOCEntry{DumpID: 215, CatalogOID: 1259, ObjectOID: 16386, Type: "TABLE", Schema: "public", Name: "orders", Owner: "app_owner"}},
+		{"5; 2615 2200 SCHEMA - public pg_database_owner",
+			TOCEntry{DumpID: 5, CatalogOID: 2615, ObjectOID: 2200, Type: "SCHEMA", Name: "public", Owner: "pg_database_owner"}},
+		{"3450; 0 16386 TABLE DATA public orders app_owner",
+			TOCEntry{DumpID: 3450, ObjectOID: 16386, Type: "TABLE DATA", Schema: "public", Name: "orders", Owner: "app_owner"}},
+		{"3451; 0 16390 MATERIALIZED VIEW DATA public daily_totals app_owner",
+			TOCEntry{DumpID: 3451, ObjectOID: 16390, Type: "MATERIALIZED VIEW DATA", Schema: "public", Name: "daily_totals", Owner: "app_owner"}},
+		{"3456; 0 0 COMMENT - SCHEMA public pg_database_owner",
+			TOCEntry{DumpID: 3456, Type: "COMMENT", Name: "SCHEMA public", Owner: "pg_database_owner"}},
+		{"220; 1255 16400 FUNCTION public add(integer, integer) app_owner",
+			TOCEntry{DumpID: 220, CatalogOID: 1255, ObjectOID: 16400, Type: "FUNCTION", Schema: "public", Name: "add(integer, integer)", Owner: "app_owner"}},
+		{"3461; 2613 16500 BLOB - 16500 etl_owner",
+			TOCEntry{DumpID: 3461, CatalogOID: 2613, ObjectOID: 16500, Type: "BLOB", Name: "16500", Owner: "etl_owner"}},
+		{"3462; 0 0 BLOBS - BLOBS ",
+			TOCEntry{DumpID: 3462, Type: "BLOBS", Name: "BLOBS"}},
+		{"  7; 3079 16384 EXTENSION - pgcrypto ",
+			TOCEntry{DumpID: 7, CatalogOID: 3079, ObjectOID: 16384, Type: "EXTENSION", Name: "pgcrypto"}},
+	}
+
+	for _, tt := range tests {
+		got, err := parseTOCLine(tt.line)
+		if err != nil {
+			t.Errorf("parseTOCLine(%q) failed: %v", tt.line, err)
+			continue
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("parseTOCLine(%q) = %+v, want %+v", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestParseTOCLineInvalid(t *testing.T) {
+	tests := []string{
+		"no separator",
+		"x; 1259 16386 TABLE public orders app_owner",
+		"215; 1259",
+		"215; abc 16386 TABLE public orders app_owner",
+		"215; 1259 abc TABLE public orders app_owner",
+		"215; 1259 16386 TABLE",
+	}
+
+	for _, line := range tests {
+		if _, err := parseTOCLine(line); err == nil {
+			t.Errorf("parseTOCLine(%q) succeeded, want error", line)
+		}
+	}
+}
+
+func TestParseTOC(t *testing.T) {
+	listing := `;
+; Archive created at 2025-01-01 12:00:00 UTC
+;     dbname: app
+;     Format: CUSTOM
+;
+; Selected TOC Entries:
+;
+5; 2615 2200 SCHEMA - public pg_database_owner
+3456; 0 0 COMMENT - SCHEMA public pg_database_owner
+; depends on: 5
+215; 1259 16386 TABLE public orders app_owner
+; depends on: 5
+3458; 0 0 ACL public TABLE orders app_owner
+; depends on: 215
+3450; 0 16386 TABLE DATA public orders app_owner
+; depends on: 215
+3462; 0 0 BLOBS - BLOBS 
+3300; 2606 16390 CONSTRAINT public orders orders_pkey app_owner
+; depends on: 215 3450
+3459; 0 0 COMMENT public CONSTRAINT orders_pkey app_owner
+; depends on: 3300
+3463; 0 0 DEFAULT ACL public DEFAULT PRIVILEGES FOR TABLES app_owner
+`
+
+	want := []struct {
+		id      int
+		typ     string
+		section string
+		deps    []int
+	}{
+		{5, "SCHEMA", SectionPreData, nil},
+		{3456, "COMMENT", SectionPreData, []int{5}},
+		{215, "TABLE", SectionPreData, []int{5}},
+		{3458, "ACL", SectionPreData, []int{215}},
+		{3450, "TABLE DATA", SectionData, []int{215}},
+		{3462, "BLOBS", SectionData, nil},
+		{3300, "CONSTRAINT", SectionPostData, []int{215, 3450}},
+		{3459, "COMMENT", SectionPostData, []int{3300}},
+		{3463, "DEFAULT ACL", SectionPostData, nil},
+	}
+
+	entries, err := ParseTOC(listing)
+	if err != nil {
+		t.Fatalf("ParseTOC failed: %v", err)
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("ParseTOC returned %d entries, want %d", len(entries), len(want))
+	}
+	for i, w := range want {
+		e := entries[i]
+		if e.DumpID != w.id || e.Type != w.typ || e.Section != w.section || fmt.Sprint(e.Dependencies) != fmt.Sprint(w.deps) {
+			t.Errorf("entry %d = %d %s %s %v, want %d %s %s %v", i, e.DumpID, e.Type, e.Section, e.Dependencies, w.id, w.typ, w.section, w.deps)
+		}
+	}
+}
+
+func TestParseTOCInvalid(t *testing.T) {
+	tests := []string{
+		"215; 1259 16386 TABLE public orders app_owner\n; depends on: 5 x\n",
+		"; header\nnot an entry\n",
+	}
+
+	for _, listing := range tests {
+		if _, err := ParseTOC(listing); err == nil {
+			t.Errorf("ParseTOC(%q) succeeded, want error", listing)
+		}
+	}
+}
@@ -129,25 +129,6 @@ func TerminateConnections(ctx context.Context, cfg config.TargetConfig, database
 	return nil
 }
 
-// CreateExtensions creates PostgreSQL extensions
-func CreateExtensions(ctx context.Context, cfg config.TargetConfig, extensions []string) error {
-	connStr := GetTargetConnectionString(cfg)
-	conn, err := pgx.Connect(ctx, connStr)
-	if err != nil {
-		return fmt.Errorf("failed to connect to database: %w", err)
-	}
-	defer conn.Close(ctx)
-
-	for _, ext := range extensions {
-		_, err = conn.Exec(ctx, fmt.Sprintf("CREATE EXTENSION IF NOT EXISTS %s", ext))
-		if err != nil {
-			return fmt.Errorf("failed to create extension %s: %w", ext, err)
-		}
-	}
-
-	return nil
-}
-
 // DropSchema drops a schema and all its objects
 func DropSchema(ctx context.Context, cfg config.TargetConfig, schema string) error {
 	connStr := GetTargetConnectionString(cfg)
@@ -157,7 +138,7 @@ func DropSchema(ctx context.Context, cfg config.TargetConfig, schema string) err
 	}
 	defer conn.Close(ctx)
 
-	_, err = conn.Exec(ctx, fmt.Sprintf("DROP SCHEMA IF EXISTS %s CASCADE", schema))
+	_, err = conn.Exec(ctx, "DROP SCHEMA IF EXISTS "+quoteIdent(schema)+" CASCADE")
 	if err != nil {
 		return fmt.Errorf("failed to drop schema: %w", err)
 	}
@@ -174,7 +155,7 @@ func CreateSchema(ctx context.Context, cfg config.TargetConfig, schema string) e
 	}
 	defer conn.Close(ctx)
 
-	_, err = conn.Exec(ctx, fmt.Sprintf("CREATE SCHEMA %s", schema))
+	_, err = conn.Exec(ctx, "CREATE SCHEMA "+quoteIdent(schema))
 	if err != nil {
 		return fmt.Errorf("failed to create schema: %w", err)
 	}
@@ -182,13 +163,9 @@ func CreateSchema(ctx context.Context, cfg config.TargetConfig, schema string) e
 	return nil
 }
 
-// PrepareTarget prepares the target database for migration
-func PrepareTarget(ctx context.Context, cfg config.TargetConfig, extensions []string) error {
-	// Terminate connections
-	if err := TerminateConnections(ctx, cfg, cfg.Database); err != nil {
-		return fmt.Errorf("failed to terminate connections: %w", err)
-	}
-
+// PrepareTarget prepares the target database for migration. Sessions on
+// the target are expected to be closed already.
+func PrepareTarget(ctx context.Context, cfg config.TargetConfig, extensions []Extension) error {
 	// Drop and recreate schema
 	if err := DropSchema(ctx, cfg, "public"); err != nil {
 		return fmt.Errorf("failed to drop schema: %w", err)